@@ -3,17 +3,18 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/BurntSushi/toml"
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/nginx-proxy/docker-gen/internal/config"
 	"github.com/nginx-proxy/docker-gen/internal/generator"
+	"github.com/nginx-proxy/docker-gen/internal/logging"
+	"github.com/nginx-proxy/docker-gen/internal/template"
 )
 
 type stringslice []string
@@ -22,6 +23,9 @@ type notifyfilter map[string][]string
 var (
 	buildVersion          string
 	version               bool
+	configTest            bool
+	dryRun                bool
+	once                  bool
 	watch                 bool
 	wait                  string
 	notifyCmd             string
@@ -32,9 +36,20 @@ var (
 	onlyExposed           bool
 	onlyPublished         bool
 	includeStopped        bool
+	services              bool
+	logExcluded           bool
 	configFiles           stringslice
 	configs               config.ConfigFile
 	interval              int
+	concurrency           int
+	logFile               string
+	logLevel              string
+	logFormat             string
+	eventRetryInterval    time.Duration
+	eventRetryMaxInterval time.Duration
+	healthListen          string
+	healthWindow          time.Duration
+	metricsListen         string
 	keepBlankLines        bool
 	endpoint              string
 	swarmNodes            stringslice
@@ -74,7 +89,7 @@ Options:`)
 
 	println(`
 Arguments:
-  template - path to a template to generate
+  template - path to a template to generate, an http(s) URL, or - to read it from stdin
   dest - path to a write the template.  If not specfied, STDOUT is used`)
 
 	println(`
@@ -86,14 +101,6 @@ Environment Variables:
 	println(`For more information, see https://github.com/nginx-proxy/docker-gen`)
 }
 
-func loadConfig(file string) error {
-	_, err := toml.DecodeFile(file, &configs)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func initFlags() {
 
 	certPath := filepath.Join(os.Getenv("DOCKER_CERT_PATH"))
@@ -101,6 +108,9 @@ func initFlags() {
 		certPath = filepath.Join(os.Getenv("HOME"), ".docker")
 	}
 	flag.BoolVar(&version, "version", false, "show version")
+	flag.BoolVar(&configTest, "config-test", false, "check the configuration and templates, without contacting docker, and exit")
+	flag.BoolVar(&dryRun, "dry-run", false, "generate once, printing the output of each config to stdout instead of writing it, without notifying, and exit")
+	flag.BoolVar(&once, "once", false, "generate and notify once, without watching, and exit with a non-zero status if a notify command failed")
 	flag.BoolVar(&watch, "watch", false, "watch for container changes")
 	flag.StringVar(&wait, "wait", "", "minimum and maximum durations to wait (e.g. \"500ms:2s\") before triggering generate")
 	flag.BoolVar(&onlyExposed, "only-exposed", false, "only include containers with exposed ports")
@@ -108,6 +118,8 @@ func initFlags() {
 	flag.BoolVar(&onlyPublished, "only-published", false,
 		"only include containers with published ports (implies -only-exposed)")
 	flag.BoolVar(&includeStopped, "include-stopped", false, "include stopped containers")
+	flag.BoolVar(&services, "services", false, "render the swarm services, returned by the services function (the endpoint must be a swarm manager)")
+	flag.BoolVar(&logExcluded, "log-excluded", false, "log the containers excluded from the template and why (debugging)")
 	flag.BoolVar(&notifyOutput, "notify-output", false, "log the output(stdout/stderr) of notify command")
 	flag.StringVar(&notifyCmd, "notify", "", "run command after template is regenerated (e.g `restart xyz`)")
 	flag.StringVar(&notifyContainerID, "notify-sighup", "",
@@ -120,6 +132,18 @@ func initFlags() {
 		"container filter for notification (e.g -notify-filter name=foo). You can have multiple of these. https://docs.docker.com/engine/reference/commandline/ps/#filter")
 	flag.Var(&configFiles, "config", "config files with template directives. Config files will be merged if this option is specified multiple times.")
 	flag.IntVar(&interval, "interval", 0, "notify command interval (secs)")
+	flag.IntVar(&concurrency, "concurrency", 0, "maximum number of configs generated in parallel, notified one after the other in the configs order. Default is the number of CPUs")
+	flag.StringVar(&logLevel, "log-level", "info", "minimum level of the logged messages: debug, info, warn or error. Received events are logged at debug")
+	flag.StringVar(&logFormat, "log-format", "text", "format of the logged messages: text, prefixed with the level, or json for one JSON object per message with its level and fields")
+	flag.StringVar(&logFile, "log-file", "", "write logs to this file instead of stderr. The file is reopened on SIGUSR1")
+	flag.DurationVar(&eventRetryInterval, "event-retry-interval", 10*time.Second,
+		"delay before reconnecting to the docker events after a failure, and interval of the docker liveness checks")
+	flag.DurationVar(&eventRetryMaxInterval, "event-retry-max-interval", 0,
+		"when greater than -event-retry-interval, double the reconnection delay after each failure up to this maximum")
+	flag.StringVar(&healthListen, "health-listen", "", "serve a /healthz endpoint on this address (e.g :8080)")
+	flag.DurationVar(&healthWindow, "health-window", time.Minute,
+		"maximum age of the last successful contact with docker for /healthz to report healthy")
+	flag.StringVar(&metricsListen, "metrics-listen", "", "serve Prometheus metrics on /metrics at this address (e.g :9100)")
 	flag.BoolVar(&keepBlankLines, "keep-blank-lines", false, "keep blank lines in the output file")
 	flag.StringVar(&endpoint, "endpoint", "", "docker api endpoint (tcp|unix://..). Default unix:///var/run/docker.sock")
 	flag.Var(&swarmNodes, "swarm-node", "swarm node api endpoint (tcp|unix://..).")
@@ -133,9 +157,9 @@ func initFlags() {
 }
 
 func main() {
-	// SIGHUP is used to trigger generation but go programs call os.Exit(2) at default.
-	// Ignore the signal until the handler is registered:
-	signal.Ignore(syscall.SIGHUP)
+	// SIGHUP and SIGUSR1 are used to trigger generation, and SIGUSR2 to pause it, but go programs
+	// call os.Exit(2) at default. Ignore the signals until the handlers are registered:
+	signal.Ignore(syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
 
 	initFlags()
 
@@ -144,22 +168,37 @@ func main() {
 		return
 	}
 
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		logging.Fatalf("%s\n", err)
+	}
+	logging.SetLevel(level)
+	format, err := logging.ParseFormat(logFormat)
+	if err != nil {
+		logging.Fatalf("%s\n", err)
+	}
+	logging.SetFormat(format)
+
 	if flag.NArg() < 1 && len(configFiles) == 0 {
 		usage()
 		os.Exit(1)
 	}
 
 	if len(configFiles) > 0 {
-		for _, configFile := range configFiles {
-			err := loadConfig(configFile)
-			if err != nil {
-				log.Fatalf("Error loading config %s: %s\n", configFile, err)
-			}
+		var err error
+		if configTest {
+			// validation problems are reported along with the template ones
+			configs, err = config.ReadConfigFiles(configFiles...)
+		} else {
+			configs, err = config.LoadConfigFiles(configFiles...)
+		}
+		if err != nil {
+			logging.Fatalf("%s\n", err)
 		}
 	} else {
 		w, err := config.ParseWait(wait)
 		if err != nil {
-			log.Fatalf("Error parsing wait interval: %s\n", err)
+			logging.Fatalf("Error parsing wait interval: %s\n", err)
 		}
 		cfg := config.Config{
 			Template:         flag.Arg(0),
@@ -172,6 +211,8 @@ func main() {
 			OnlyExposed:      onlyExposed,
 			OnlyPublished:    onlyPublished,
 			IncludeStopped:   includeStopped,
+			Services:         services,
+			LogExcluded:      logExcluded,
 			Interval:         interval,
 			KeepBlankLines:   keepBlankLines,
 		}
@@ -186,29 +227,44 @@ func main() {
 			Config: []config.Config{cfg}}
 	}
 
-	all := true
-	for _, config := range configs.Config {
-		if config.IncludeStopped {
-			all = true
+	if configTest {
+		errs := template.CheckConfigs(configs)
+		for _, err := range errs {
+			logging.Errorf("%s\n", err)
 		}
+		if len(errs) > 0 {
+			logging.Fatalf("Configuration test failed with %d error(s)\n", len(errs))
+		}
+		logging.Infof("Configuration test is successful")
+		return
 	}
 
 	generator, err := generator.NewGenerator(generator.GeneratorConfig{
-		Endpoint:   endpoint,
-		SwarmNodes: swarmNodes,
-		TLSKey:     tlsKey,
-		TLSCert:    tlsCert,
-		TLSCACert:  tlsCaCert,
-		TLSVerify:  tlsVerify,
-		All:        all,
-		ConfigFile: configs,
+		Endpoint:    endpoint,
+		SwarmNodes:  swarmNodes,
+		TLSKey:      tlsKey,
+		TLSCert:     tlsCert,
+		TLSCACert:   tlsCaCert,
+		TLSVerify:   tlsVerify,
+		ConfigFile:  configs,
+		ConfigFiles: configFiles,
+		Concurrency: concurrency,
+		LogFile:     logFile,
+
+		EventRetryInterval:    eventRetryInterval,
+		EventRetryMaxInterval: eventRetryMaxInterval,
+		HealthListen:          healthListen,
+		HealthWindow:          healthWindow,
+		MetricsListen:         metricsListen,
+		DryRun:                dryRun,
+		Once:                  once,
 	})
 
 	if err != nil {
-		log.Fatalf("Error creating generator: %v", err)
+		logging.Fatalf("Error creating generator: %v", err)
 	}
 
 	if err := generator.Generate(); err != nil {
-		log.Fatalf("Error running generate: %v", err)
+		logging.Fatalf("Error running generate: %v", err)
 	}
 }
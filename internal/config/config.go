@@ -2,28 +2,171 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
+	Endpoint               string
 	Template               string
+	TemplateChecksum       string
+	LeftDelim              string
+	RightDelim             string
 	Dest                   string
+	Dests                  []string
+	CreateDestDir          bool
+	DestDirMode            string
+	FileMode               string
+	Compress               string
+	BackupCount            int
+	Uid                    *int
+	Gid                    *int
 	Watch                  bool
+	WatchEvents            []string
 	Wait                   *Wait
 	NotifyCmd              string
+	FirstRunCmd            string
+	ValidateCmd            string
+	NotifyRetries          int
+	NotifyRetryInterval    time.Duration
 	NotifyOutput           bool
 	NotifyContainers       map[string]int
+	NotifyContainersMatch  string
 	NotifyContainersFilter map[string][]string
+	ContainerFilter        map[string][]string
 	NotifyContainersSignal int
+	NotifyContainersLabel  string
 	OnlyExposed            bool
 	OnlyPublished          bool
 	IncludeStopped         bool
+	IncludeSize            bool
+	Services               bool
 	Interval               int
 	KeepBlankLines         bool
+	NormalizeJSON          bool
+	SkipUnchanged          bool
+	LogChanges             bool
+	LogExcluded            bool
+	Data                   map[string]interface{}
+}
+
+// Destinations returns the paths the template output is written to:
+// Dest followed by Dests.
+func (c *Config) Destinations() []string {
+	dests := []string{}
+	if c.Dest != "" {
+		dests = append(dests, c.Dest)
+	}
+	return append(dests, c.Dests...)
+}
+
+// DestDirPerm returns the permissions of the destination directories created
+// when CreateDestDir is set: DestDirMode, an octal mode, or 0755 by default.
+func (c *Config) DestDirPerm() (os.FileMode, error) {
+	if c.DestDirMode == "" {
+		return 0755, nil
+	}
+	mode, err := strconv.ParseUint(c.DestDirMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid destdirmode %q: must be an octal mode like 0755", c.DestDirMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// FilePerm returns the permissions of the generated files, FileMode being an
+// octal mode. It returns false when FileMode is unset, the files then keeping
+// the mode of the destination they replace.
+func (c *Config) FilePerm() (os.FileMode, bool, error) {
+	if c.FileMode == "" {
+		return 0, false, nil
+	}
+	mode, err := strconv.ParseUint(c.FileMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, false, fmt.Errorf("invalid filemode %q: must be an octal mode like 0644", c.FileMode)
+	}
+	return os.FileMode(mode), true, nil
+}
+
+// ValidateCommand returns ValidateCmd with {{ .Dest }} replaced by path, the
+// temp file holding the new contents of a destination before it is renamed
+// over it. The path is single-quoted, the command being run by the shell.
+func (c *Config) ValidateCommand(path string) (string, error) {
+	tmpl, err := template.New("validatecmd").Parse(c.ValidateCmd)
+	if err != nil {
+		return "", fmt.Errorf("invalid validatecmd %q: %w", c.ValidateCmd, err)
+	}
+	buf := new(strings.Builder)
+	quoted := "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+	if err := tmpl.Execute(buf, struct{ Dest string }{quoted}); err != nil {
+		return "", fmt.Errorf("invalid validatecmd %q: %w", c.ValidateCmd, err)
+	}
+	return buf.String(), nil
+}
+
+// CheckDelims checks that the template delimiters are either both set or
+// both unset, the template then using the standard {{ and }}.
+func (c *Config) CheckDelims() error {
+	if (c.LeftDelim == "") != (c.RightDelim == "") {
+		return fmt.Errorf("leftdelim and rightdelim must be set together, got %q and %q", c.LeftDelim, c.RightDelim)
+	}
+	return nil
+}
+
+// CheckCompress checks the compression of the generated files: "gzip", or
+// none when unset.
+func (c *Config) CheckCompress() error {
+	if c.Compress != "" && c.Compress != "gzip" {
+		return fmt.Errorf("invalid compress %q: must be gzip", c.Compress)
+	}
+	return nil
+}
+
+// CheckBackupCount checks the number of backups kept of each destination,
+// 0 disabling them.
+func (c *Config) CheckBackupCount() error {
+	if c.BackupCount < 0 {
+		return fmt.Errorf("invalid backupcount %d: must be positive", c.BackupCount)
+	}
+	return nil
+}
+
+// DefaultWatchEvents are the container events regenerating a watching config
+// without WatchEvents.
+var DefaultWatchEvents = []string{"start", "stop", "die"}
+
+// ContainerEvents are the statuses of the docker container events. The
+// health_status events carry the new status after a colon, e.g.
+// "health_status: healthy".
+var ContainerEvents = []string{
+	"attach", "commit", "copy", "create", "destroy", "detach", "die",
+	"exec_create", "exec_detach", "exec_die", "exec_start", "export",
+	"health_status", "kill", "oom", "pause", "rename", "resize", "restart",
+	"start", "stop", "top", "unpause", "update",
+}
+
+// WatchedEvents returns the container events regenerating the config:
+// WatchEvents, or DefaultWatchEvents when unset.
+func (c *Config) WatchedEvents() []string {
+	if len(c.WatchEvents) == 0 {
+		return DefaultWatchEvents
+	}
+	return c.WatchEvents
 }
 
 type ConfigFile struct {
+	// Wait, when set, debounces the events once for all the watching configs
+	// without a wait of their own, so that the containers are listed once
+	// per burst of events instead of once per config.
+	Wait   *Wait
 	Config []Config
 }
 
@@ -36,15 +179,261 @@ func (c *ConfigFile) FilterWatches() ConfigFile {
 		}
 	}
 	return ConfigFile{
+		Wait:   c.Wait,
 		Config: configWithWatches,
 	}
 }
 
+// NotifyContainerMatcher returns the function matching a container name
+// against a key of NotifyContainers, according to NotifyContainersMatch: a
+// glob, or a regular expression matching the whole name. It returns nil when
+// NotifyContainersMatch is unset, the keys being then container names or IDs.
+func (c *Config) NotifyContainerMatcher() (func(key, name string) bool, error) {
+	switch c.NotifyContainersMatch {
+	case "":
+		return nil, nil
+	case "glob":
+		for key := range c.NotifyContainers {
+			if _, err := path.Match(key, ""); err != nil {
+				return nil, fmt.Errorf("invalid notify container glob %q: %w", key, err)
+			}
+		}
+		return func(key, name string) bool {
+			matched, _ := path.Match(key, name)
+			return matched
+		}, nil
+	case "regex":
+		patterns := make(map[string]*regexp.Regexp)
+		for key := range c.NotifyContainers {
+			rx, err := regexp.Compile("^(?:" + key + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid notify container regex %q: %w", key, err)
+			}
+			patterns[key] = rx
+		}
+		return func(key, name string) bool {
+			return patterns[key].MatchString(name)
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid notifycontainersmatch %q: must be glob or regex", c.NotifyContainersMatch)
+	}
+}
+
+// IncludesStopped returns whether any config includes stopped containers
+func (c *ConfigFile) IncludesStopped() bool {
+	for _, config := range c.Config {
+		if config.IncludeStopped {
+			return true
+		}
+	}
+	return false
+}
+
+// IncludesSize returns whether any config needs the container sizes
+func (c *ConfigFile) IncludesSize() bool {
+	for _, config := range c.Config {
+		if config.IncludeSize {
+			return true
+		}
+	}
+	return false
+}
+
+// IncludesServices returns whether any config of endpoint renders the swarm
+// services, "" standing for the global endpoint
+func (c *ConfigFile) IncludesServices(endpoint string) bool {
+	for _, config := range c.Config {
+		if config.Services && config.Endpoint == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainerFilters returns the distinct container filters of the configs of
+// endpoint, "" standing for the global endpoint. It returns nil when a config
+// of endpoint has no filter, or endpoint has no config, all the containers
+// being then needed.
+func (c *ConfigFile) ContainerFilters(endpoint string) []map[string][]string {
+	filters := []map[string][]string{}
+	for _, config := range c.Config {
+		if config.Endpoint != endpoint {
+			continue
+		}
+		if len(config.ContainerFilter) == 0 {
+			return nil
+		}
+		duplicate := false
+		for _, filter := range filters {
+			if reflect.DeepEqual(filter, config.ContainerFilter) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			filters = append(filters, config.ContainerFilter)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return filters
+}
+
+// WatchedEvents returns the union of the container events watched by the
+// watching configs of endpoint, "" standing for the global endpoint.
+func (c *ConfigFile) WatchedEvents(endpoint string) map[string]bool {
+	events := make(map[string]bool)
+	for _, config := range c.Config {
+		if !config.Watch || config.Endpoint != endpoint {
+			continue
+		}
+		for _, event := range config.WatchedEvents() {
+			events[event] = true
+		}
+	}
+	return events
+}
+
+// Endpoints returns the distinct docker endpoints of the configs, in order of
+// first use, "" standing for the global endpoint.
+func (c *ConfigFile) Endpoints() []string {
+	endpoints := []string{}
+	seen := make(map[string]bool)
+	for _, config := range c.Config {
+		if !seen[config.Endpoint] {
+			seen[config.Endpoint] = true
+			endpoints = append(endpoints, config.Endpoint)
+		}
+	}
+	return endpoints
+}
+
+// Validate checks that every config has the settings required to render it.
+func (c *ConfigFile) Validate() error {
+	for i, config := range c.Config {
+		if config.Template == "" {
+			return fmt.Errorf("config #%d: template is required", i)
+		}
+		if _, err := config.DestDirPerm(); err != nil {
+			return fmt.Errorf("config #%d: %w", i, err)
+		}
+		if _, _, err := config.FilePerm(); err != nil {
+			return fmt.Errorf("config #%d: %w", i, err)
+		}
+		if _, err := config.NotifyContainerMatcher(); err != nil {
+			return fmt.Errorf("config #%d: %w", i, err)
+		}
+		if _, err := config.ValidateCommand(""); err != nil {
+			return fmt.Errorf("config #%d: %w", i, err)
+		}
+		if err := config.CheckDelims(); err != nil {
+			return fmt.Errorf("config #%d: %w", i, err)
+		}
+		if err := config.CheckCompress(); err != nil {
+			return fmt.Errorf("config #%d: %w", i, err)
+		}
+		if err := config.CheckBackupCount(); err != nil {
+			return fmt.Errorf("config #%d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// LoadConfigFiles decodes and merges the given TOML config files, in order,
+// and validates the result.
+func LoadConfigFiles(files ...string) (ConfigFile, error) {
+	configFile, err := ReadConfigFiles(files...)
+	if err != nil {
+		return ConfigFile{}, err
+	}
+	if err := configFile.Validate(); err != nil {
+		return ConfigFile{}, err
+	}
+	return configFile, nil
+}
+
+// ReadConfigFiles decodes the config files like LoadConfigFiles, without
+// validating the resulting configs. Invalid values and unknown keys are
+// reported with the index of their config.
+func ReadConfigFiles(files ...string) (ConfigFile, error) {
+	var configFile ConfigFile
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return ConfigFile{}, fmt.Errorf("error loading config %s: %w", file, err)
+		}
+		loaded, err := decodeConfigFile(string(contents), len(configFile.Config))
+		if err != nil {
+			return ConfigFile{}, fmt.Errorf("error loading config %s: %w", file, err)
+		}
+		if loaded.Wait != nil {
+			configFile.Wait = loaded.Wait
+		}
+		configFile.Config = append(configFile.Config, loaded.Config...)
+	}
+	return configFile, nil
+}
+
+// decodeConfigFile decodes a config file whose configs are numbered from
+// offset. The configs are decoded one by one, so that their errors are
+// reported with their index.
+func decodeConfigFile(contents string, offset int) (ConfigFile, error) {
+	var raw struct {
+		Wait   *Wait
+		Config []toml.Primitive
+	}
+	md, err := toml.Decode(contents, &raw)
+	if err != nil {
+		return ConfigFile{}, err
+	}
+
+	configFile := ConfigFile{Wait: raw.Wait}
+	for i, primitive := range raw.Config {
+		var keys map[string]interface{}
+		if err := md.PrimitiveDecode(primitive, &keys); err != nil {
+			return ConfigFile{}, fmt.Errorf("config #%d: %w", offset+i, err)
+		}
+		for key := range keys {
+			if !hasField(reflect.TypeOf(Config{}), key) {
+				return ConfigFile{}, fmt.Errorf("config #%d: unknown key %q", offset+i, key)
+			}
+		}
+		var config Config
+		if err := md.PrimitiveDecode(primitive, &config); err != nil {
+			return ConfigFile{}, fmt.Errorf("config #%d: %w", offset+i, err)
+		}
+		configFile.Config = append(configFile.Config, config)
+	}
+	for _, key := range md.Undecoded() {
+		if key[0] != "config" {
+			return ConfigFile{}, fmt.Errorf("unknown key %q", key.String())
+		}
+	}
+	return configFile, nil
+}
+
+// hasField returns whether the struct type t has a field named key, compared
+// case-insensitively like the TOML decoder does
+func hasField(t reflect.Type, key string) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.EqualFold(t.Field(i).Name, key) {
+			return true
+		}
+	}
+	return false
+}
+
 type Wait struct {
 	Min time.Duration
 	Max time.Duration
 }
 
+// MarshalText encodes the wait as "min:max", as read by UnmarshalText
+func (w Wait) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s:%s", w.Min, w.Max)), nil
+}
+
 func (w *Wait) UnmarshalText(text []byte) error {
 	wait, err := ParseWait(string(text))
 	if err == nil {
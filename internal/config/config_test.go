@@ -1,8 +1,13 @@
 package config
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -59,3 +64,323 @@ func TestWaitUnmarshalText(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedWait, wait)
 }
+
+func TestLoadConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.cfg")
+	second := filepath.Join(dir, "second.cfg")
+	invalid := filepath.Join(dir, "invalid.cfg")
+
+	err := os.WriteFile(first, []byte("[[config]]\ntemplate = \"foo.tmpl\"\ndest = \"foo\"\nwatch = true\nwait = \"1ms:2ms\"\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(second, []byte("[[config]]\ntemplate = \"bar.tmpl\"\nnotifyretries = 3\nnotifyretryinterval = \"2s\"\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(invalid, []byte("[[config]]\ndest = \"baz\"\n"), 0644)
+	assert.NoError(t, err)
+
+	configFile, err := LoadConfigFiles(first, second)
+	assert.NoError(t, err)
+	assert.Len(t, configFile.Config, 2)
+	assert.Equal(t, "foo.tmpl", configFile.Config[0].Template)
+	assert.Equal(t, &Wait{1000000, 2000000}, configFile.Config[0].Wait)
+	assert.Equal(t, "bar.tmpl", configFile.Config[1].Template)
+	assert.Equal(t, 3, configFile.Config[1].NotifyRetries)
+	assert.Equal(t, 2*time.Second, configFile.Config[1].NotifyRetryInterval)
+
+	_, err = LoadConfigFiles(first, invalid)
+	assert.Error(t, err)
+
+	configFile, err = ReadConfigFiles(first, invalid)
+	assert.NoError(t, err, "configs are not validated when read")
+	assert.Len(t, configFile.Config, 2)
+
+	_, err = LoadConfigFiles(filepath.Join(dir, "missing.cfg"))
+	assert.Error(t, err)
+}
+
+func TestReadConfigFilesErrors(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.cfg")
+	err := os.WriteFile(first, []byte("[[config]]\ntemplate = \"foo.tmpl\"\n"), 0644)
+	assert.NoError(t, err)
+
+	invalidValue := filepath.Join(dir, "value.cfg")
+	err = os.WriteFile(invalidValue, []byte("[[config]]\ntemplate = \"foo.tmpl\"\n\n[[config]]\ntemplate = \"bar.tmpl\"\nwatch = \"yes\"\n"), 0644)
+	assert.NoError(t, err)
+	_, err = ReadConfigFiles(invalidValue)
+	assert.ErrorContains(t, err, "config #1: toml: line 6")
+	assert.ErrorContains(t, err, "config.watch")
+	_, err = ReadConfigFiles(first, invalidValue)
+	assert.ErrorContains(t, err, "config #2: ", "configs are numbered across files")
+
+	invalidWait := filepath.Join(dir, "wait.cfg")
+	err = os.WriteFile(invalidWait, []byte("[[config]]\ntemplate = \"foo.tmpl\"\nwait = \"2s:1s\"\n"), 0644)
+	assert.NoError(t, err)
+	_, err = ReadConfigFiles(invalidWait)
+	assert.ErrorContains(t, err, "config #0: ")
+	assert.ErrorContains(t, err, "max must be larger than min")
+
+	unknown := filepath.Join(dir, "unknown.cfg")
+	err = os.WriteFile(unknown, []byte("[[config]]\ntemplate = \"foo.tmpl\"\n\n[[config]]\ntemplate = \"bar.tmpl\"\nnotifycmdd = \"reload\"\n"), 0644)
+	assert.NoError(t, err)
+	_, err = ReadConfigFiles(unknown)
+	assert.ErrorContains(t, err, `config #1: unknown key "notifycmdd"`)
+
+	unknownGlobal := filepath.Join(dir, "global.cfg")
+	err = os.WriteFile(unknownGlobal, []byte("interval = 5\n\n[[config]]\ntemplate = \"foo.tmpl\"\n"), 0644)
+	assert.NoError(t, err)
+	_, err = ReadConfigFiles(unknownGlobal)
+	assert.ErrorContains(t, err, `global.cfg: unknown key "interval"`)
+}
+
+func TestConfigFileRoundTrip(t *testing.T) {
+	uid, gid := 1000, 1001
+	configFile := ConfigFile{
+		Wait: &Wait{Min: time.Second, Max: 5 * time.Second},
+		Config: []Config{
+			{
+				Template:               "/etc/docker-gen/nginx.tmpl",
+				Dest:                   "/etc/nginx/conf.d/default.conf",
+				Dests:                  []string{"/etc/nginx/conf.d/copy.conf"},
+				FileMode:               "0640",
+				Uid:                    &uid,
+				Gid:                    &gid,
+				Watch:                  true,
+				WatchEvents:            []string{"start", "die", "health_status"},
+				Wait:                   &Wait{Min: 500 * time.Millisecond, Max: 2 * time.Second},
+				NotifyCmd:              "nginx -s reload",
+				NotifyOutput:           true,
+				NotifyRetries:          3,
+				NotifyRetryInterval:    2 * time.Second,
+				NotifyContainers:       map[string]int{"nginx": 1},
+				NotifyContainersMatch:  "glob",
+				NotifyContainersFilter: map[string][]string{"label": {"com.example.proxy"}},
+				NotifyContainersSignal: 1,
+				ContainerFilter:        map[string][]string{"label": {"com.example.app=web"}},
+				OnlyPublished:          true,
+				Interval:               60,
+				Data:                   map[string]interface{}{"prefix": "upstream"},
+			},
+			{
+				Template: "/etc/docker-gen/logrotate.tmpl",
+				Endpoint: "tcp://10.0.0.2:2375",
+				Services: true,
+			},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, toml.NewEncoder(buf).Encode(configFile))
+	file := filepath.Join(t.TempDir(), "docker-gen.cfg")
+	assert.NoError(t, os.WriteFile(file, buf.Bytes(), 0644))
+
+	decoded, err := LoadConfigFiles(file)
+	assert.NoError(t, err)
+	assert.Equal(t, configFile, decoded)
+}
+
+func TestDestinations(t *testing.T) {
+	assert.Equal(t, []string{}, (&Config{}).Destinations())
+	assert.Equal(t, []string{"foo"}, (&Config{Dest: "foo"}).Destinations())
+	assert.Equal(t, []string{"bar", "baz"}, (&Config{Dests: []string{"bar", "baz"}}).Destinations())
+	assert.Equal(t, []string{"foo", "bar"}, (&Config{Dest: "foo", Dests: []string{"bar"}}).Destinations())
+}
+
+func TestReadConfigFilesGlobalWait(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.cfg")
+	second := filepath.Join(dir, "second.cfg")
+
+	err := os.WriteFile(first, []byte("wait = \"1ms:2ms\"\n\n[[config]]\ntemplate = \"foo.tmpl\"\nwatch = true\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(second, []byte("[[config]]\ntemplate = \"bar.tmpl\"\nwatch = true\n"), 0644)
+	assert.NoError(t, err)
+
+	configFile, err := ReadConfigFiles(first, second)
+	assert.NoError(t, err)
+	assert.Equal(t, &Wait{1000000, 2000000}, configFile.Wait, "the global wait is kept when merging")
+	assert.Nil(t, configFile.Config[0].Wait)
+	assert.Equal(t, configFile.Wait, configFile.FilterWatches().Wait)
+
+	configFile, err = ReadConfigFiles(second)
+	assert.NoError(t, err)
+	assert.Nil(t, configFile.Wait)
+}
+
+func TestNotifyContainerMatcher(t *testing.T) {
+	match, err := (&Config{}).NotifyContainerMatcher()
+	assert.NoError(t, err)
+	assert.Nil(t, match, "keys are container names or IDs by default")
+
+	glob := &Config{NotifyContainersMatch: "glob", NotifyContainers: map[string]int{"web-*": 1}}
+	match, err = glob.NotifyContainerMatcher()
+	if assert.NoError(t, err) {
+		assert.True(t, match("web-*", "web-1"))
+		assert.False(t, match("web-*", "api-web-1"))
+	}
+
+	regex := &Config{NotifyContainersMatch: "regex", NotifyContainers: map[string]int{"web-[0-9]+": 1}}
+	match, err = regex.NotifyContainerMatcher()
+	if assert.NoError(t, err) {
+		assert.True(t, match("web-[0-9]+", "web-12"))
+		assert.False(t, match("web-[0-9]+", "web-12-old"), "regexes match the whole name")
+	}
+
+	_, err = (&Config{NotifyContainersMatch: "glob", NotifyContainers: map[string]int{"web-[": 1}}).NotifyContainerMatcher()
+	assert.ErrorContains(t, err, `invalid notify container glob "web-["`)
+	_, err = (&Config{NotifyContainersMatch: "regex", NotifyContainers: map[string]int{"web-(": 1}}).NotifyContainerMatcher()
+	assert.ErrorContains(t, err, `invalid notify container regex "web-("`)
+	_, err = (&Config{NotifyContainersMatch: "prefix"}).NotifyContainerMatcher()
+	assert.ErrorContains(t, err, `invalid notifycontainersmatch "prefix"`)
+}
+
+func TestIncludesStopped(t *testing.T) {
+	configFile := &ConfigFile{
+		Config: []Config{
+			{Template: "foo"},
+			{Template: "bar"},
+		},
+	}
+	assert.False(t, configFile.IncludesStopped())
+
+	configFile.Config[1].IncludeStopped = true
+	assert.True(t, configFile.IncludesStopped())
+}
+
+func TestIncludesServices(t *testing.T) {
+	configFile := &ConfigFile{
+		Config: []Config{
+			{Template: "foo"},
+			{Template: "bar", Endpoint: "tcp://10.0.0.2:2375"},
+		},
+	}
+	assert.False(t, configFile.IncludesServices(""))
+
+	configFile.Config[1].Services = true
+	assert.False(t, configFile.IncludesServices(""))
+	assert.True(t, configFile.IncludesServices("tcp://10.0.0.2:2375"))
+}
+
+func TestCheckDelims(t *testing.T) {
+	assert.NoError(t, (&Config{}).CheckDelims())
+	assert.NoError(t, (&Config{LeftDelim: "[[", RightDelim: "]]"}).CheckDelims())
+	assert.EqualError(t, (&Config{LeftDelim: "[["}).CheckDelims(), `leftdelim and rightdelim must be set together, got "[[" and ""`)
+
+	configFile := ConfigFile{Config: []Config{{Template: "foo.tmpl"}, {Template: "bar.tmpl", RightDelim: "]]"}}}
+	assert.EqualError(t, configFile.Validate(), `config #1: leftdelim and rightdelim must be set together, got "" and "]]"`)
+}
+
+func TestCheckBackupCount(t *testing.T) {
+	assert.NoError(t, (&Config{}).CheckBackupCount())
+	assert.NoError(t, (&Config{BackupCount: 3}).CheckBackupCount())
+
+	configFile := ConfigFile{Config: []Config{{Template: "foo.tmpl", BackupCount: -1}}}
+	assert.EqualError(t, configFile.Validate(), "config #0: invalid backupcount -1: must be positive")
+}
+
+func TestWatchedEvents(t *testing.T) {
+	configFile := ConfigFile{
+		Config: []Config{
+			{Watch: true},
+			{Watch: true, WatchEvents: []string{"start", "health_status"}},
+			{Watch: true, WatchEvents: []string{"destroy"}, Endpoint: "tcp://10.0.0.2:2375"},
+			{Watch: false, WatchEvents: []string{"oom"}},
+		},
+	}
+	assert.Equal(t, DefaultWatchEvents, configFile.Config[0].WatchedEvents())
+	assert.Equal(t, []string{"start", "health_status"}, configFile.Config[1].WatchedEvents())
+
+	assert.Equal(t, map[string]bool{"start": true, "stop": true, "die": true, "health_status": true}, configFile.WatchedEvents(""))
+	assert.Equal(t, map[string]bool{"destroy": true}, configFile.WatchedEvents("tcp://10.0.0.2:2375"))
+	assert.Empty(t, configFile.WatchedEvents("tcp://10.0.0.3:2375"))
+}
+
+func TestContainerFilters(t *testing.T) {
+	web := map[string][]string{"label": {"com.example.app=web"}}
+	db := map[string][]string{"label": {"com.example.app=db"}}
+	configFile := &ConfigFile{
+		Config: []Config{
+			{Template: "foo", ContainerFilter: web},
+			{Template: "bar", ContainerFilter: db},
+			{Template: "baz", ContainerFilter: map[string][]string{"label": {"com.example.app=web"}}},
+			{Template: "qux", Endpoint: "tcp://10.0.0.2:2375"},
+		},
+	}
+	assert.Equal(t, []map[string][]string{web, db}, configFile.ContainerFilters(""))
+	assert.Nil(t, configFile.ContainerFilters("tcp://10.0.0.2:2375"), "a config without filter needs all the containers")
+	assert.Nil(t, configFile.ContainerFilters("tcp://10.0.0.3:2375"))
+}
+
+func TestIncludesSize(t *testing.T) {
+	configFile := &ConfigFile{
+		Config: []Config{
+			{Template: "foo"},
+			{Template: "bar"},
+		},
+	}
+	assert.False(t, configFile.IncludesSize())
+
+	configFile.Config[0].IncludeSize = true
+	assert.True(t, configFile.IncludesSize())
+}
+
+func TestFilePerm(t *testing.T) {
+	_, ok, err := (&Config{}).FilePerm()
+	assert.NoError(t, err)
+	assert.False(t, ok, "unset filemode keeps the destination mode")
+
+	perm, ok, err := (&Config{FileMode: "0640"}).FilePerm()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, os.FileMode(0640), perm)
+
+	_, _, err = (&Config{FileMode: "0999"}).FilePerm()
+	assert.Error(t, err)
+
+	configFile := ConfigFile{Config: []Config{{Template: "foo", FileMode: "rw-r--r--"}}}
+	assert.Error(t, configFile.Validate())
+}
+
+func TestEndpoints(t *testing.T) {
+	configFile := &ConfigFile{
+		Config: []Config{
+			{Template: "foo", Endpoint: "tcp://b:2375"},
+			{Template: "bar"},
+			{Template: "baz", Endpoint: "tcp://a:2375"},
+			{Template: "qux", Endpoint: "tcp://b:2375"},
+		},
+	}
+	assert.Equal(t, []string{"tcp://b:2375", "", "tcp://a:2375"}, configFile.Endpoints())
+	assert.Equal(t, []string{}, (&ConfigFile{}).Endpoints())
+}
+
+func TestDestDirPerm(t *testing.T) {
+	for mode, expected := range map[string]os.FileMode{"": 0755, "0700": 0700, "750": 0750} {
+		perm, err := (&Config{DestDirMode: mode}).DestDirPerm()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, perm, mode)
+	}
+
+	for _, mode := range []string{"rwx", "0789", "01777"} {
+		_, err := (&Config{DestDirMode: mode}).DestDirPerm()
+		assert.Error(t, err, mode)
+	}
+
+	configFile := ConfigFile{Config: []Config{{Template: "foo", DestDirMode: "rwx"}}}
+	assert.Error(t, configFile.Validate())
+}
+
+func TestValidateCommand(t *testing.T) {
+	c := &Config{ValidateCmd: "nginx -t -c {{ .Dest }}"}
+	cmd, err := c.ValidateCommand("/etc/nginx/.default.conf.tmp")
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx -t -c '/etc/nginx/.default.conf.tmp'", cmd)
+
+	cmd, err = c.ValidateCommand("/tmp/it's $(rm -rf ~); .conf")
+	assert.NoError(t, err)
+	assert.Equal(t, `nginx -t -c '/tmp/it'\''s $(rm -rf ~); .conf'`, cmd)
+
+	c.ValidateCmd = "true {{ .Missing }}"
+	_, err = c.ValidateCommand("")
+	assert.Error(t, err)
+}
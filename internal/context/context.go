@@ -6,6 +6,7 @@ import (
 	"os"
 	"regexp"
 	"sync"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/nginx-proxy/docker-gen/internal/utils"
@@ -15,6 +16,8 @@ var (
 	mu         sync.RWMutex
 	dockerInfo Docker
 	dockerEnv  *docker.Env
+	// services holds the swarm services listed from each endpoint
+	services = make(map[string][]*RuntimeService)
 )
 
 type Context []*RuntimeContainer
@@ -29,6 +32,22 @@ func (c *Context) Docker() Docker {
 	return dockerInfo
 }
 
+// SetServices stores the swarm services listed from endpoint, "" standing for
+// the global endpoint
+func SetServices(endpoint string, s []*RuntimeService) {
+	mu.Lock()
+	defer mu.Unlock()
+	services[endpoint] = s
+}
+
+// Services returns the swarm services last listed from endpoint, "" standing
+// for the global endpoint
+func Services(endpoint string) []*RuntimeService {
+	mu.RLock()
+	defer mu.RUnlock()
+	return services[endpoint]
+}
+
 func SetServerInfo(d *docker.DockerInfo) {
 	mu.Lock()
 	defer mu.Unlock()
@@ -71,6 +90,7 @@ type Network struct {
 	MacAddress          string
 	GlobalIPv6PrefixLen int
 	IPPrefixLen         int
+	Aliases             []string
 }
 
 type Volume struct {
@@ -81,6 +101,10 @@ type Volume struct {
 
 type State struct {
 	Running bool
+	Paused  bool
+	// Health is the healthcheck status (starting, healthy or unhealthy),
+	// empty if the container has no healthcheck
+	Health string
 }
 
 type RuntimeContainer struct {
@@ -100,6 +124,28 @@ type RuntimeContainer struct {
 	IP6Global    string
 	Mounts       []Mount
 	State        State
+	Args         []string
+	Cmd          []string
+	Entrypoint   []string
+	SizeRw       int64
+	SizeRootFs   int64
+	// ExposedPorts are the ports exposed by the image or the container,
+	// published or not, as "port/proto" sorted strings
+	ExposedPorts    []string
+	PublishAllPorts bool
+	// Created and StartedAt are the creation and last start times of the
+	// container, StartedAt being zero if it never started
+	Created   time.Time
+	StartedAt time.Time
+	// RestartCount is the number of times docker restarted the container
+	// since it was created, not within a time window
+	RestartCount int
+	// MemoryLimit (in bytes), CPUShares (relative weight) and NanoCPUs
+	// (in billionths of a CPU) are the resource limits of the container,
+	// zero meaning unlimited
+	MemoryLimit int64
+	CPUShares   int64
+	NanoCPUs    int64
 }
 
 func (r *RuntimeContainer) Equals(o RuntimeContainer) bool {
@@ -116,6 +162,42 @@ func (r *RuntimeContainer) PublishedAddresses() []Address {
 	return mapped
 }
 
+// RuntimeService is a swarm service, listed from a swarm manager
+type RuntimeService struct {
+	ID     string
+	Name   string
+	Image  DockerImage
+	Labels map[string]string
+	// Mode is "replicated" or "global"
+	Mode string
+	// Replicas is the number of tasks the service asks for: its replicas in
+	// replicated mode, and its running tasks in global mode
+	Replicas     uint64
+	RunningTasks int
+	Ports        []ServicePort
+}
+
+// ServicePort is a port of a swarm service, published on every swarm node in
+// ingress mode or on the nodes running its tasks in host mode
+type ServicePort struct {
+	Name          string
+	Proto         string
+	TargetPort    uint32
+	PublishedPort uint32
+	PublishMode   string
+}
+
+// PublishedPorts returns the ports of the service published on the nodes
+func (s *RuntimeService) PublishedPorts() []ServicePort {
+	published := []ServicePort{}
+	for _, port := range s.Ports {
+		if port.PublishedPort != 0 {
+			published = append(published, port)
+		}
+	}
+	return published
+}
+
 type DockerImage struct {
 	Registry   string
 	Repository string
@@ -160,6 +242,11 @@ type Docker struct {
 	CurrentContainerID string
 }
 
+// APIVersion returns the API version of the docker daemon
+func (d Docker) APIVersion() string {
+	return d.ApiVersion
+}
+
 // GetCurrentContainerID attempts to extract the current container ID from the provided file paths.
 // If no files paths are provided, it will default to /proc/1/cpuset, /proc/self/cgroup and /proc/self/mountinfo.
 // It attempts to match the HOSTNAME first then use the fallback method, and returns with the first valid match.
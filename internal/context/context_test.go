@@ -198,3 +198,20 @@ func TestDockerImageString(t *testing.T) {
 	image.Registry = ""
 	assert.Equal(t, "foo/bar:qux", image.String())
 }
+
+func TestServices(t *testing.T) {
+	services := []*RuntimeService{{ID: "1", Name: "web"}}
+	SetServices("tcp://manager:2375", services)
+	defer SetServices("tcp://manager:2375", nil)
+
+	assert.Equal(t, services, Services("tcp://manager:2375"))
+	assert.Nil(t, Services(""))
+}
+
+func TestPublishedPorts(t *testing.T) {
+	service := RuntimeService{Ports: []ServicePort{
+		{Proto: "tcp", TargetPort: 80, PublishedPort: 8080, PublishMode: "ingress"},
+		{Proto: "tcp", TargetPort: 9000},
+	}}
+	assert.Equal(t, []ServicePort{{Proto: "tcp", TargetPort: 80, PublishedPort: 8080, PublishMode: "ingress"}}, service.PublishedPorts())
+}
@@ -3,36 +3,84 @@ package dockerclient
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/nginx-proxy/docker-gen/internal/logging"
 	"github.com/nginx-proxy/docker-gen/internal/utils"
 )
 
+const (
+	defaultUnixSocket = "unix:///var/run/docker.sock"
+	defaultNamedPipe  = "npipe:////./pipe/docker_engine"
+)
+
+// endpointForms lists the accepted endpoints in the errors of GetEndpoint
+const endpointForms = "expected unix:///path/to/socket, npipe:////./pipe/name, tcp://host:port, https://host:port or a socket path"
+
+// GetEndpoint returns the docker endpoint to connect to: endpoint, or
+// DOCKER_HOST, or the default unix socket. It is normalized to a URL: a bare
+// socket path is a unix endpoint and a bare host:port a tcp one.
 func GetEndpoint(endpoint string) (string, error) {
-	defaultEndpoint := "unix:///var/run/docker.sock"
-	if os.Getenv("DOCKER_HOST") != "" {
-		defaultEndpoint = os.Getenv("DOCKER_HOST")
+	if endpoint == "" {
+		endpoint = os.Getenv("DOCKER_HOST")
 	}
+	endpoint = strings.TrimSpace(endpoint)
 
-	if endpoint != "" {
-		defaultEndpoint = endpoint
+	switch {
+	case endpoint == "", endpoint == "unix://":
+		return defaultUnixSocket, nil
+	case strings.HasPrefix(endpoint, "/"):
+		return "unix://" + endpoint, nil
+	case strings.HasPrefix(endpoint, "unix://"):
+		if !strings.HasPrefix(strings.TrimPrefix(endpoint, "unix://"), "/") {
+			return "", fmt.Errorf("invalid docker endpoint %s: the socket path must be absolute, %s", endpoint, endpointForms)
+		}
+		return endpoint, nil
+	case endpoint == "npipe://":
+		return defaultNamedPipe, nil
+	case strings.HasPrefix(endpoint, "npipe://"):
+		return endpoint, nil
+	case strings.HasPrefix(endpoint, "fd://"):
+		// only the daemon listens on inherited sockets, clients cannot dial them
+		return "", fmt.Errorf("invalid docker endpoint %s: fd:// endpoints are not supported, %s", endpoint, endpointForms)
+	case strings.HasPrefix(endpoint, "http://"), strings.HasPrefix(endpoint, "https://"):
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return "", fmt.Errorf("invalid docker endpoint %s: missing host, %s", endpoint, endpointForms)
+		}
+		return endpoint, nil
 	}
 
-	_, _, err := parseHost(defaultEndpoint)
+	proto, host, err := parseHost(endpoint)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("invalid docker endpoint %s: %s, %s", endpoint, err, endpointForms)
 	}
+	return proto + "://" + host, nil
+}
 
-	return defaultEndpoint, nil
+// isTLSCapable returns whether TLS can be used to reach endpoint, a tcp or
+// https one, unlike local sockets and named pipes
+func isTLSCapable(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "tcp://") || strings.HasPrefix(endpoint, "https://")
 }
 
+// NewDockerClient returns a client of endpoint, as returned by GetEndpoint.
+// TLS is used when requested with tlsVerify or when any of the certificate
+// files exists, and only on tcp and https endpoints: local sockets and named
+// pipes are reached without TLS, with a warning if TLS verification was
+// requested, as DOCKER_TLS_VERIFY may be set for other endpoints.
 func NewDockerClient(endpoint string, tlsVerify bool, tlsCert, tlsCaCert, tlsKey string) (*docker.Client, error) {
-	if strings.HasPrefix(endpoint, "unix:") {
+	if !isTLSCapable(endpoint) {
+		if tlsVerify {
+			logging.Warnf("TLS verification was requested, but %s is not a tcp:// or https:// endpoint: connecting without TLS", endpoint)
+		}
 		return docker.NewClient(endpoint)
-	} else if tlsVerify || tlsEnabled(tlsCert, tlsCaCert, tlsKey) {
+	}
+	if tlsVerify || tlsEnabled(tlsCert, tlsCaCert, tlsKey) {
 		if tlsVerify {
 			if e, err := utils.PathExists(tlsCaCert); !e || err != nil {
 				return nil, errors.New("TLS verification was requested, but CA cert does not exist")
@@ -41,6 +89,9 @@ func NewDockerClient(endpoint string, tlsVerify bool, tlsCert, tlsCaCert, tlsKey
 
 		return docker.NewTLSClient(endpoint, tlsCert, tlsKey, tlsCaCert)
 	}
+	if strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("%s is an https endpoint, but none of the TLS certificates %s, %s and %s exists: set -tlscert, -tlskey and -tlscacert or DOCKER_CERT_PATH", endpoint, tlsCert, tlsKey, tlsCaCert)
+	}
 	return docker.NewClient(endpoint)
 }
 
@@ -118,6 +169,43 @@ func parseHost(addr string) (string, string, error) {
 	return proto, fmt.Sprintf("%s:%d", host, port), nil
 }
 
+var signals = map[string]docker.Signal{
+	"ABRT":   docker.SIGABRT,
+	"ALRM":   docker.SIGALRM,
+	"CONT":   docker.SIGCONT,
+	"HUP":    docker.SIGHUP,
+	"INT":    docker.SIGINT,
+	"KILL":   docker.SIGKILL,
+	"QUIT":   docker.SIGQUIT,
+	"STOP":   docker.SIGSTOP,
+	"TERM":   docker.SIGTERM,
+	"USR1":   docker.SIGUSR1,
+	"USR2":   docker.SIGUSR2,
+	"WINCH":  docker.SIGWINCH,
+	"TSTP":   docker.SIGTSTP,
+	"PWR":    docker.SIGPWR,
+	"IO":     docker.SIGIO,
+	"URG":    docker.SIGURG,
+	"TTIN":   docker.SIGTTIN,
+	"TTOU":   docker.SIGTTOU,
+	"PROF":   docker.SIGPROF,
+	"VTALRM": docker.SIGVTALRM,
+}
+
+// ParseSignal converts a signal number (e.g. "1", or "-1" for a restart)
+// or a signal name (e.g. "SIGHUP" or "hup") to a signal number.
+func ParseSignal(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, nil
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	if signal, ok := signals[name]; ok {
+		return int(signal), nil
+	}
+	return 0, fmt.Errorf("invalid signal: %s", s)
+}
+
 func SplitDockerImage(img string) (string, string, string) {
 	index := 0
 	repository := img
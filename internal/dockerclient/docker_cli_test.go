@@ -2,7 +2,10 @@ package dockerclient
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/nginx-proxy/docker-gen/internal/context"
@@ -65,6 +68,80 @@ func TestUnixBadFormat(t *testing.T) {
 	}
 }
 
+func TestGetEndpointForms(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint   string
+		dockerHost string
+		expected   string
+		err        string
+	}{
+		{"", "", "unix:///var/run/docker.sock", ""},
+		{"", "tcp://127.0.0.1:4243", "tcp://127.0.0.1:4243", ""},
+		{"", "/run/user/1000/docker.sock", "unix:///run/user/1000/docker.sock", ""},
+		{"unix:///tmp/docker.sock", "tcp://127.0.0.1:4243", "unix:///tmp/docker.sock", ""},
+		{"unix://", "", "unix:///var/run/docker.sock", ""},
+		{"/var/run/docker.sock", "", "unix:///var/run/docker.sock", ""},
+		{"npipe:////./pipe/docker_engine", "", "npipe:////./pipe/docker_engine", ""},
+		{"npipe://", "", "npipe:////./pipe/docker_engine", ""},
+		{"tcp://10.0.0.2:2375", "", "tcp://10.0.0.2:2375", ""},
+		{"tcp://:2375", "", "tcp://127.0.0.1:2375", ""},
+		{"10.0.0.2:2376", "", "tcp://10.0.0.2:2376", ""},
+		{" tcp://docker:2375 ", "", "tcp://docker:2375", ""},
+		{"https://docker.example.com:2376", "", "https://docker.example.com:2376", ""},
+		{"unix:/var/run/docker.sock", "", "", "invalid docker endpoint unix:/var/run/docker.sock"},
+		{"unix://var/run/docker.sock", "", "", "the socket path must be absolute"},
+		{"tcp://10.0.0.2", "", "", "invalid docker endpoint tcp://10.0.0.2"},
+		{"tcp://", "", "", "invalid docker endpoint tcp://"},
+		{"https://", "", "", "missing host"},
+		{"ssh://docker", "", "", "invalid bind address protocol"},
+		{"fd://", "", "", "fd:// endpoints are not supported"},
+		{"fd://3", "", "", "fd:// endpoints are not supported"},
+	} {
+		t.Run(tc.endpoint+"|"+tc.dockerHost, func(t *testing.T) {
+			t.Setenv("DOCKER_HOST", tc.dockerHost)
+			endpoint, err := GetEndpoint(tc.endpoint)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, endpoint)
+		})
+	}
+}
+
+func TestNewDockerClientTLS(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	for _, tc := range []struct {
+		endpoint  string
+		tlsVerify bool
+		err       string
+	}{
+		{"unix:///var/run/docker.sock", false, ""},
+		{"tcp://127.0.0.1:2375", false, ""},
+		{"unix:///var/run/docker.sock", true, ""},
+		{"npipe:////./pipe/docker_engine", true, ""},
+		{"tcp://127.0.0.1:2376", true, "CA cert does not exist"},
+		{"https://127.0.0.1:2376", false, "none of the TLS certificates"},
+	} {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			_, err := NewDockerClient(tc.endpoint, tc.tlsVerify, missing, missing, missing)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	_, err := NewDockerClient("unix:///var/run/docker.sock", true, missing, missing, missing)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "is not a tcp:// or https:// endpoint: connecting without TLS")
+}
+
 func TestSplitDockerImageRepository(t *testing.T) {
 	registry, repository, tag := SplitDockerImage("ubuntu")
 
@@ -246,3 +323,25 @@ func TestTlsEnabled(t *testing.T) {
 	tls = tlsEnabled(filepaths["cert"], filepaths["caCert"], filepaths["key"])
 	assert.True(t, tls)
 }
+
+func TestParseSignal(t *testing.T) {
+	valid := map[string]int{
+		"1":       1,
+		"-1":      -1,
+		"15":      15,
+		"SIGHUP":  1,
+		"HUP":     1,
+		"sigusr1": 10,
+		" TERM ":  15,
+	}
+	for input, expected := range valid {
+		signal, err := ParseSignal(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, signal, input)
+	}
+
+	for _, input := range []string{"", "SIGFOO", "reload"} {
+		_, err := ParseSignal(input)
+		assert.Error(t, err, input)
+	}
+}
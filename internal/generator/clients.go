@@ -0,0 +1,64 @@
+package generator
+
+import (
+	"fmt"
+	"sync"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/nginx-proxy/docker-gen/internal/dockerclient"
+	"github.com/nginx-proxy/docker-gen/internal/logging"
+)
+
+// clientPool holds one docker client per endpoint, shared by the container
+// listings, the notifications and the event listeners, so that each daemon
+// is reached through a single connection pool and TLS configuration.
+type clientPool struct {
+	tlsVerify                  bool
+	tlsCert, tlsCaCert, tlsKey string
+
+	mu      sync.Mutex
+	clients map[string]*docker.Client
+}
+
+func newClientPool(tlsVerify bool, tlsCert, tlsCaCert, tlsKey string) *clientPool {
+	return &clientPool{
+		tlsVerify: tlsVerify,
+		tlsCert:   tlsCert,
+		tlsCaCert: tlsCaCert,
+		tlsKey:    tlsKey,
+		clients:   make(map[string]*docker.Client),
+	}
+}
+
+// get returns the client of endpoint, created on first use or after a reset
+func (p *clientPool) get(endpoint string) (*docker.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if client, ok := p.clients[endpoint]; ok {
+		return client, nil
+	}
+	resolved, err := dockerclient.GetEndpoint(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("bad endpoint: %s", err)
+	}
+	client, err := dockerclient.NewDockerClient(resolved, p.tlsVerify, p.tlsCert, p.tlsCaCert, p.tlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create docker client: %s", err)
+	}
+	p.clients[endpoint] = client
+	return client, nil
+}
+
+// reset drops the client of endpoint after a connection failure, so that the
+// next get reconnects. A client already replaced by another caller is kept.
+func (p *clientPool) reset(endpoint string, client *docker.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if current, ok := p.clients[endpoint]; ok && current == client {
+		delete(p.clients, endpoint)
+		dockerReconnectionsTotal.Inc()
+		logging.Infof("Docker client of %s reset, reconnecting on next use", endpoint)
+	}
+}
@@ -0,0 +1,59 @@
+package generator
+
+import (
+	"io"
+	"log"
+	"sync"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientPool(t *testing.T) {
+	log.SetOutput(io.Discard)
+	pool := newClientPool(false, "", "", "")
+
+	client, err := pool.get("tcp://127.0.0.1:2375")
+	assert.NoError(t, err)
+	cached, err := pool.get("tcp://127.0.0.1:2375")
+	assert.NoError(t, err)
+	assert.Same(t, client, cached, "clients are shared")
+	other, err := pool.get("tcp://127.0.0.2:2375")
+	assert.NoError(t, err)
+	assert.NotSame(t, client, other)
+
+	_, err = pool.get("udp://127.0.0.1:2375")
+	assert.ErrorContains(t, err, "bad endpoint")
+
+	reconnections := testutil.ToFloat64(dockerReconnectionsTotal)
+	pool.reset("tcp://127.0.0.1:2375", client)
+	fresh, err := pool.get("tcp://127.0.0.1:2375")
+	assert.NoError(t, err)
+	assert.NotSame(t, client, fresh, "a reset client is recreated")
+
+	pool.reset("tcp://127.0.0.1:2375", client)
+	current, err := pool.get("tcp://127.0.0.1:2375")
+	assert.NoError(t, err)
+	assert.Same(t, fresh, current, "a stale reset keeps the new client")
+	assert.Equal(t, reconnections+1, testutil.ToFloat64(dockerReconnectionsTotal))
+}
+
+func TestClientPoolConcurrentGet(t *testing.T) {
+	pool := newClientPool(false, "", "", "")
+
+	var wg sync.WaitGroup
+	clients := make([]*docker.Client, 10)
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i], _ = pool.get("tcp://127.0.0.1:2375")
+		}(i)
+	}
+	wg.Wait()
+	for _, client := range clients {
+		assert.Same(t, clients[0], client)
+	}
+}
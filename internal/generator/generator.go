@@ -1,13 +1,23 @@
 package generator
 
 import (
+	gocontext "context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -15,22 +25,80 @@ import (
 	"github.com/nginx-proxy/docker-gen/internal/config"
 	"github.com/nginx-proxy/docker-gen/internal/context"
 	"github.com/nginx-proxy/docker-gen/internal/dockerclient"
+	"github.com/nginx-proxy/docker-gen/internal/logging"
 	"github.com/nginx-proxy/docker-gen/internal/template"
 	"github.com/nginx-proxy/docker-gen/internal/utils"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type generator struct {
-	Client                     *docker.Client
-	SwarmClients               []*docker.Client
-	Configs                    config.ConfigFile
-	Endpoint                   string
-	SwarmNodes                 []string
-	TLSVerify                  bool
-	TLSCert, TLSCaCert, TLSKey string
-	All                        bool
+	Configs     config.ConfigFile
+	Endpoint    string
+	SwarmNodes  []string
+	All         bool
+	ConfigFiles []string
+	Concurrency int
+
+	// clients holds the docker clients of the global endpoint, the swarm
+	// nodes and the config endpoints
+	clients *clientPool
 
 	wg    sync.WaitGroup
 	retry bool
+
+	// initialAttempts is the number of attempts of the initial generation
+	// in daemon mode, the first retry waiting initialBackoff, doubling after
+	initialAttempts int
+	initialBackoff  time.Duration
+
+	// done is closed on SIGTERM and SIGINT, interrupting the waits between
+	// the attempts of the notify commands
+	done <-chan struct{}
+
+	mu sync.RWMutex
+
+	// intervals holds the cancel of the interval watcher of each config, by
+	// watcherKey, and events the pipeline of the event watchers, so that a
+	// reload only starts and stops the watchers of the configs it changes
+	watchMu   sync.Mutex
+	intervals map[string]gocontext.CancelFunc
+	events    *eventPipeline
+
+	// paused is toggled by SIGUSR2. While paused, events are consumed but
+	// nothing is generated nor notified.
+	paused atomic.Bool
+
+	// rendered holds the containers last rendered by each config, by configKey
+	renderedMu sync.Mutex
+	rendered   map[string][]*context.RuntimeContainer
+
+	// ran holds the configs generated at least once, for FirstRunCmd
+	ranMu sync.Mutex
+	ran   map[string]bool
+
+	// apiVersion is the API version of the docker daemon, nil if unknown
+	apiVersion docker.APIVersion
+
+	// eventRetryInterval is the delay before reconnecting to the events of a
+	// docker daemon, doubled after each failed attempt up to
+	// eventRetryMaxInterval, and the interval of its liveness pings
+	eventRetryInterval    time.Duration
+	eventRetryMaxInterval time.Duration
+
+	// health tracks the last successful contact with docker, served on
+	// healthListen when set
+	health       *health
+	healthListen string
+
+	// metricsListen is the address of the /metrics endpoint, if any
+	metricsListen string
+
+	// dryRun prints the configs once instead of generating them
+	dryRun bool
+	// once generates the configs once, reporting the failures of their
+	// notify commands, counted in notifyFailures
+	once           bool
+	notifyFailures atomic.Int32
 }
 
 type GeneratorConfig struct {
@@ -41,30 +109,88 @@ type GeneratorConfig struct {
 	TLSKey    string
 	TLSCACert string
 	TLSVerify bool
-	All       bool
+	// All lists the stopped containers too. It only affects the listing:
+	// each config still renders the running containers only, unless it sets
+	// IncludeStopped.
+	All bool
 
 	ConfigFile config.ConfigFile
+	// ConfigFiles are the paths the ConfigFile was loaded from. When set,
+	// SIGHUP re-reads them before regenerating, and docker-gen keeps running
+	// to handle it.
+	ConfigFiles []string
+	// Concurrency caps the number of configs generated in parallel.
+	// Zero means the number of CPUs.
+	Concurrency int
+	// LogFile is the file the logs are written to instead of stderr. It is
+	// reopened on SIGUSR1 to cooperate with external log rotation.
+	LogFile string
+	// EventRetryInterval is the delay before reconnecting to the events of a
+	// docker daemon, and the interval of its liveness pings. Zero means 10s.
+	EventRetryInterval time.Duration
+	// EventRetryMaxInterval, when greater than EventRetryInterval, enables an
+	// exponential backoff: the delay doubles after each failed reconnection,
+	// up to EventRetryMaxInterval.
+	EventRetryMaxInterval time.Duration
+	// HealthListen is the address of the /healthz endpoint, disabled if empty.
+	HealthListen string
+	// HealthWindow is how recent the last successful container listing or
+	// docker liveness check must be for /healthz to succeed. Zero means 1m.
+	HealthWindow time.Duration
+	// MetricsListen is the address of the Prometheus /metrics endpoint,
+	// disabled if empty.
+	MetricsListen string
+	// DryRun generates the configs once, printing their output to stdout
+	// instead of writing their destinations, without notifying.
+	DryRun bool
+	// Once generates and notifies the configs once, without watching events,
+	// generating at intervals nor handling signals. Generate then returns an
+	// error if the containers could not be listed or a notify command failed.
+	Once bool
 }
 
 func NewGenerator(gc GeneratorConfig) (*generator, error) {
-	endpoint, err := dockerclient.GetEndpoint(gc.Endpoint)
-	if err != nil {
-		return nil, fmt.Errorf("bad endpoint: %s", err)
+	if gc.LogFile != "" {
+		logFile, err := openLogFile(gc.LogFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open log file: %s", err)
+		}
+		log.SetOutput(logFile)
+		logFile.reopenOnSignal()
 	}
 
-	client, err := dockerclient.NewDockerClient(endpoint, gc.TLSVerify, gc.TLSCert, gc.TLSCACert, gc.TLSKey)
+	clients := newClientPool(gc.TLSVerify, gc.TLSCert, gc.TLSCACert, gc.TLSKey)
+	client, err := clients.get(gc.Endpoint)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create docker client: %s", err)
+		return nil, err
 	}
 
 	apiVersion, err := client.Version()
 	if err != nil {
-		log.Printf("Error retrieving docker server version info: %s\n", err)
+		logging.Errorf("Error retrieving docker server version info: %s\n", err)
 	}
 
 	// Grab the docker daemon info once and hold onto it
 	context.SetDockerEnv(apiVersion)
 
+	var daemonAPIVersion docker.APIVersion
+	if apiVersion != nil {
+		daemonAPIVersion, err = docker.NewAPIVersion(apiVersion.Get("ApiVersion"))
+		if err != nil {
+			logging.Errorf("Error parsing docker API version: %s\n", err)
+		}
+	}
+
+	eventRetryInterval := gc.EventRetryInterval
+	if eventRetryInterval <= 0 {
+		eventRetryInterval = 10 * time.Second
+	}
+
+	healthWindow := gc.HealthWindow
+	if healthWindow <= 0 {
+		healthWindow = time.Minute
+	}
+
 	var swarmNodes []string
 	if len(gc.SwarmNodes) == 0 {
 		swarmNodes = append(swarmNodes, gc.Endpoint)
@@ -72,55 +198,223 @@ func NewGenerator(gc GeneratorConfig) (*generator, error) {
 		swarmNodes = append(swarmNodes, gc.SwarmNodes...)
 	}
 
-	var swarmClients []*docker.Client
-	for _, swarmNodeEndpoint := range swarmNodes {
-		swarmNodeEndpoint, err := dockerclient.GetEndpoint(swarmNodeEndpoint)
-		if err != nil {
-			return nil, fmt.Errorf("bad endpoint: %s", err)
-		}
-		client, err := dockerclient.NewDockerClient(swarmNodeEndpoint, gc.TLSVerify, gc.TLSCert, gc.TLSCACert, gc.TLSKey)
-		if err != nil {
-			return nil, fmt.Errorf("unable to create docker client: %s", err)
+	// the swarm nodes are checked at startup, a swarm node being the global
+	// endpoint sharing its client
+	for _, swarmNode := range swarmNodes {
+		if _, err := clients.get(swarmNode); err != nil {
+			return nil, err
 		}
-		swarmClients = append(swarmClients, client)
 	}
 
 	return &generator{
-		Client:       client,
-		Endpoint:     gc.Endpoint,
-		SwarmNodes:   swarmNodes,
-		SwarmClients: swarmClients,
-		TLSVerify:    gc.TLSVerify,
-		TLSCert:      gc.TLSCert,
-		TLSCaCert:    gc.TLSCACert,
-		TLSKey:       gc.TLSKey,
-		All:          gc.All,
-		Configs:      gc.ConfigFile,
-		retry:        true,
+		Endpoint:        gc.Endpoint,
+		SwarmNodes:      swarmNodes,
+		clients:         clients,
+		All:             gc.All,
+		Configs:         gc.ConfigFile,
+		ConfigFiles:     gc.ConfigFiles,
+		Concurrency:     gc.Concurrency,
+		retry:           true,
+		initialAttempts: 5,
+		initialBackoff:  time.Second,
+		rendered:        make(map[string][]*context.RuntimeContainer),
+		ran:             make(map[string]bool),
+		apiVersion:      daemonAPIVersion,
+
+		eventRetryInterval:    eventRetryInterval,
+		eventRetryMaxInterval: gc.EventRetryMaxInterval,
+		health:                &health{window: healthWindow},
+		healthListen:          gc.HealthListen,
+		metricsListen:         gc.MetricsListen,
+		dryRun:                gc.DryRun,
+		once:                  gc.Once,
 	}, nil
 }
 
 func (g *generator) Generate() error {
-	g.generateFromContainers()
-	g.generateAtInterval()
-	g.generateFromEvents()
-	g.generateFromSignals()
+	// SIGTERM and SIGINT cancel ctx, stopping the retries and every watcher
+	ctx, cancel := signal.NotifyContext(gocontext.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	g.done = ctx.Done()
+
+	if err := g.fetchRemoteTemplates(ctx); err != nil {
+		return err
+	}
+	if g.dryRun {
+		// a single run, without watching events or generating at intervals
+		return g.generateFromContainers()
+	}
+	if g.once {
+		return g.generateOnce()
+	}
+	if g.healthListen != "" {
+		server, err := startServer(g.healthListen, "/healthz", g.health)
+		if err != nil {
+			return fmt.Errorf("unable to serve health endpoint: %s", err)
+		}
+		// the watchers return on SIGTERM, and the server stops with them
+		defer shutdownServer(server)
+	}
+	if g.metricsListen != "" {
+		server, err := startServer(g.metricsListen, "/metrics", promhttp.Handler())
+		if err != nil {
+			return fmt.Errorf("unable to serve metrics endpoint: %s", err)
+		}
+		defer shutdownServer(server)
+	}
+	g.pauseOnSignal(ctx)
+	g.generateInitial(ctx)
+	g.generateAtInterval(ctx)
+	g.generateFromEvents(ctx)
+	g.generateFromSignals(ctx)
 	g.wg.Wait()
 
 	return nil
 }
 
-func (g *generator) generateFromSignals() {
-	var hasWatcher bool
-	for _, config := range g.Configs.Config {
-		if config.Watch {
-			hasWatcher = true
-			break
+// generateOnce generates and notifies the configs once, and returns an error
+// if the containers could not be listed, a config could not be generated or a
+// notify command failed
+func (g *generator) generateOnce() error {
+	if err := g.generateFromContainers(); err != nil {
+		return fmt.Errorf("unable to generate: %w", err)
+	}
+	if failures := g.notifyFailures.Load(); failures > 0 {
+		return fmt.Errorf("%d notify command(s) failed", failures)
+	}
+	return nil
+}
+
+// generateInitial runs the initial generation. In daemon mode (when a config
+// watches for events or generates at an interval), failures are retried with
+// an exponential backoff so that the files exist as soon as possible, until
+// ctx is canceled.
+func (g *generator) generateInitial(ctx gocontext.Context) {
+	daemon := isDaemon(g.configs())
+	backoff := g.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := g.generateFromContainers()
+		if err == nil || !daemon || attempt >= g.initialAttempts {
+			return
+		}
+		logging.Warnf("Initial generation failed (attempt %d/%d), retrying in %s", attempt, g.initialAttempts, backoff)
+		if !sleep(ctx.Done(), backoff) {
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// fetchRemoteTemplates fetches the templates given as URLs at startup,
+// retrying failures with an exponential backoff until ctx is canceled
+func (g *generator) fetchRemoteTemplates(ctx gocontext.Context) error {
+	backoff := g.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := template.FetchRemoteTemplates(g.configs())
+		if err == nil {
+			return nil
+		}
+		if attempt >= g.initialAttempts {
+			return fmt.Errorf("unable to fetch remote templates: %w", err)
+		}
+		logging.Errorf("Error fetching remote templates (attempt %d/%d), retrying in %s: %s", attempt, g.initialAttempts, backoff, err)
+		if !sleep(ctx.Done(), backoff) {
+			return fmt.Errorf("unable to fetch remote templates: %w", err)
 		}
+		backoff *= 2
 	}
+}
+
+// sleep waits for d, and returns false if done is closed first
+func sleep(done <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
 
-	// If none of the configs need to watch for events, don't watch for signals either
-	if !hasWatcher {
+// pauseOnSignal toggles the pause of the generation every time docker-gen
+// receives SIGUSR2. A generation runs when the generation is resumed.
+func (g *generator) pauseOnSignal(ctx gocontext.Context) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sigChan)
+		for {
+			select {
+			case <-sigChan:
+				g.togglePause()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (g *generator) togglePause() {
+	if !g.paused.Load() {
+		g.paused.Store(true)
+		logging.Infof("Generation paused, send SIGUSR2 again to resume")
+	} else {
+		g.paused.Store(false)
+		logging.Infof("Generation resumed")
+		g.generateFromContainers()
+	}
+}
+
+// configs returns the currently active configuration
+func (g *generator) configs() config.ConfigFile {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.Configs
+}
+
+// isDaemon returns whether a config watches for events or generates at an
+// interval, docker-gen then running until it is stopped
+func isDaemon(configs config.ConfigFile) bool {
+	for _, cfg := range configs.Config {
+		if cfg.Watch || cfg.Interval > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadConfigs re-reads the config files, and if they are valid, starts the
+// watchers of the configs they add and stops the ones of the configs they
+// remove, the watchers of the unchanged configs keep running. An invalid
+// configuration is rejected and the running one is kept.
+func (g *generator) reloadConfigs(ctx gocontext.Context) {
+	configs, err := config.LoadConfigFiles(g.ConfigFiles...)
+	if err != nil {
+		logging.Errorf("Error reloading config, keeping current configuration: %s\n", err)
+		return
+	}
+	if err := template.FetchRemoteTemplates(configs); err != nil {
+		logging.Errorf("Error reloading config, keeping current configuration: %s\n", err)
+		return
+	}
+	logging.Infof("Reloaded configuration from %s", strings.Join(g.ConfigFiles, ", "))
+
+	g.mu.Lock()
+	g.Configs = configs
+	g.mu.Unlock()
+
+	g.generateFromContainers()
+	g.generateAtInterval(ctx)
+	g.generateFromEvents(ctx)
+}
+
+// generateFromSignals regenerates the configs on SIGUSR1, and reloads them on
+// SIGHUP, until ctx is canceled. Signals are handled when docker-gen runs as a
+// daemon, or when it was configured from config files, as a reload may add
+// watching configs.
+func (g *generator) generateFromSignals(ctx gocontext.Context) {
+	if !isDaemon(g.configs()) && len(g.ConfigFiles) == 0 {
 		return
 	}
 
@@ -131,85 +425,378 @@ func (g *generator) generateFromSignals() {
 		sigChan, cleanup := newSignalChannel()
 		defer cleanup()
 		for {
-			sig := <-sigChan
-			log.Printf("Received signal: %s\n", sig)
+			var sig os.Signal
+			select {
+			case sig = <-sigChan:
+			case <-ctx.Done():
+				return
+			}
+			logging.Infof("Received signal: %s\n", sig)
 			switch sig {
 			case syscall.SIGHUP:
+				if len(g.ConfigFiles) > 0 {
+					g.reloadConfigs(ctx)
+				} else {
+					if err := template.FetchRemoteTemplates(g.configs()); err != nil {
+						logging.Errorf("Error fetching remote templates, keeping the previous ones: %s\n", err)
+					}
+					g.generateFromContainers()
+				}
+			case syscall.SIGUSR1:
+				// forces a regeneration, without reloading the configuration.
+				// SIGUSR1 also reopens the log file (see reopenOnSignal) and
+				// SIGUSR2 pauses the generation (see pauseOnSignal).
 				g.generateFromContainers()
-			case syscall.SIGTERM, syscall.SIGINT:
-				// exit when context is done
-				return
 			}
 		}
 	}()
 }
 
-func (g *generator) generateFromContainers() {
-	containers, err := g.getContainers()
-	if err != nil {
-		log.Printf("Error listing containers: %s\n", err)
-		return
+func (g *generator) generateFromContainers() error {
+	if g.paused.Load() {
+		logging.Infof("Generation is paused. Skipping generation")
+		return nil
 	}
-	for _, config := range g.Configs.Config {
-		changed := template.GenerateFile(config, containers)
-		if !changed {
-			log.Printf("Contents of %s did not change. Skipping notification '%s'", config.Dest, config.NotifyCmd)
+	// the containers of each endpoint are listed once for all its configs, and
+	// the configs of an endpoint that cannot be listed are skipped
+	configs := g.configs()
+	containersByEndpoint := make(map[string][]*context.RuntimeContainer)
+	var errs []error
+	for _, endpoint := range configs.Endpoints() {
+		containers, err := g.getContainers(config.Config{Endpoint: endpoint})
+		if err != nil {
+			if endpoint == "" {
+				endpoint = g.Endpoint
+			}
+			logging.Errorf("Error listing containers of %s, skipping its configs: %s\n", endpoint, err)
+			errs = append(errs, err)
 			continue
 		}
-		g.runNotifyCmd(config)
-		g.sendSignalToContainer(config)
-		g.sendSignalToContainers(config)
+		containersByEndpoint[endpoint] = containers
+	}
+
+	selected := []config.Config{}
+	for _, cfg := range configs.Config {
+		if _, ok := containersByEndpoint[cfg.Endpoint]; ok {
+			selected = append(selected, cfg)
+		}
+	}
+	if err := g.generateConfigs(selected, func(cfg config.Config) []*context.RuntimeContainer {
+		return containersByEndpoint[cfg.Endpoint]
+	}); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// generation is the outcome of the rendering of a config
+type generation struct {
+	containers []*context.RuntimeContainer
+	changed    bool
+	count      int
+	err        error
+}
+
+// generateConfigs generates the configs with their containers, up to
+// g.Concurrency of them in parallel so that a slow template does not delay
+// the other configs, then notifies the changed ones one after the other, in
+// the configs order. It returns the errors of the configs that could not be
+// generated.
+func (g *generator) generateConfigs(configs []config.Config, containers func(config.Config) []*context.RuntimeContainer) error {
+	if g.dryRun {
+		// one after the other, so that the outputs follow the configs order
+		var errs []error
+		for _, cfg := range configs {
+			if err := template.PrintFile(cfg, containers(cfg)); err != nil {
+				logging.Errorf("%s", err)
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	workers := g.Concurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	generations := make([]generation, len(configs))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+	for i, cfg := range configs {
+		wg.Add(1)
+		go func(i int, cfg config.Config) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			generations[i].containers = containers(cfg)
+			generations[i].changed, generations[i].count, generations[i].err = template.GenerateFileCount(cfg, generations[i].containers)
+		}(i, cfg)
 	}
+	wg.Wait()
+
+	var errs []error
+	for i, cfg := range configs {
+		gen := generations[i]
+		if gen.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cfg.Template, gen.err))
+		}
+		g.notifyGeneration(cfg, gen)
+	}
+	return errors.Join(errs...)
 }
 
-func (g *generator) generateAtInterval() {
-	for _, cfg := range g.Configs.Config {
+// generateConfig generates cfg, and notifies it if its output changed
+func (g *generator) generateConfig(cfg config.Config, containers []*context.RuntimeContainer) error {
+	gen := generation{containers: containers}
+	gen.changed, gen.count, gen.err = template.GenerateFileCount(cfg, containers)
+	g.notifyGeneration(cfg, gen)
+	return gen.err
+}
+
+// notifyGeneration records the generation of cfg, and notifies it if its
+// output changed or if it is its first run. A template that could not be
+// rendered is only recorded as a failure, and not notified.
+func (g *generator) notifyGeneration(cfg config.Config, gen generation) {
+	if gen.err != nil {
+		recordGenerationFailure(cfg)
+	}
+	if errors.Is(gen.err, template.ErrRender) {
+		return
+	}
+	changed, containers, count := gen.changed, gen.containers, gen.count
+	recordGeneration(changed)
+	g.logContainerChanges(cfg, containers, changed)
+	first := g.firstRun(cfg)
+	if !changed && !(first && cfg.FirstRunCmd != "") {
+		logging.WithFields(logging.Fields{"dest": cfg.Dest}).Infof("Contents of %s did not change. Skipping notification '%s'", cfg.Dest, cfg.NotifyCmd)
+		return
+	}
+	g.runNotifyCmd(cfg, first, notifyEnv(cfg, changed, count)...)
+	g.sendSignalToContainer(cfg)
+	g.sendSignalToContainers(cfg)
+	g.sendSignalToLabeledContainers(cfg)
+}
+
+// generateAtInterval generates the configs having an interval, until ctx is
+// canceled. Called again after a reload, it starts the watchers of the added
+// configs and stops the ones of the removed configs, the others keep their
+// ticker.
+func (g *generator) generateAtInterval(ctx gocontext.Context) {
+	g.watchMu.Lock()
+	defer g.watchMu.Unlock()
+
+	running := make(map[string]gocontext.CancelFunc)
+	seen := make(map[string]int)
+	for _, cfg := range g.configs().Config {
 
 		if cfg.Interval == 0 {
 			continue
 		}
 
-		log.Printf("Generating every %d seconds", cfg.Interval)
+		key := watcherKey(seen, cfg)
+		if cancel, ok := g.intervals[key]; ok {
+			running[key] = cancel
+			delete(g.intervals, key)
+			continue
+		}
+		ctx, cancel := gocontext.WithCancel(ctx)
+		running[key] = cancel
+
+		logging.Infof("Generating every %d seconds", cfg.Interval)
 		g.wg.Add(1)
 		ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
 		go func(cfg config.Config) {
 			defer g.wg.Done()
+			defer ticker.Stop()
 
-			sigChan, cleanup := newSignalChannel()
-			defer cleanup()
 			for {
 				select {
 				case <-ticker.C:
-					containers, err := g.getContainers()
+					if g.paused.Load() {
+						logging.Infof("Generation is paused. Skipping generation of %s", cfg.Dest)
+						continue
+					}
+					containers, err := g.getContainers(cfg)
 					if err != nil {
-						log.Printf("Error listing containers: %s\n", err)
+						logging.Errorf("Error listing containers: %s\n", err)
+						continue
+					}
+					// ignore changed return value. always run notify command,
+					// unless the template could not be rendered
+					changed, count, err := template.GenerateFileCount(cfg, containers)
+					if err != nil {
+						recordGenerationFailure(cfg)
+					}
+					if errors.Is(err, template.ErrRender) {
 						continue
 					}
-					// ignore changed return value. always run notify command
-					template.GenerateFile(cfg, containers)
-					g.runNotifyCmd(cfg)
+					recordGeneration(changed)
+					g.logContainerChanges(cfg, containers, changed)
+					g.runNotifyCmd(cfg, g.firstRun(cfg), notifyEnv(cfg, changed, count)...)
 					g.sendSignalToContainer(cfg)
 					g.sendSignalToContainers(cfg)
-				case sig := <-sigChan:
-					log.Printf("Received signal: %s\n", sig)
-					switch sig {
-					case syscall.SIGTERM, syscall.SIGINT:
-						ticker.Stop()
-						return
-					}
+					g.sendSignalToLabeledContainers(cfg)
+				case <-ctx.Done():
+					return
 				}
 			}
 		}(cfg)
 	}
+
+	// the configs removed by a reload
+	for _, cancel := range g.intervals {
+		cancel()
+	}
+	g.intervals = running
+}
+
+// generateFromEvents generates the watching configs on docker events, until
+// ctx is canceled or, without retry, the connection to docker is interrupted.
+// Called again after a reload, it starts the watchers and listeners of the
+// added configs and stops the ones of the removed configs, the others keep
+// running.
+func (g *generator) generateFromEvents(ctx gocontext.Context) {
+	current := g.configs()
+	configs := current.FilterWatches()
+
+	g.watchMu.Lock()
+	defer g.watchMu.Unlock()
+	p := g.events
+	if p == nil || p.ctx.Err() != nil {
+		if len(configs.Config) == 0 {
+			return
+		}
+		p = g.dispatchEvents(ctx)
+		g.events = p
+	}
+
+	warnUnknownEvents(configs)
+	g.updateEventWatchers(p, configs)
+	g.updateEventListeners(p, configs)
+}
+
+// eventPipeline dispatches the docker events received by the listeners to
+// the event watchers of the running configuration
+type eventPipeline struct {
+	// ctx stops the pipeline, with the first listener that gives up
+	ctx       gocontext.Context
+	cancel    gocontext.CancelFunc
+	eventChan chan endpointEvent
+
+	// listeners holds the cancel of each listener, by listenerKey
+	listeners map[string]gocontext.CancelFunc
+
+	// watchers holds the watchers, by watcherKey, closed once the pipeline
+	// stops
+	mu       sync.Mutex
+	watchers map[string]eventWatcher
+	closed   bool
+}
+
+// dispatchEvents starts the pipeline forwarding the events of its listeners
+// to the watchers of their endpoint watching them, until ctx is canceled
+func (g *generator) dispatchEvents(ctx gocontext.Context) *eventPipeline {
+	ctx, cancel := gocontext.WithCancel(ctx)
+	p := &eventPipeline{
+		ctx:       ctx,
+		cancel:    cancel,
+		eventChan: make(chan endpointEvent, 100),
+		listeners: make(map[string]gocontext.CancelFunc),
+		watchers:  make(map[string]eventWatcher),
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer cancel()
+		defer p.closeWatchers()
+
+		for {
+			select {
+			case event := <-p.eventChan:
+				if event.event == nil {
+					g.generateFromContainers()
+					continue
+				}
+				if !p.dispatch(event) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// dispatch fans event out to the watchers of its endpoint watching it. It
+// returns false if the pipeline stopped meanwhile.
+func (p *eventPipeline) dispatch(event endpointEvent) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, watcher := range p.watchers {
+		if watcher.endpoint != event.endpoint || !watcher.watches(event.event) {
+			continue
+		}
+		select {
+		case watcher.ch <- event.event:
+		case <-p.ctx.Done():
+			return false
+		}
+	}
+	return true
 }
 
-func (g *generator) generateFromEvents() {
-	configs := g.Configs.FilterWatches()
-	if len(configs.Config) == 0 {
+// addWatcher adds the watcher of key, closing it right away if the pipeline
+// already stopped
+func (p *eventPipeline) addWatcher(key string, watcher eventWatcher) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		close(watcher.ch)
 		return
 	}
+	p.watchers[key] = watcher
+}
+
+// hasWatcher returns whether the watcher of key is running
+func (p *eventPipeline) hasWatcher(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.watchers[key]
+	return ok
+}
+
+// removeWatchers closes and removes the watchers that are not wanted anymore
+func (p *eventPipeline) removeWatchers(wanted map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, watcher := range p.watchers {
+		if !wanted[key] {
+			close(watcher.ch)
+			delete(p.watchers, key)
+		}
+	}
+}
+
+// closeWatchers closes every watcher, once the pipeline stopped
+func (p *eventPipeline) closeWatchers() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, watcher := range p.watchers {
+		close(watcher.ch)
+		delete(p.watchers, key)
+	}
+	p.closed = true
+}
 
-	var watchers []chan *docker.APIEvents
+// updateEventWatchers starts the watchers of the watching configs that are not
+// running yet, and stops the ones of the configs that were removed
+func (g *generator) updateEventWatchers(p *eventPipeline, configs config.ConfigFile) {
+	wanted := make(map[string]bool)
+	seen := make(map[string]int)
+	// configs sharing the global wait, by endpoint
+	shared := make(map[string][]config.Config)
 
 	for _, cfg := range configs.Config {
 
@@ -217,157 +804,557 @@ func (g *generator) generateFromEvents() {
 			continue
 		}
 
+		if configs.Wait != nil && cfg.Wait == nil {
+			shared[cfg.Endpoint] = append(shared[cfg.Endpoint], cfg)
+			continue
+		}
+
+		key := watcherKey(seen, cfg)
+		wanted[key] = true
+		if p.hasWatcher(key) {
+			continue
+		}
+
 		g.wg.Add(1)
 		watcher := make(chan *docker.APIEvents, 100)
-		watchers = append(watchers, watcher)
-
 		go func(cfg config.Config) {
 			defer g.wg.Done()
+			var signature string
 			debouncedChan := newDebounceChannel(watcher, cfg.Wait)
 			for range debouncedChan {
-				containers, err := g.getContainers()
-				if err != nil {
-					log.Printf("Error listing containers: %s\n", err)
-					continue
-				}
-				changed := template.GenerateFile(cfg, containers)
-				if !changed {
-					log.Printf("Contents of %s did not change. Skipping notification '%s'", cfg.Dest, cfg.NotifyCmd)
-					continue
-				}
-				g.runNotifyCmd(cfg)
-				g.sendSignalToContainer(cfg)
-				g.sendSignalToContainers(cfg)
+				g.generateFromEvent(cfg, &signature)
 			}
 		}(cfg)
+		p.addWatcher(key, newEventWatcher(watcher, cfg))
 	}
 
-	eventChan := make(chan *docker.APIEvents, 100)
-	done := make(chan bool)
-	clientDone := make(chan bool)
+	// the configs sharing the global wait are debounced once, and generated
+	// together from a single listing of the containers
+	for _, cfgs := range shared {
+		key := watcherKey(seen, struct {
+			Wait    *config.Wait
+			Configs []config.Config
+		}{configs.Wait, cfgs})
+		wanted[key] = true
+		if p.hasWatcher(key) {
+			continue
+		}
 
-	for _, endpoint := range g.SwarmNodes {
-		go func(endpoint string) {
-			var client *docker.Client
-			var listenerChan chan *docker.APIEvents
-			for {
-				if client == nil {
-					var err error
-					endpoint, err := dockerclient.GetEndpoint(endpoint)
-					if err != nil {
-						log.Printf("Bad endpoint: %s", err)
-						clientDone <- true
-						return
-					}
-					client, err = dockerclient.NewDockerClient(endpoint, g.TLSVerify, g.TLSCert, g.TLSCaCert, g.TLSKey)
-					if err != nil {
-						log.Printf("Unable to connect to docker daemon: %s", err)
-						time.Sleep(10 * time.Second)
-						continue
-					}
-					listenerChan = make(chan *docker.APIEvents, 100)
-					err = client.AddEventListener(listenerChan)
-					if err != nil && err != docker.ErrListenerAlreadyExists {
-						log.Printf("Error registering docker event listener: %s", err)
-						client = nil
-						listenerChan = nil
-						time.Sleep(10 * time.Second)
-						continue
-					}
-					log.Println("Watching docker events")
-					// sync all configs after resuming listener
-					eventChan <- nil
-				}
-				select {
-				case event, ok := <-listenerChan:
-					if !ok {
-						log.Printf("Docker daemon connection interrupted")
-						client.RemoveEventListener(listenerChan)
-						client = nil
-						listenerChan = nil
-						if !g.retry {
-							clientDone <- true
-							return
-						}
-						time.Sleep(10 * time.Second)
-					}
-					if event.Status == "start" || event.Status == "stop" || event.Status == "die" {
-						log.Printf("Received event %s for container %s", event.Status, event.ID[:12])
-						// fanout event to all watchers
-						eventChan <- event
-					}
-				case <-time.After(10 * time.Second):
-					// check for docker liveness
-					err := client.Ping()
-					if err != nil {
-						log.Printf("Unable to ping docker daemon: %s", err)
-						client.RemoveEventListener(listenerChan)
-						client = nil
-						listenerChan = nil
-					}
-				case <-done:
-					log.Printf("Done signal received")
-					client.RemoveEventListener(listenerChan)
-					client = nil
-					listenerChan = nil
-					return
-				}
+		g.wg.Add(1)
+		watcher := make(chan *docker.APIEvents, 100)
+		go func(cfgs []config.Config, wait *config.Wait) {
+			defer g.wg.Done()
+			signatures := make([]*string, len(cfgs))
+			for i := range signatures {
+				signatures[i] = new(string)
 			}
-		}(endpoint)
+			debouncedChan := newDebounceChannel(watcher, wait)
+			for range debouncedChan {
+				g.generateFromSettledEvents(cfgs, signatures)
+			}
+		}(cfgs, configs.Wait)
+		p.addWatcher(key, newEventWatcher(watcher, cfgs...))
 	}
 
-	go func() {
-		sigChan, cleanup := newSignalChannel()
-		defer cleanup()
-		defer close(done)
-		defer func() {
-			for _, watcher := range watchers {
-				close(watcher)
+	p.removeWatchers(wanted)
+}
+
+// updateEventListeners starts the listeners of the endpoints of the watching
+// configs that are not running yet, and stops the ones that are not needed
+// anymore. Events are listened to on the endpoints of the watching configs
+// only, the global endpoint meaning every swarm node.
+func (g *generator) updateEventListeners(p *eventPipeline, configs config.ConfigFile) {
+	wanted := make(map[string]bool)
+	for _, key := range configs.Endpoints() {
+		nodes := []string{key}
+		if key == "" {
+			nodes = g.SwarmNodes
+		}
+		events := configs.WatchedEvents(key)
+		for _, node := range nodes {
+			id := listenerKey(key, node, events)
+			wanted[id] = true
+			if _, ok := p.listeners[id]; ok {
+				continue
 			}
-		}()
 
-		for {
-			select {
-			case event := <-eventChan:
-				if event == nil {
-					g.generateFromContainers()
-					continue
+			ctx, cancel := gocontext.WithCancel(p.ctx)
+			p.listeners[id] = cancel
+			g.wg.Add(1)
+			go func(key, node string) {
+				defer g.wg.Done()
+				// the pipeline stops with the first listener that gives up
+				if !g.listenEvents(ctx, key, node, events, p.eventChan) {
+					p.cancel()
 				}
-				// fanout event to all watchers
-				for _, watcher := range watchers {
-					watcher <- event
+			}(key, node)
+		}
+	}
+
+	for id, cancel := range p.listeners {
+		if !wanted[id] {
+			cancel()
+			delete(p.listeners, id)
+		}
+	}
+}
+
+// watcherKey returns the key of the watcher of v, a config or the configs
+// sharing the global wait, made of all their settings so that a reload keeps
+// the watchers of the configs it does not change. seen counts the keys
+// already returned, identical configs having watchers of their own.
+func watcherKey(seen map[string]int, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%#v", v))
+	}
+	key := string(data)
+	seen[key]++
+	return key + "\x00" + strconv.Itoa(seen[key])
+}
+
+// listenerKey returns the key of the listener of the events of node, a node
+// of the config endpoint key
+func listenerKey(key, node string, events map[string]bool) string {
+	names := make([]string, 0, len(events))
+	for event := range events {
+		names = append(names, event)
+	}
+	sort.Strings(names)
+	return key + "\x00" + node + "\x00" + strings.Join(names, ",")
+}
+
+// eventWatcher receives the docker events regenerating one or several configs
+// sharing a wait: the service events when one of them renders the swarm
+// services, and the container events they watch.
+type eventWatcher struct {
+	endpoint string
+	events   map[string]bool
+	services bool
+	ch       chan *docker.APIEvents
+}
+
+func newEventWatcher(ch chan *docker.APIEvents, cfgs ...config.Config) eventWatcher {
+	watcher := eventWatcher{events: make(map[string]bool), ch: ch}
+	for _, cfg := range cfgs {
+		watcher.endpoint = cfg.Endpoint
+		for _, event := range cfg.WatchedEvents() {
+			watcher.events[event] = true
+		}
+		watcher.services = watcher.services || cfg.Services
+	}
+	return watcher
+}
+
+func (w eventWatcher) watches(event *docker.APIEvents) bool {
+	if event.Type == "service" {
+		return w.services
+	}
+	return w.events[eventName(event.Status)]
+}
+
+// eventName returns the name of the container event of the given status,
+// without the new status carried by the health_status events
+func eventName(status string) string {
+	name, _, _ := strings.Cut(status, ":")
+	return name
+}
+
+// warnUnknownEvents logs once each watched event that is not a docker
+// container event, which would never regenerate its configs
+func warnUnknownEvents(configs config.ConfigFile) {
+	known := make(map[string]bool)
+	for _, event := range config.ContainerEvents {
+		known[event] = true
+	}
+	for _, cfg := range configs.Config {
+		for _, event := range cfg.WatchEvents {
+			if !known[event] {
+				logging.Warnf("Unknown docker event '%s' in watchevents of template %s", event, cfg.Template)
+				known[event] = true
+			}
+		}
+	}
+}
+
+// endpointEvent is a docker event received from the daemon of a config
+// endpoint, "" standing for the global endpoint. A nil event requests the
+// generation of every config.
+type endpointEvent struct {
+	endpoint string
+	event    *docker.APIEvents
+}
+
+// listenEvents forwards the service events and the given container events of
+// the docker daemon at node, a node of the config endpoint key, to eventChan,
+// reconnecting when the connection is interrupted, until ctx is canceled. It
+// returns false when it gives up on the node: a bad endpoint, or an
+// interrupted connection without retry.
+func (g *generator) listenEvents(ctx gocontext.Context, key, node string, events map[string]bool, eventChan chan<- endpointEvent) bool {
+	var client *docker.Client
+	var listenerChan chan *docker.APIEvents
+	// the listener is reported unhealthy while it is disconnected
+	listener := listenerKey(key, node, events)
+	defer func() {
+		g.health.listening(listener, node, true)
+		if client != nil {
+			client.RemoveEventListener(listenerChan)
+		}
+	}()
+	backoff := g.eventRetryInterval
+	// wait returns false if ctx is canceled while waiting to reconnect
+	wait := func() bool {
+		logging.Infof("Reconnecting to docker daemon in %s", backoff)
+		timer := time.NewTimer(backoff)
+		defer timer.Stop()
+		backoff = g.nextEventRetry(backoff)
+		select {
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	// forward returns false if ctx is canceled before the event is forwarded
+	forward := func(event endpointEvent) bool {
+		select {
+		case eventChan <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if _, err := dockerclient.GetEndpoint(node); err != nil {
+		logging.Errorf("Bad endpoint: %s", err)
+		return false
+	}
+	// the client is shared with the container listings: it is reset in the
+	// pool on connection failures, so that every user reconnects
+	for {
+		if client == nil {
+			var err error
+			client, err = g.clients.get(node)
+			if err != nil {
+				logging.Errorf("Unable to connect to docker daemon: %s", err)
+				g.health.listening(listener, node, false)
+				client = nil
+				if !wait() {
+					return true
 				}
-			case <-clientDone:
-				return
-			case sig := <-sigChan:
-				log.Printf("Received signal: %s\n", sig)
-				switch sig {
-				case syscall.SIGTERM, syscall.SIGINT:
-					return
+				continue
+			}
+			listenerChan = make(chan *docker.APIEvents, 100)
+			err = client.AddEventListener(listenerChan)
+			if err != nil && err != docker.ErrListenerAlreadyExists {
+				logging.Errorf("Error registering docker event listener: %s", err)
+				g.health.listening(listener, node, false)
+				g.clients.reset(node, client)
+				client = nil
+				listenerChan = nil
+				if !wait() {
+					return true
 				}
+				continue
+			}
+			logging.Infof("Watching docker events")
+			g.health.listening(listener, node, true)
+			backoff = g.eventRetryInterval
+			// sync all configs after resuming listener
+			if !forward(endpointEvent{endpoint: key}) {
+				return true
 			}
 		}
-	}()
+		select {
+		case event, ok := <-listenerChan:
+			if !ok {
+				logging.Warnf("Docker daemon connection interrupted")
+				g.health.listening(listener, node, false)
+				client.RemoveEventListener(listenerChan)
+				g.clients.reset(node, client)
+				client = nil
+				listenerChan = nil
+				if !g.retry {
+					return false
+				}
+				if !wait() {
+					return true
+				}
+				continue
+			}
+			if events[eventName(event.Status)] {
+				logging.WithFields(logging.Fields{"status": event.Status, "container": event.ID[:12]}).Debugf("Received event %s for container %s", event.Status, event.ID[:12])
+				// forward event to the watchers of the endpoint
+				if !forward(endpointEvent{endpoint: key, event: event}) {
+					return true
+				}
+			} else if event.Type == "service" {
+				logging.WithFields(logging.Fields{"status": event.Action, "service": event.Actor.ID}).Debugf("Received event %s for service %s", event.Action, event.Actor.ID)
+				if !forward(endpointEvent{endpoint: key, event: event}) {
+					return true
+				}
+			}
+		case <-time.After(g.eventRetryInterval):
+			// check for docker liveness
+			err := client.Ping()
+			if err != nil {
+				logging.Errorf("Unable to ping docker daemon: %s", err)
+				g.health.listening(listener, node, false)
+				client.RemoveEventListener(listenerChan)
+				g.clients.reset(node, client)
+				client = nil
+				listenerChan = nil
+			} else {
+				g.health.record()
+			}
+		case <-ctx.Done():
+			logging.Infof("Done signal received")
+			return true
+		}
+	}
+}
+
+// nextEventRetry returns the delay following backoff before reconnecting to
+// docker events: doubled, up to eventRetryMaxInterval, when the exponential
+// backoff is enabled
+func (g *generator) nextEventRetry(backoff time.Duration) time.Duration {
+	if g.eventRetryMaxInterval <= g.eventRetryInterval {
+		return g.eventRetryInterval
+	}
+	backoff *= 2
+	if backoff > g.eventRetryMaxInterval {
+		backoff = g.eventRetryMaxInterval
+	}
+	return backoff
+}
+
+// generateFromEvent regenerates cfg after a (debounced) docker event.
+// When cfg.SkipUnchanged is set, signature holds the signature of the container
+// list of the previous cycle, and the cycle is skipped if it did not change.
+func (g *generator) generateFromEvent(cfg config.Config, signature *string) {
+	g.generateFromSettledEvents([]config.Config{cfg}, []*string{signature})
+}
+
+// generateFromSettledEvents regenerates configs of a same endpoint after a
+// (debounced) docker event, listing and inspecting the containers once for
+// all of them. signatures holds, for each config, the signature used by
+// SkipUnchanged.
+func (g *generator) generateFromSettledEvents(configs []config.Config, signatures []*string) {
+	if g.paused.Load() {
+		logging.Infof("Generation is paused. Skipping generation")
+		return
+	}
+	start := time.Now()
+	listed, err := g.listContainers(configs[0])
+	if err != nil {
+		logging.Errorf("Error listing containers: %s\n", err)
+		return
+	}
+
+	current := ""
+	selected := []config.Config{}
+	selectedSignatures := []*string{}
+	for i, cfg := range configs {
+		if cfg.SkipUnchanged {
+			if current == "" {
+				current = containersSignature(listed)
+			}
+			if current == *signatures[i] {
+				logging.Infof("Container list did not change. Skipping generation of %s", cfg.Dest)
+				continue
+			}
+			*signatures[i] = current
+			selectedSignatures = append(selectedSignatures, signatures[i])
+		}
+		selected = append(selected, cfg)
+	}
+	if len(selected) == 0 {
+		return
+	}
+
+	containers := g.inspectContainers(listed)
+	getContainersDuration.Observe(time.Since(start).Seconds())
+	g.updateServices(configs[0])
+	if err := g.generateConfigs(selected, func(config.Config) []*context.RuntimeContainer {
+		return containers
+	}); err != nil {
+		// the failed generation is retried on the next event, even if the
+		// containers did not change
+		for _, signature := range selectedSignatures {
+			*signature = ""
+		}
+	}
+}
+
+// logContainerChanges logs, when cfg.LogChanges is set and the output changed,
+// which containers were added, removed or modified since the previous render of cfg
+func (g *generator) logContainerChanges(cfg config.Config, containers []*context.RuntimeContainer, changed bool) {
+	if !cfg.LogChanges {
+		return
+	}
+
+	key := configKey(cfg)
+	g.renderedMu.Lock()
+	previous, rendered := g.rendered[key]
+	g.rendered[key] = containers
+	g.renderedMu.Unlock()
+
+	if !changed || !rendered {
+		return
+	}
+	added, removed, modified := containerChanges(previous, containers)
+	dests := strings.Join(cfg.Destinations(), ", ")
+	logging.WithFields(logging.Fields{"dest": dests}).Infof("Containers changed for '%s': added %v, removed %v, modified %v", dests, added, removed, modified)
+}
+
+// containerChanges returns the containers added, removed and modified between
+// the previous and current container sets. Containers are identified by
+// name and ID, and described as "name (short ID)".
+func containerChanges(previous, current []*context.RuntimeContainer) (added, removed, modified []string) {
+	identity := func(c *context.RuntimeContainer) string {
+		id := c.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		return fmt.Sprintf("%s (%s)", c.Name, id)
+	}
+
+	before := make(map[string]*context.RuntimeContainer, len(previous))
+	for _, c := range previous {
+		before[identity(c)] = c
+	}
+	after := make(map[string]*context.RuntimeContainer, len(current))
+	for _, c := range current {
+		after[identity(c)] = c
+	}
+
+	for _, c := range current {
+		key := identity(c)
+		if old, ok := before[key]; !ok {
+			added = append(added, key)
+		} else if !reflect.DeepEqual(old, c) {
+			modified = append(modified, key)
+		}
+	}
+	for _, c := range previous {
+		if key := identity(c); after[key] == nil {
+			removed = append(removed, key)
+		}
+	}
+	return
 }
 
-func (g *generator) runNotifyCmd(config config.Config) {
-	if config.NotifyCmd == "" {
+// configKey identifies a config across generations and reloads, by its
+// template and destinations
+func configKey(cfg config.Config) string {
+	return cfg.Template + "\x00" + strings.Join(cfg.Destinations(), "\x00")
+}
+
+// firstRun returns whether the config is generated for the first time since
+// docker-gen started
+func (g *generator) firstRun(cfg config.Config) bool {
+	key := configKey(cfg)
+
+	g.ranMu.Lock()
+	defer g.ranMu.Unlock()
+	if g.ran[key] {
+		return false
+	}
+	g.ran[key] = true
+	return true
+}
+
+// notifyEnv returns the variables describing a generation of cfg to its
+// notify command: its template and destinations, whether its output changed
+// and the number of containers it rendered
+func notifyEnv(cfg config.Config, changed bool, containers int) []string {
+	dests := cfg.Destinations()
+	dest := ""
+	if len(dests) > 0 {
+		dest = dests[0]
+	}
+	return []string{
+		"DOCKER_GEN_TEMPLATE=" + cfg.Template,
+		"DOCKER_GEN_DEST=" + dest,
+		"DOCKER_GEN_DESTS=" + strings.Join(dests, ":"),
+		"DOCKER_GEN_CHANGED=" + strconv.FormatBool(changed),
+		"DOCKER_GEN_CONTAINER_COUNT=" + strconv.Itoa(containers),
+	}
+}
+
+// runNotifyCmd runs the notify command of the config, or its first run
+// command if it is set and the config is generated for the first time, with
+// env added to the environment of docker-gen
+func (g *generator) runNotifyCmd(config config.Config, first bool, env ...string) {
+	notifyCmd := config.NotifyCmd
+	if first && config.FirstRunCmd != "" {
+		notifyCmd = config.FirstRunCmd
+	}
+	if notifyCmd == "" {
 		return
 	}
 
-	log.Printf("Running '%s'", config.NotifyCmd)
-	cmd := exec.Command("/bin/sh", "-c", config.NotifyCmd)
+	// a failed command is retried NotifyRetries times
+	attempts := 1
+	if config.NotifyRetries > 0 {
+		attempts += config.NotifyRetries
+	}
+	// the retries stop on shutdown
+	ran := 0
+	for ran < attempts {
+		if ran > 0 {
+			logging.WithFields(logging.Fields{"dest": config.Dest}).Warnf("Retrying '%s' in %s (attempt %d/%d)", notifyCmd, config.NotifyRetryInterval, ran+1, attempts)
+			if !sleep(g.done, config.NotifyRetryInterval) {
+				break
+			}
+		}
+		ran++
+		if runNotifyCmdOnce(config, notifyCmd, env) {
+			return
+		}
+	}
+	g.notifyFailures.Add(1)
+	if attempts > 1 {
+		logging.WithFields(logging.Fields{"dest": config.Dest}).Errorf("Error running notify command: %s, giving up after %d attempts", notifyCmd, ran)
+	}
+}
+
+// runNotifyCmdOnce runs the notify command, logging its outcome, and returns
+// whether it succeeded
+func runNotifyCmdOnce(config config.Config, notifyCmd string, env []string) bool {
+	logger := logging.WithFields(logging.Fields{"dest": config.Dest})
+	logger.Infof("Running '%s'", notifyCmd)
+	notifyCommandsTotal.Inc()
+	start := time.Now()
+	cmd := exec.Command("/bin/sh", "-c", notifyCmd)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	out, err := cmd.CombinedOutput()
+	duration := time.Since(start).Round(time.Millisecond)
 	if err != nil {
-		log.Printf("Error running notify command: %s, %s\n", config.NotifyCmd, err)
+		notifyCommandFailuresTotal.Inc()
+		logger.Errorf("Error running notify command: %s, %s (exit code %d after %s)\n", notifyCmd, err, exitCode(err), duration)
+	} else {
+		logger.Debugf("Ran '%s' (exit code 0 after %s)", notifyCmd, duration)
 	}
 	if config.NotifyOutput {
 		for _, line := range strings.Split(string(out), "\n") {
 			if line != "" {
-				log.Printf("[%s]: %s", config.NotifyCmd, line)
+				logger.Infof("[%s]: %s", notifyCmd, line)
 			}
 		}
 	}
+	return err == nil
+}
+
+// exitCode returns the exit code of a command from the error it returned,
+// or -1 if the command did not exit normally
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
 }
 
 func (g *generator) sendSignalToContainer(config config.Config) {
@@ -375,22 +1362,48 @@ func (g *generator) sendSignalToContainer(config config.Config) {
 		return
 	}
 
-	for container, signal := range config.NotifyContainers {
-		log.Printf("Sending container '%s' signal '%v'", container, signal)
+	client, err := g.configClient(config)
+	if err != nil {
+		logging.Errorf("Error notifying containers: %s", err)
+		return
+	}
+	match, err := config.NotifyContainerMatcher()
+	if err != nil {
+		logging.Errorf("Error notifying containers: %s", err)
+		return
+	}
+	if match == nil {
+		for container, signal := range config.NotifyContainers {
+			g.signalContainer(client, container, signal)
+		}
+		return
+	}
 
-		if signal == -1 {
-			if err := g.Client.RestartContainer(container, 10); err != nil {
-				log.Printf("Error sending restarting container: %s", err)
+	// the keys are patterns, resolved against the running containers
+	containers, err := client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		logging.Errorf("Error getting containers: %s", err)
+		return
+	}
+	for pattern, signal := range config.NotifyContainers {
+		ids, names := []string{}, []string{}
+		for _, container := range containers {
+			for _, name := range container.Names {
+				name = strings.TrimPrefix(name, "/")
+				if match(pattern, name) {
+					ids = append(ids, container.ID)
+					names = append(names, name)
+					break
+				}
 			}
-			return
 		}
-
-		killOpts := docker.KillContainerOptions{
-			ID:     container,
-			Signal: docker.Signal(signal),
+		if len(ids) == 0 {
+			logging.Warnf("No container matches notify pattern '%s'", pattern)
+			continue
 		}
-		if err := g.Client.KillContainer(killOpts); err != nil {
-			log.Printf("Error sending signal to container: %s", err)
+		logging.Infof("Notify pattern '%s' matches containers %s", pattern, strings.Join(names, ", "))
+		for _, id := range ids {
+			g.signalContainer(client, id, signal)
 		}
 	}
 }
@@ -400,54 +1413,311 @@ func (g *generator) sendSignalToContainers(config config.Config) {
 		return
 	}
 
-	containers, err := g.Client.ListContainers(docker.ListContainersOptions{
+	client, err := g.configClient(config)
+	if err != nil {
+		logging.Errorf("Error notifying containers: %s", err)
+		return
+	}
+	containers, err := client.ListContainers(docker.ListContainersOptions{
 		Filters: config.NotifyContainersFilter,
 	})
 	if err != nil {
-		log.Printf("Error getting containers: %s", err)
+		logging.Errorf("Error getting containers: %s", err)
 		return
 	}
 	for _, container := range containers {
-		log.Printf("Sending container '%s' signal '%v'", container.ID, config.NotifyContainersSignal)
-		if config.NotifyContainersSignal == -1 {
-			if err := g.Client.RestartContainer(container.ID, 10); err != nil {
-				log.Printf("Error sending restarting container: %s", err)
-			}
-		} else {
-			killOpts := docker.KillContainerOptions{
-				ID:     container.ID,
-				Signal: docker.Signal(config.NotifyContainersSignal),
+		g.signalContainer(client, container.ID, config.NotifyContainersSignal)
+	}
+}
+
+// sendSignalToLabeledContainers signals the containers carrying the
+// NotifyContainersLabel label, using the label's value as the signal
+func (g *generator) sendSignalToLabeledContainers(config config.Config) {
+	if config.NotifyContainersLabel == "" {
+		return
+	}
+
+	client, err := g.configClient(config)
+	if err != nil {
+		logging.Errorf("Error notifying containers: %s", err)
+		return
+	}
+	containers, err := client.ListContainers(docker.ListContainersOptions{
+		Filters: map[string][]string{"label": {config.NotifyContainersLabel}},
+	})
+	if err != nil {
+		logging.Errorf("Error getting containers: %s", err)
+		return
+	}
+	for _, container := range containers {
+		signal, err := dockerclient.ParseSignal(container.Labels[config.NotifyContainersLabel])
+		if err != nil {
+			logging.Errorf("Error parsing signal label of container '%s': %s", container.ID, err)
+			continue
+		}
+		g.signalContainer(client, container.ID, signal)
+	}
+}
+
+// signalContainer sends signal to the container, or restarts it if signal is -1
+func (g *generator) signalContainer(client *docker.Client, id string, signal int) {
+	logging.Infof("Sending container '%s' signal '%v'", id, signal)
+	if signal == -1 {
+		if err := client.RestartContainer(id, 10); err != nil {
+			logging.Errorf("Error sending restarting container: %s", err)
+		}
+		return
+	}
+
+	killOpts := docker.KillContainerOptions{
+		ID:     id,
+		Signal: docker.Signal(signal),
+	}
+	if err := client.KillContainer(killOpts); err != nil {
+		logging.Errorf("Error sending signal to container: %s", err)
+	}
+}
+
+// listedContainers holds the container summaries returned by a swarm client
+type listedContainers struct {
+	client     *docker.Client
+	containers []docker.APIContainers
+}
+
+// listContainers lists the containers of the daemon of cfg: the daemon of
+// cfg.Endpoint, or else every swarm client. Stopped
+// containers are listed if any config includes them; each config then
+// filters the containers according to its own IncludeStopped setting.
+// Container sizes are only computed if a config asks for them, as it is
+// expensive for the docker daemon. When every config of the endpoint has a
+// ContainerFilter, only the union of the containers matching these filters
+// is listed.
+func (g *generator) listContainers(cfg config.Config) ([]listedContainers, error) {
+	configs := g.configs()
+	all := g.All || configs.IncludesStopped()
+	size := configs.IncludesSize()
+	filters := configs.ContainerFilters(cfg.Endpoint)
+	if filters == nil {
+		filters = []map[string][]string{nil}
+	}
+
+	nodes := g.SwarmNodes
+	if cfg.Endpoint != "" {
+		nodes = []string{cfg.Endpoint}
+	}
+	clients := []*docker.Client{}
+	for _, node := range nodes {
+		client, err := g.clients.get(node)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	listed := []listedContainers{}
+	for _, client := range clients {
+		apiContainers := []docker.APIContainers{}
+		seen := make(map[string]bool)
+		for _, filter := range filters {
+			matching, err := client.ListContainers(docker.ListContainersOptions{
+				All:     all,
+				Size:    size,
+				Filters: filter,
+			})
+			if err != nil {
+				return nil, err
 			}
-			if err := g.Client.KillContainer(killOpts); err != nil {
-				log.Printf("Error sending signal to container: %s", err)
+			for _, apiContainer := range matching {
+				if !seen[apiContainer.ID] {
+					seen[apiContainer.ID] = true
+					apiContainers = append(apiContainers, apiContainer)
+				}
 			}
 		}
+		listed = append(listed, listedContainers{client: client, containers: apiContainers})
+	}
+	g.health.record()
+	return listed, nil
+}
+
+// configClient returns the client of the daemon of cfg, used to notify its
+// containers: the client of cfg.Endpoint, or else the global client
+func (g *generator) configClient(cfg config.Config) (*docker.Client, error) {
+	if cfg.Endpoint == "" {
+		return g.clients.get(g.Endpoint)
+	}
+	return g.clients.get(cfg.Endpoint)
+}
+
+// containersSignature returns a digest of the container summaries, covering
+// the fields that change when a container is started, stopped, recreated,
+// relabeled, connected to a network or changes health.
+func containersSignature(listed []listedContainers) string {
+	summaries := []docker.APIContainers{}
+	for _, l := range listed {
+		summaries = append(summaries, l.containers...)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].ID < summaries[j].ID
+	})
+
+	h := sha1.New()
+	enc := json.NewEncoder(h)
+	for _, c := range summaries {
+		enc.Encode([]interface{}{
+			c.ID, c.Names, c.Image, c.State, containerHealth(c.Status),
+			c.Labels, c.Ports, c.Networks, c.Mounts,
+		})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// containerHealth extracts the health part of a container summary status
+// (e.g. "Up 2 minutes (healthy)"), ignoring the uptime that changes constantly
+func containerHealth(status string) string {
+	for _, health := range []string{"(healthy)", "(unhealthy)", "(health: starting)"} {
+		if strings.Contains(status, health) {
+			return health
+		}
 	}
+	return ""
 }
 
-func (g *generator) getContainers() ([]*context.RuntimeContainer, error) {
-	apiInfo, err := g.Client.Info()
+// supportsAPIVersion returns whether the docker daemon API is at least the
+// given version. A daemon of unknown version is assumed to be recent.
+func (g *generator) supportsAPIVersion(version string) bool {
+	if g.apiVersion == nil {
+		return true
+	}
+	minVersion, err := docker.NewAPIVersion(version)
 	if err != nil {
-		log.Printf("Error retrieving docker server info: %s\n", err)
-	} else {
-		context.SetServerInfo(apiInfo)
+		return false
 	}
+	return g.apiVersion.GreaterThanOrEqualTo(minVersion)
+}
 
-	containers := []*context.RuntimeContainer{}
-	for _, client := range g.SwarmClients {
-		apiContainers, err := client.ListContainers(docker.ListContainersOptions{
-			All:  g.All,
-			Size: false,
+// getContainers lists and inspects the containers of the daemon of cfg
+func (g *generator) getContainers(cfg config.Config) ([]*context.RuntimeContainer, error) {
+	start := time.Now()
+	listed, err := g.listContainers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	containers := g.inspectContainers(listed)
+	getContainersDuration.Observe(time.Since(start).Seconds())
+	g.updateServices(cfg)
+	return containers, nil
+}
+
+// updateServices lists the swarm services of the daemon of cfg if a config of
+// its endpoint renders them. On error, the previously listed services are kept.
+func (g *generator) updateServices(cfg config.Config) {
+	configs := g.configs()
+	if !configs.IncludesServices(cfg.Endpoint) {
+		return
+	}
+	services, err := g.getServices(cfg)
+	if err != nil {
+		logging.Errorf("Error listing services: %s\n", err)
+		return
+	}
+	context.SetServices(cfg.Endpoint, services)
+}
+
+// getServices lists the swarm services of the daemon of cfg, which must be a
+// swarm manager, and counts their running tasks
+func (g *generator) getServices(cfg config.Config) ([]*context.RuntimeService, error) {
+	client, err := g.configClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	apiServices, err := client.ListServices(docker.ListServicesOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	services := []*context.RuntimeService{}
+	for _, service := range apiServices {
+		tasks, err := client.ListTasks(docker.ListTasksOptions{
+			Filters: map[string][]string{"service": {service.ID}},
 		})
 		if err != nil {
-			return nil, err
+			logging.Errorf("Error inspecting service: %s: %s\n", service.ID, err)
+			continue
+		}
+
+		image := ""
+		if service.Spec.TaskTemplate.ContainerSpec != nil {
+			// the image is pinned by digest (e.g. nginx:1.25@sha256:...)
+			image = strings.Split(service.Spec.TaskTemplate.ContainerSpec.Image, "@")[0]
+		}
+		registry, repository, tag := dockerclient.SplitDockerImage(image)
+		runtimeService := &context.RuntimeService{
+			ID:   service.ID,
+			Name: service.Spec.Name,
+			Image: context.DockerImage{
+				Registry:   registry,
+				Repository: repository,
+				Tag:        tag,
+			},
+			Labels: make(map[string]string),
+			Ports:  []context.ServicePort{},
+		}
+		for k, v := range service.Spec.Labels {
+			runtimeService.Labels[k] = v
+		}
+		for _, task := range tasks {
+			if task.Status.State == "running" {
+				runtimeService.RunningTasks++
+			}
+		}
+		switch {
+		case service.Spec.Mode.Global != nil:
+			runtimeService.Mode = "global"
+			runtimeService.Replicas = uint64(runtimeService.RunningTasks)
+		case service.Spec.Mode.Replicated != nil:
+			runtimeService.Mode = "replicated"
+			if service.Spec.Mode.Replicated.Replicas != nil {
+				runtimeService.Replicas = *service.Spec.Mode.Replicated.Replicas
+			}
+		}
+		for _, port := range service.Endpoint.Ports {
+			runtimeService.Ports = append(runtimeService.Ports, context.ServicePort{
+				Name:          port.Name,
+				Proto:         string(port.Protocol),
+				TargetPort:    port.TargetPort,
+				PublishedPort: port.PublishedPort,
+				PublishMode:   string(port.PublishMode),
+			})
 		}
+		services = append(services, runtimeService)
+	}
+	return services, nil
+}
+
+func (g *generator) inspectContainers(listed []listedContainers) []*context.RuntimeContainer {
+	// the groupings memoized for the previous containers are not needed anymore
+	template.ResetCache()
+
+	client, err := g.clients.get(g.Endpoint)
+	if err == nil {
+		var apiInfo *docker.DockerInfo
+		if apiInfo, err = client.Info(); err == nil {
+			context.SetServerInfo(apiInfo)
+		}
+	}
+	if err != nil {
+		logging.Errorf("Error retrieving docker server info: %s\n", err)
+	}
 
-		for _, apiContainer := range apiContainers {
+	containers := []*context.RuntimeContainer{}
+	for _, l := range listed {
+		client := l.client
+		for _, apiContainer := range l.containers {
 			opts := docker.InspectContainerOptions{ID: apiContainer.ID}
 			container, err := client.InspectContainerWithOptions(opts)
 			if err != nil {
-				log.Printf("Error inspecting container: %s: %s\n", apiContainer.ID, err)
+				logging.Errorf("Error inspecting container: %s: %s\n", apiContainer.ID, err)
 				continue
 			}
 
@@ -461,6 +1731,7 @@ func (g *generator) getContainers() ([]*context.RuntimeContainer, error) {
 				},
 				State: context.State{
 					Running: container.State.Running,
+					Paused:  container.State.Paused,
 				},
 				Name:         strings.TrimLeft(container.Name, "/"),
 				Hostname:     container.Config.Hostname,
@@ -474,6 +1745,11 @@ func (g *generator) getContainers() ([]*context.RuntimeContainer, error) {
 				IP:           container.NetworkSettings.IPAddress,
 				IP6LinkLocal: container.NetworkSettings.LinkLocalIPv6Address,
 				IP6Global:    container.NetworkSettings.GlobalIPv6Address,
+				SizeRw:       apiContainer.SizeRw,
+				SizeRootFs:   apiContainer.SizeRootFs,
+				Created:      container.Created,
+				StartedAt:    container.State.StartedAt,
+				RestartCount: container.RestartCount,
 			}
 			for k, v := range container.NetworkSettings.Ports {
 				address := context.Address{
@@ -502,6 +1778,7 @@ func (g *generator) getContainers() ([]*context.RuntimeContainer, error) {
 					MacAddress:          v.MacAddress,
 					GlobalIPv6PrefixLen: v.GlobalIPv6PrefixLen,
 					IPPrefixLen:         v.IPPrefixLen,
+					Aliases:             append([]string{}, v.Aliases...),
 				}
 
 				runtimeContainer.Networks = append(runtimeContainer.Networks,
@@ -522,28 +1799,50 @@ func (g *generator) getContainers() ([]*context.RuntimeContainer, error) {
 				}
 			}
 
-			for _, v := range container.Mounts {
-				runtimeContainer.Mounts = append(runtimeContainer.Mounts, context.Mount{
-					Name:        v.Name,
-					Source:      v.Source,
-					Destination: v.Destination,
-					Driver:      v.Driver,
-					Mode:        v.Mode,
-					RW:          v.RW,
-				})
+			// mounts were introduced in docker API 1.20
+			if g.supportsAPIVersion("1.20") {
+				for _, v := range container.Mounts {
+					runtimeContainer.Mounts = append(runtimeContainer.Mounts, context.Mount{
+						Name:        v.Name,
+						Source:      v.Source,
+						Destination: v.Destination,
+						Driver:      v.Driver,
+						Mode:        v.Mode,
+						RW:          v.RW,
+					})
+				}
+			}
+
+			// healthchecks were introduced in docker API 1.24
+			if g.supportsAPIVersion("1.24") && container.State.Health.Status != "none" {
+				runtimeContainer.State.Health = container.State.Health.Status
 			}
 
+			runtimeContainer.Args = append([]string{}, container.Args...)
+			runtimeContainer.Cmd = append([]string{}, container.Config.Cmd...)
+			runtimeContainer.Entrypoint = append([]string{}, container.Config.Entrypoint...)
+			runtimeContainer.ExposedPorts = []string{}
+			for port := range container.Config.ExposedPorts {
+				runtimeContainer.ExposedPorts = append(runtimeContainer.ExposedPorts, string(port))
+			}
+			sort.Strings(runtimeContainer.ExposedPorts)
+			if container.HostConfig != nil {
+				runtimeContainer.PublishAllPorts = container.HostConfig.PublishAllPorts
+				runtimeContainer.MemoryLimit = container.HostConfig.Memory
+				runtimeContainer.CPUShares = container.HostConfig.CPUShares
+				runtimeContainer.NanoCPUs = container.HostConfig.NanoCPUs
+			}
 			runtimeContainer.Env = utils.SplitKeyValueSlice(container.Config.Env)
 			runtimeContainer.Labels = container.Config.Labels
 			containers = append(containers, runtimeContainer)
 		}
 	}
-	return containers, nil
+	return containers
 }
 
 func newSignalChannel() (<-chan os.Signal, func()) {
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGUSR1)
 	return sig, func() { signal.Stop(sig) }
 }
 
@@ -578,11 +1877,11 @@ func newDebounceChannel(input chan *docker.APIEvents, wait *config.Wait) chan *d
 					maxTimer = time.After(wait.Max)
 				}
 			case <-minTimer:
-				log.Println("Debounce minTimer fired")
+				logging.Debugf("Debounce minTimer fired")
 				minTimer, maxTimer = nil, nil
 				output <- event
 			case <-maxTimer:
-				log.Println("Debounce maxTimer fired")
+				logging.Debugf("Debounce maxTimer fired")
 				minTimer, maxTimer = nil, nil
 				output <- event
 			}
@@ -2,22 +2,84 @@ package generator
 
 import (
 	"bufio"
+	gocontext "context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 	dockertest "github.com/fsouza/go-dockerclient/testing"
 	"github.com/nginx-proxy/docker-gen/internal/config"
+	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/nginx-proxy/docker-gen/internal/logging"
+	"github.com/stretchr/testify/assert"
 )
 
+// newTestDockerServer returns a fake docker daemon, stopped at the end of the
+// test, and its endpoint. It lists and inspects the given containers; tests
+// needing other responses override its handlers with CustomHandler.
+func newTestDockerServer(t testing.TB, containers ...docker.Container) (*dockertest.DockerServer, string) {
+	server, err := dockertest.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatalf("Unable to start docker server: %v\n", err)
+	}
+	t.Cleanup(server.Stop)
+
+	byID := make(map[string]docker.Container, len(containers))
+	for _, container := range containers {
+		byID[container.ID] = container
+	}
+	server.CustomHandler("/info", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"Containers":%d,"Images":1}`, len(containers))))
+	}))
+	server.CustomHandler("/version", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Version":"24.0.5","ApiVersion":"1.43"}`))
+	}))
+	server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listed := []docker.APIContainers{}
+		for _, container := range containers {
+			listed = append(listed, docker.APIContainers{ID: container.ID, Names: []string{"/" + strings.TrimPrefix(container.Name, "/")}})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listed)
+	}))
+	server.CustomHandler("/containers/[^/]+/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		container, ok := byID[strings.Split(r.URL.Path, "/")[2]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(container)
+	}))
+	return server, fmt.Sprintf("tcp://%s", strings.TrimRight(strings.TrimPrefix(server.URL(), "http://"), "/"))
+}
+
+// runningContainer returns a running container of the fake docker daemon
+func runningContainer(id string) docker.Container {
+	return docker.Container{
+		ID:              id,
+		Name:            "/" + id,
+		Config:          &docker.Config{Image: "base"},
+		State:           docker.State{Running: true},
+		NetworkSettings: &docker.NetworkSettings{},
+	}
+}
+
 func TestGenerateFromEvents(t *testing.T) {
 	log.SetOutput(io.Discard)
 	containerID := "8dfafdbc3a40"
@@ -170,7 +232,7 @@ func TestGenerateFromEvents(t *testing.T) {
 
 	generator.retry = false
 
-	generator.generateFromEvents()
+	generator.generateFromEvents(gocontext.Background())
 	generator.wg.Wait()
 
 	var (
@@ -200,3 +262,1278 @@ func TestGenerateFromEvents(t *testing.T) {
 		}
 	}
 }
+
+func TestReloadConfigsKeepsConfigOnError(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	invalid := filepath.Join(t.TempDir(), "invalid.cfg")
+	if err := os.WriteFile(invalid, []byte("[[config]]\ndest = \"foo\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v\n", err)
+	}
+
+	current := config.ConfigFile{
+		Config: []config.Config{{Template: "foo.tmpl", Dest: "foo", Interval: 3600}},
+	}
+	g := &generator{
+		Configs:     current,
+		ConfigFiles: []string{invalid},
+	}
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	defer g.wg.Wait()
+	defer cancel()
+	g.generateAtInterval(ctx)
+	watchers := make(map[string]bool)
+	for key := range g.intervals {
+		watchers[key] = true
+	}
+
+	g.reloadConfigs(ctx)
+
+	for key := range watchers {
+		if _, ok := g.intervals[key]; !ok || len(g.intervals) != len(watchers) {
+			t.Errorf("expected watchers to keep running after an invalid reload")
+		}
+	}
+	if len(g.Configs.Config) != 1 || g.Configs.Config[0].Template != "foo.tmpl" {
+		t.Errorf("expected configuration to be kept, got %v", g.Configs)
+	}
+}
+
+func TestReloadConfigsUpdatesWatchers(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer log.SetOutput(io.Discard)
+
+	_, serverURL := newTestDockerServer(t)
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	configFile := filepath.Join(dir, "docker-gen.cfg")
+	writeConfigs := func(configs ...string) {
+		contents := ""
+		for _, cfg := range configs {
+			contents += fmt.Sprintf("[[config]]\ntemplate = %q\n%s\n", tmplFile, cfg)
+		}
+		if err := os.WriteFile(configFile, []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write config file: %v\n", err)
+		}
+	}
+
+	kept := fmt.Sprintf("dest = %q\ninterval = 3601", filepath.Join(dir, "kept"))
+	watched := fmt.Sprintf("dest = %q\nwatch = true", filepath.Join(dir, "watched"))
+	writeConfigs(kept, fmt.Sprintf("dest = %q\ninterval = 3602", filepath.Join(dir, "removed")), watched)
+	configs, err := config.LoadConfigFiles(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config file: %v\n", err)
+	}
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint:    serverURL,
+		ConfigFile:  configs,
+		ConfigFiles: []string{configFile},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	generator.generateAtInterval(ctx)
+	generator.generateFromEvents(ctx)
+	events := generator.events
+	assert.Len(t, generator.intervals, 2)
+	assert.Len(t, events.watchers, 1)
+
+	buf.Reset()
+	writeConfigs(kept, fmt.Sprintf("dest = %q\ninterval = 3603", filepath.Join(dir, "added")), watched, fmt.Sprintf("dest = %q\nwatch = true", filepath.Join(dir, "added-watched")))
+	generator.reloadConfigs(ctx)
+
+	assert.Contains(t, buf.String(), "Generating every 3603 seconds")
+	assert.NotContains(t, buf.String(), "Generating every 3601 seconds", "the unchanged config keeps its ticker")
+	assert.Len(t, generator.intervals, 2)
+	assert.Same(t, events, generator.events, "the event watchers keep running")
+	assert.Len(t, events.watchers, 2)
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		generator.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watchers did not stop")
+	}
+}
+
+func TestContainersSignature(t *testing.T) {
+	listed := []listedContainers{{containers: []docker.APIContainers{
+		{ID: "b", State: "running", Status: "Up 2 minutes (healthy)"},
+		{ID: "a", State: "running", Status: "Up 3 minutes", Labels: map[string]string{"foo": "bar"}},
+	}}}
+	signature := containersSignature(listed)
+
+	// uptime and ordering changes don't change the signature
+	same := []listedContainers{{containers: []docker.APIContainers{
+		{ID: "a", State: "running", Status: "Up 4 minutes", Labels: map[string]string{"foo": "bar"}},
+		{ID: "b", State: "running", Status: "Up 3 minutes (healthy)"},
+	}}}
+	if containersSignature(same) != signature {
+		t.Errorf("expected signature to be unchanged")
+	}
+
+	changes := [][]docker.APIContainers{
+		{{ID: "a", State: "exited"}, {ID: "b", State: "running", Status: "Up 2 minutes (healthy)"}},
+		{{ID: "a", State: "running", Labels: map[string]string{"foo": "baz"}}, {ID: "b", State: "running", Status: "Up 2 minutes (healthy)"}},
+		{{ID: "a", State: "running", Labels: map[string]string{"foo": "bar"}}, {ID: "b", State: "running", Status: "Up 2 minutes (unhealthy)"}},
+		{{ID: "a", State: "running", Labels: map[string]string{"foo": "bar"}}},
+	}
+	for i, containers := range changes {
+		if containersSignature([]listedContainers{{containers: containers}}) == signature {
+			t.Errorf("expected signature of change #%d to differ", i)
+		}
+	}
+}
+
+func BenchmarkGenerateFromEvent(b *testing.B) {
+	log.SetOutput(io.Discard)
+	const numContainers = 20
+	var inspects atomic.Int32
+
+	server, serverURL := newTestDockerServer(b)
+	server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := []docker.APIContainers{}
+		for i := 0; i < numContainers; i++ {
+			result = append(result, docker.APIContainers{
+				ID:     fmt.Sprintf("container%d", i),
+				Image:  "base:latest",
+				State:  "running",
+				Status: "Up 1 minute",
+				Names:  []string{fmt.Sprintf("/container%d", i)},
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	server.CustomHandler("/containers/[^/]+/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inspects.Add(1)
+		id := strings.Split(r.URL.Path, "/")[2]
+		container := docker.Container{
+			Name:            id,
+			ID:              id,
+			Config:          &docker.Config{Image: "base:latest"},
+			State:           docker.State{Running: true},
+			NetworkSettings: &docker.NetworkSettings{IPAddress: "10.0.0.10"},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(container)
+	}))
+
+	dir := b.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{range .}}{{.ID}} {{.IP}}\n{{end}}"), 0644); err != nil {
+		b.Fatalf("Failed to write template: %v\n", err)
+	}
+
+	for _, skipUnchanged := range []bool{false, true} {
+		b.Run(fmt.Sprintf("SkipUnchanged=%t", skipUnchanged), func(b *testing.B) {
+			cfg := config.Config{
+				Template:      tmplFile,
+				Dest:          filepath.Join(dir, fmt.Sprintf("dest-%t", skipUnchanged)),
+				SkipUnchanged: skipUnchanged,
+			}
+			generator, err := NewGenerator(GeneratorConfig{
+				Endpoint:   serverURL,
+				ConfigFile: config.ConfigFile{Config: []config.Config{cfg}},
+			})
+			if err != nil {
+				b.Fatalf("Error creating generator: %v\n", err)
+			}
+
+			var signature string
+			inspects.Store(0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				generator.generateFromEvent(cfg, &signature)
+			}
+			b.ReportMetric(float64(inspects.Load())/float64(b.N), "inspects/op")
+		})
+	}
+}
+
+func TestContainerChanges(t *testing.T) {
+	previous := []*context.RuntimeContainer{
+		{ID: "1", Name: "kept"},
+		{ID: "2", Name: "modified", Labels: map[string]string{"foo": "bar"}},
+		{ID: "3", Name: "removed"},
+		{ID: "4", Name: "recreated"},
+	}
+	current := []*context.RuntimeContainer{
+		{ID: "1", Name: "kept"},
+		{ID: "2", Name: "modified", Labels: map[string]string{"foo": "baz"}},
+		{ID: "5", Name: "recreated"},
+		{ID: "0123456789abcdef", Name: "added"},
+	}
+
+	added, removed, modified := containerChanges(previous, current)
+	assert.Equal(t, []string{"recreated (5)", "added (0123456789ab)"}, added)
+	assert.Equal(t, []string{"removed (3)", "recreated (4)"}, removed)
+	assert.Equal(t, []string{"modified (2)"}, modified)
+
+	added, removed, modified = containerChanges(current, current)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, modified)
+}
+
+func TestLogContainerChangesPerConfig(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer log.SetOutput(io.Discard)
+
+	web := &context.RuntimeContainer{ID: "1", Name: "web"}
+	db := &context.RuntimeContainer{ID: "2", Name: "db"}
+	a := config.Config{Template: "a.tmpl", Dests: []string{"/etc/a.conf"}, LogChanges: true}
+	b := config.Config{Template: "b.tmpl", Dests: []string{"/etc/b.conf"}, LogChanges: true}
+	g := &generator{rendered: make(map[string][]*context.RuntimeContainer)}
+
+	g.logContainerChanges(a, []*context.RuntimeContainer{web}, true)
+	g.logContainerChanges(b, []*context.RuntimeContainer{db}, true)
+	assert.Empty(t, buf.String(), "nothing to compare to on the first render")
+
+	g.logContainerChanges(a, []*context.RuntimeContainer{web, db}, true)
+	assert.Contains(t, buf.String(), "Containers changed for '/etc/a.conf': added [db (2)], removed [], modified []")
+	assert.NotContains(t, buf.String(), "removed [db (2)]", "configs without dest are told apart by their dests")
+}
+
+func TestInspectContainers(t *testing.T) {
+	log.SetOutput(io.Discard)
+	containers := map[string]docker.Container{
+		"full": {
+			ID:   "full",
+			Name: "/full",
+			Args: []string{"--role=web", "--port=80"},
+			Config: &docker.Config{
+				Image:        "registry.example.com/app:1.0",
+				Cmd:          []string{"--role=web", "--port=80"},
+				Entrypoint:   []string{"/usr/local/bin/app"},
+				Env:          []string{"FOO=bar"},
+				Labels:       map[string]string{"com.example.foo": "bar"},
+				ExposedPorts: map[docker.Port]struct{}{"443/tcp": {}, "80/tcp": {}},
+			},
+			HostConfig:   &docker.HostConfig{PublishAllPorts: true, Memory: 536870912, CPUShares: 512, NanoCPUs: 1500000000},
+			Created:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			RestartCount: 3,
+			State: docker.State{
+				Running:   true,
+				Paused:    true,
+				Health:    docker.Health{Status: "healthy"},
+				StartedAt: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC),
+			},
+			NetworkSettings: &docker.NetworkSettings{
+				IPAddress: "10.0.0.10",
+				Networks: map[string]docker.ContainerNetwork{
+					"proxy": {IPAddress: "172.18.0.2", Aliases: []string{"web", "full"}},
+				},
+			},
+		},
+		"minimal": {
+			ID:     "minimal",
+			Name:   "/minimal",
+			Config: &docker.Config{Image: "base"},
+			NetworkSettings: &docker.NetworkSettings{
+				Networks: map[string]docker.ContainerNetwork{"bridge": {IPAddress: "172.17.0.2"}},
+			},
+		},
+		"unchecked": {
+			ID:              "unchecked",
+			Name:            "/unchecked",
+			Config:          &docker.Config{Image: "base"},
+			State:           docker.State{Running: true, Health: docker.Health{Status: "none"}},
+			NetworkSettings: &docker.NetworkSettings{},
+		},
+	}
+
+	_, serverURL := newTestDockerServer(t, containers["full"], containers["minimal"], containers["unchecked"])
+
+	generator, err := NewGenerator(GeneratorConfig{Endpoint: serverURL})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+	client, _ := generator.clients.get(serverURL)
+
+	inspected := generator.inspectContainers([]listedContainers{{
+		client:     client,
+		containers: []docker.APIContainers{{ID: "full", SizeRw: 1024, SizeRootFs: 4096}, {ID: "minimal"}, {ID: "unchecked"}},
+	}})
+	if !assert.Len(t, inspected, 3) {
+		return
+	}
+
+	full, minimal, unchecked := inspected[0], inspected[1], inspected[2]
+	assert.Equal(t, "full", full.Name)
+	assert.Equal(t, context.DockerImage{Registry: "registry.example.com", Repository: "app", Tag: "1.0"}, full.Image)
+	assert.Equal(t, map[string]string{"FOO": "bar"}, full.Env)
+	assert.Equal(t, map[string]string{"com.example.foo": "bar"}, full.Labels)
+	assert.Equal(t, "10.0.0.10", full.IP)
+	assert.Equal(t, context.State{Running: true, Paused: true, Health: "healthy"}, full.State)
+	assert.Equal(t, []string{"--role=web", "--port=80"}, full.Args)
+	assert.Equal(t, int64(1024), full.SizeRw)
+	assert.Equal(t, int64(4096), full.SizeRootFs)
+	assert.Equal(t, []string{"443/tcp", "80/tcp"}, full.ExposedPorts)
+	assert.True(t, full.PublishAllPorts)
+	assert.True(t, full.Created.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+	assert.True(t, full.StartedAt.Equal(time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)))
+
+	assert.Equal(t, "minimal", minimal.Name)
+	assert.Equal(t, context.State{}, minimal.State)
+	assert.Equal(t, []string{}, minimal.Args)
+	assert.Equal(t, []string{"--role=web", "--port=80"}, full.Cmd)
+	assert.Equal(t, []string{"/usr/local/bin/app"}, full.Entrypoint)
+	assert.Equal(t, []string{}, minimal.Cmd, "no command, an empty list")
+	assert.Equal(t, []string{}, minimal.Entrypoint, "no entrypoint, an empty list")
+	assert.Zero(t, minimal.SizeRw)
+	assert.Equal(t, []string{}, minimal.ExposedPorts)
+	assert.False(t, minimal.PublishAllPorts)
+	assert.True(t, minimal.StartedAt.IsZero())
+	assert.Equal(t, []string{"web", "full"}, full.Networks[0].Aliases)
+	assert.Equal(t, []string{}, minimal.Networks[0].Aliases, "networks without aliases have an empty list")
+	assert.Equal(t, 3, full.RestartCount)
+	assert.Zero(t, minimal.RestartCount)
+	assert.Equal(t, int64(536870912), full.MemoryLimit)
+	assert.Equal(t, int64(512), full.CPUShares)
+	assert.Equal(t, int64(1500000000), full.NanoCPUs)
+	assert.Zero(t, minimal.MemoryLimit, "no HostConfig, unlimited")
+
+	assert.Equal(t, context.State{Running: true}, unchecked.State, "containers without healthcheck have an empty health")
+}
+
+func TestSupportsAPIVersion(t *testing.T) {
+	g := &generator{}
+	assert.True(t, g.supportsAPIVersion("1.20"), "unknown versions are assumed recent")
+
+	for version, expected := range map[string]bool{"1.19": false, "1.20": true, "1.41": true} {
+		g.apiVersion, _ = docker.NewAPIVersion(version)
+		assert.Equal(t, expected, g.supportsAPIVersion("1.20"), version)
+	}
+}
+
+func TestGenerateDockerAPIVersion(t *testing.T) {
+	log.SetOutput(io.Discard)
+	server, serverURL := newTestDockerServer(t)
+	server.CustomHandler("/version", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Version":"20.10.0","ApiVersion":"1.41"}`))
+	}))
+
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{ .Docker.APIVersion }}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	dest := filepath.Join(dir, "dest")
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint:   serverURL,
+		ConfigFile: config.ConfigFile{Config: []config.Config{{Template: tmplFile, Dest: dest}}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	assert.NoError(t, generator.generateFromContainers())
+	contents, err := os.ReadFile(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.41", string(contents))
+}
+
+func TestInspectContainersGatedByAPIVersion(t *testing.T) {
+	log.SetOutput(io.Discard)
+	container := docker.Container{
+		ID:              "mounted",
+		Name:            "/mounted",
+		Config:          &docker.Config{Image: "base"},
+		NetworkSettings: &docker.NetworkSettings{},
+		Mounts:          []docker.Mount{{Source: "/src", Destination: "/dst", RW: true}},
+	}
+
+	for version, expectedMounts := range map[string]int{"1.19": 0, "1.20": 1} {
+		server, serverURL := newTestDockerServer(t, container)
+		server.CustomHandler("/version", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(fmt.Sprintf(`{"Version":"1.8.0","ApiVersion":"%s"}`, version)))
+		}))
+
+		generator, err := NewGenerator(GeneratorConfig{Endpoint: serverURL})
+		if err != nil {
+			t.Fatalf("Error creating generator: %v\n", err)
+		}
+		client, _ := generator.clients.get(serverURL)
+		inspected := generator.inspectContainers([]listedContainers{{
+			client:     client,
+			containers: []docker.APIContainers{{ID: container.ID}},
+		}})
+
+		if assert.Len(t, inspected, 1) {
+			assert.Len(t, inspected[0].Mounts, expectedMounts, version)
+		}
+	}
+}
+
+func TestGenerateInitialRetries(t *testing.T) {
+	log.SetOutput(io.Discard)
+	var lists atomic.Int32
+
+	server, serverURL := newTestDockerServer(t)
+	server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lists.Add(1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+
+	tmplFile := filepath.Join(t.TempDir(), "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	destFile := filepath.Join(t.TempDir(), "dest")
+
+	for _, test := range []struct {
+		watch         bool
+		expectedLists int32
+	}{
+		{false, 1},
+		{true, 3},
+	} {
+		lists.Store(0)
+		generator, err := NewGenerator(GeneratorConfig{
+			Endpoint: serverURL,
+			ConfigFile: config.ConfigFile{Config: []config.Config{
+				{Template: tmplFile, Dest: destFile, Watch: test.watch},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("Error creating generator: %v\n", err)
+		}
+		generator.initialBackoff = time.Millisecond
+
+		generator.generateInitial(gocontext.Background())
+		assert.Equal(t, test.expectedLists, lists.Load(), "watch=%t", test.watch)
+	}
+
+	contents, err := os.ReadFile(destFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", string(contents))
+
+	// the retries stop once ctx is canceled
+	lists.Store(-10)
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint: serverURL,
+		ConfigFile: config.ConfigFile{Config: []config.Config{
+			{Template: tmplFile, Dest: destFile, Watch: true},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+	generator.initialBackoff = time.Hour
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	generator.generateInitial(ctx)
+	assert.Equal(t, int32(-9), lists.Load())
+}
+
+func TestGenerateInitialRetriesTemplateErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+	_, serverURL := newTestDockerServer(t)
+
+	// the template fails until it is fixed, before the second retry
+	tmplFile := filepath.Join(t.TempDir(), "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{ index . 1 }}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	destFile := filepath.Join(t.TempDir(), "dest")
+
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint: serverURL,
+		ConfigFile: config.ConfigFile{Config: []config.Config{
+			{Template: tmplFile, Dest: destFile, Watch: true, NotifyCmd: "exit 1"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+	generator.initialBackoff = 50 * time.Millisecond
+	time.AfterFunc(10*time.Millisecond, func() {
+		os.WriteFile(tmplFile, []byte("{{ len . }}"), 0644)
+	})
+
+	generator.generateInitial(gocontext.Background())
+	contents, err := os.ReadFile(destFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", string(contents))
+	assert.Equal(t, int32(1), generator.notifyFailures.Load(), "the failed render is not notified")
+}
+
+func TestFetchRemoteTemplatesRetries(t *testing.T) {
+	log.SetOutput(io.Discard)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{{ len . }}`))
+	}))
+	defer server.Close()
+
+	g := &generator{
+		Configs:         config.ConfigFile{Config: []config.Config{{Template: server.URL + "/retried.tmpl"}}},
+		initialAttempts: 5,
+		initialBackoff:  time.Millisecond,
+	}
+	assert.NoError(t, g.fetchRemoteTemplates(gocontext.Background()))
+	assert.Equal(t, int32(3), requests.Load())
+
+	requests.Store(-10)
+	g.initialBackoff = time.Hour
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	assert.Error(t, g.fetchRemoteTemplates(ctx), "the retries stop once ctx is canceled")
+	assert.Equal(t, int32(-9), requests.Load())
+	g.initialBackoff = time.Millisecond
+
+	requests.Store(0)
+	g.Configs.Config[0].Template = server.URL + "/failing.tmpl"
+	g.initialAttempts = 2
+	assert.Error(t, g.fetchRemoteTemplates(gocontext.Background()))
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestFirstRunCmd(t *testing.T) {
+	log.SetOutput(io.Discard)
+	out := filepath.Join(t.TempDir(), "out")
+	cfg := config.Config{
+		Template:    "foo.tmpl",
+		Dest:        "foo",
+		FirstRunCmd: "echo first >> " + out,
+		NotifyCmd:   "echo notify >> " + out,
+	}
+	g := &generator{ran: make(map[string]bool)}
+
+	for i := 0; i < 3; i++ {
+		g.runNotifyCmd(cfg, g.firstRun(cfg))
+	}
+	contents, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nnotify\nnotify\n", string(contents))
+
+	other := cfg
+	other.Dest = "bar"
+	assert.True(t, g.firstRun(other), "configs are tracked independently")
+	assert.False(t, g.firstRun(other))
+
+	// without a first run command, the notify command runs the first time too
+	cfg.Template, cfg.FirstRunCmd = "bar.tmpl", ""
+	g.runNotifyCmd(cfg, g.firstRun(cfg))
+	contents, err = os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nnotify\nnotify\nnotify\n", string(contents))
+}
+
+func TestNotifyCmdEnv(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	out := filepath.Join(dir, "out")
+	cfg := config.Config{
+		Template:    tmplFile,
+		Dest:        filepath.Join(dir, "a.conf"),
+		Dests:       []string{filepath.Join(dir, "b.conf")},
+		FirstRunCmd: "true",
+		NotifyCmd:   `echo "$DOCKER_GEN_TEMPLATE $DOCKER_GEN_DEST $DOCKER_GEN_DESTS $DOCKER_GEN_CHANGED $DOCKER_GEN_CONTAINER_COUNT" >> ` + out,
+	}
+	g := &generator{ran: make(map[string]bool)}
+	g.firstRun(cfg)
+
+	containers := []*context.RuntimeContainer{
+		{ID: "1", State: context.State{Running: true}},
+		{ID: "2"},
+	}
+	g.generateConfig(cfg, containers)
+	contents, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s %s %s:%s true 1\n", tmplFile, cfg.Dest, cfg.Dest, cfg.Dests[0]), string(contents))
+
+	assert.Equal(t, []string{
+		"DOCKER_GEN_TEMPLATE=" + tmplFile,
+		"DOCKER_GEN_DEST=",
+		"DOCKER_GEN_DESTS=",
+		"DOCKER_GEN_CHANGED=false",
+		"DOCKER_GEN_CONTAINER_COUNT=0",
+	}, notifyEnv(config.Config{Template: tmplFile}, false, 0), "without destination")
+}
+
+func TestRunNotifyCmdLogsExitCode(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer log.SetOutput(io.Discard)
+
+	g := &generator{}
+	g.runNotifyCmd(config.Config{NotifyCmd: "true"}, false)
+	assert.NotContains(t, buf.String(), "Ran 'true'", "successful runs are logged at debug")
+
+	logging.SetLevel(logging.DebugLevel)
+	defer logging.SetLevel(logging.InfoLevel)
+	g.runNotifyCmd(config.Config{NotifyCmd: "true"}, false)
+	assert.Regexp(t, `Ran 'true' \(exit code 0 after [\d.]+m?s\)`, buf.String())
+
+	buf.Reset()
+	g.runNotifyCmd(config.Config{NotifyCmd: "exit 3"}, false)
+	assert.Regexp(t, `Error running notify command: exit 3, exit status 3 \(exit code 3 after [\d.]+m?s\)`, buf.String())
+
+	assert.Equal(t, -1, exitCode(errors.New("not started")))
+}
+
+func TestRunNotifyCmdRetries(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer log.SetOutput(io.Discard)
+
+	// fails twice, then succeeds
+	counter := filepath.Join(t.TempDir(), "counter")
+	flaky := fmt.Sprintf("n=$(cat %[1]s 2>/dev/null || echo 0); n=$((n+1)); echo $n > %[1]s; [ $n -ge 3 ]", counter)
+	g := &generator{}
+	g.runNotifyCmd(config.Config{NotifyCmd: flaky, NotifyRetries: 5, NotifyRetryInterval: time.Millisecond}, false)
+	contents, err := os.ReadFile(counter)
+	assert.NoError(t, err)
+	assert.Equal(t, "3\n", string(contents), "retries stop at the first success")
+	assert.Contains(t, buf.String(), "(attempt 3/6)")
+	assert.NotContains(t, buf.String(), "giving up")
+
+	buf.Reset()
+	g.runNotifyCmd(config.Config{NotifyCmd: "exit 1", NotifyRetries: 2}, false)
+	assert.Equal(t, 3, strings.Count(buf.String(), "Running 'exit 1'"))
+	assert.Contains(t, buf.String(), "Error running notify command: exit 1, giving up after 3 attempts")
+
+	buf.Reset()
+	g.runNotifyCmd(config.Config{NotifyCmd: "exit 1"}, false)
+	assert.Equal(t, 1, strings.Count(buf.String(), "Running 'exit 1'"), "no retry by default")
+	assert.NotContains(t, buf.String(), "giving up")
+
+	buf.Reset()
+	done := make(chan struct{})
+	close(done)
+	g.done = done
+	g.runNotifyCmd(config.Config{NotifyCmd: "exit 1", NotifyRetries: 2, NotifyRetryInterval: time.Hour}, false)
+	assert.Equal(t, 1, strings.Count(buf.String(), "Running 'exit 1'"), "no retry on shutdown")
+	assert.Contains(t, buf.String(), "giving up after 1 attempts")
+}
+
+func TestTogglePause(t *testing.T) {
+	log.SetOutput(io.Discard)
+	var lists atomic.Int32
+
+	server, serverURL := newTestDockerServer(t)
+	server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lists.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+
+	tmplFile := filepath.Join(t.TempDir(), "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	cfg := config.Config{Template: tmplFile, Dest: filepath.Join(t.TempDir(), "dest"), Watch: true, Interval: 1}
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint:   serverURL,
+		ConfigFile: config.ConfigFile{Config: []config.Config{cfg}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	generator.togglePause()
+	generator.generateFromContainers()
+	signature := ""
+	generator.generateFromEvent(cfg, &signature)
+	assert.Equal(t, int32(0), lists.Load(), "nothing is generated while paused")
+	_, err = os.Stat(cfg.Dest)
+	assert.True(t, os.IsNotExist(err))
+
+	generator.togglePause()
+	assert.Equal(t, int32(1), lists.Load(), "a generation runs on resume")
+	contents, err := os.ReadFile(cfg.Dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", string(contents))
+}
+
+func TestGenerateMultipleDestinationsNotifiesOnce(t *testing.T) {
+	log.SetOutput(io.Discard)
+	_, serverURL := newTestDockerServer(t)
+
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	notified := filepath.Join(dir, "notified")
+	cfg := config.Config{
+		Template:  tmplFile,
+		Dest:      filepath.Join(dir, "nginx.conf"),
+		Dests:     []string{filepath.Join(dir, "upstreams.map")},
+		NotifyCmd: "echo reload >> " + notified,
+	}
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint:   serverURL,
+		ConfigFile: config.ConfigFile{Config: []config.Config{cfg}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	assert.NoError(t, generator.generateFromContainers())
+	for _, dest := range cfg.Destinations() {
+		contents, err := os.ReadFile(dest)
+		assert.NoError(t, err)
+		assert.Equal(t, "0", string(contents))
+	}
+	contents, err := os.ReadFile(notified)
+	assert.NoError(t, err)
+	assert.Equal(t, "reload\n", string(contents), "changing both destinations notifies once")
+
+	assert.NoError(t, generator.generateFromContainers())
+	contents, err = os.ReadFile(notified)
+	assert.NoError(t, err)
+	assert.Equal(t, "reload\n", string(contents), "unchanged destinations do not notify")
+}
+
+func TestGenerateConfigsInOrder(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	notified := filepath.Join(dir, "notified")
+	configs := []config.Config{}
+	for i := 0; i < 8; i++ {
+		configs = append(configs, config.Config{
+			Template:  tmplFile,
+			Dest:      filepath.Join(dir, fmt.Sprintf("%d.conf", i)),
+			NotifyCmd: fmt.Sprintf("echo %d >> %s", i, notified),
+		})
+	}
+	// a destination under a file cannot be written
+	configs = append(configs, config.Config{Template: tmplFile, Dest: filepath.Join(tmplFile, "invalid.conf")})
+
+	g := &generator{Concurrency: 3, ran: make(map[string]bool)}
+	err := g.generateConfigs(configs, func(config.Config) []*context.RuntimeContainer { return nil })
+	assert.ErrorContains(t, err, "invalid.conf", "the errors of the configs are returned")
+
+	contents, err := os.ReadFile(notified)
+	assert.NoError(t, err)
+	assert.Equal(t, "0\n1\n2\n3\n4\n5\n6\n7\n", string(contents), "the configs are notified in order")
+}
+
+func TestGenerateFromConfigEndpoints(t *testing.T) {
+	log.SetOutput(io.Discard)
+	_, endpointA := newTestDockerServer(t, runningContainer("a1"))
+	serverB, endpointB := newTestDockerServer(t, runningContainer("b1"), runningContainer("b2"))
+
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{range .}}{{.Name}} {{end}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint: endpointA,
+		ConfigFile: config.ConfigFile{Config: []config.Config{
+			{Template: tmplFile, Dest: filepath.Join(dir, "a")},
+			{Template: tmplFile, Dest: filepath.Join(dir, "b"), Endpoint: endpointB},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	assert.NoError(t, generator.generateFromContainers())
+	contents, err := os.ReadFile(filepath.Join(dir, "a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a1 ", string(contents))
+	contents, err = os.ReadFile(filepath.Join(dir, "b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b1 b2 ", string(contents))
+
+	client, err := generator.clients.get(endpointB)
+	assert.NoError(t, err)
+	cached, err := generator.configClient(config.Config{Endpoint: endpointB})
+	assert.NoError(t, err)
+	assert.Same(t, client, cached, "endpoint clients are cached")
+	global, err := generator.configClient(config.Config{})
+	assert.NoError(t, err)
+	client, err = generator.clients.get(generator.Endpoint)
+	assert.NoError(t, err)
+	assert.Same(t, client, global)
+
+	// an unreachable endpoint only skips its own configs
+	serverB.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	_, endpointC := newTestDockerServer(t, runningContainer("c1"))
+	generator.Configs.Config = append(generator.Configs.Config, config.Config{Template: tmplFile, Dest: filepath.Join(dir, "c"), Endpoint: endpointC})
+	assert.Error(t, generator.generateFromContainers())
+	contents, err = os.ReadFile(filepath.Join(dir, "c"))
+	assert.NoError(t, err)
+	assert.Equal(t, "c1 ", string(contents))
+	contents, err = os.ReadFile(filepath.Join(dir, "b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b1 b2 ", string(contents), "the configs of the failing endpoint are kept as is")
+}
+
+func TestNextEventRetry(t *testing.T) {
+	g := &generator{eventRetryInterval: 10 * time.Second}
+	assert.Equal(t, 10*time.Second, g.nextEventRetry(10*time.Second), "no backoff by default")
+
+	g.eventRetryMaxInterval = time.Minute
+	backoff := g.eventRetryInterval
+	var delays []time.Duration
+	for i := 0; i < 4; i++ {
+		backoff = g.nextEventRetry(backoff)
+		delays = append(delays, backoff)
+	}
+	assert.Equal(t, []time.Duration{20 * time.Second, 40 * time.Second, time.Minute, time.Minute}, delays)
+}
+
+func TestEventWatcher(t *testing.T) {
+	defaults := newEventWatcher(nil, config.Config{Watch: true})
+	health := newEventWatcher(nil,
+		config.Config{Watch: true, WatchEvents: []string{"start", "die"}},
+		config.Config{Watch: true, WatchEvents: []string{"health_status"}, Services: true},
+	)
+
+	for _, test := range []struct {
+		event    docker.APIEvents
+		defaults bool
+		health   bool
+	}{
+		{docker.APIEvents{Status: "start"}, true, true},
+		{docker.APIEvents{Status: "stop"}, true, false},
+		{docker.APIEvents{Status: "die"}, true, true},
+		{docker.APIEvents{Status: "health_status: healthy"}, false, true},
+		{docker.APIEvents{Status: "destroy"}, false, false},
+		{docker.APIEvents{Type: "service", Action: "update"}, false, true},
+	} {
+		assert.Equal(t, test.defaults, defaults.watches(&test.event), "default events, %+v", test.event)
+		assert.Equal(t, test.health, health.watches(&test.event), "configured events, %+v", test.event)
+	}
+}
+
+func TestRegenerateOnSIGUSR1(t *testing.T) {
+	log.SetOutput(io.Discard)
+	// keep the test process alive until generateFromSignals handles SIGUSR1
+	guard := make(chan os.Signal, 10)
+	signal.Notify(guard, syscall.SIGUSR1)
+	defer signal.Stop(guard)
+
+	_, serverURL := newTestDockerServer(t)
+
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	// the configs watching events, the ones generated at an interval only,
+	// and the ones read from config files, that a reload may make watch
+	for i, test := range []struct {
+		cfg         config.Config
+		configFiles []string
+	}{
+		{config.Config{Watch: true}, nil},
+		{config.Config{Interval: 3600}, nil},
+		{config.Config{}, []string{filepath.Join(dir, "docker-gen.cfg")}},
+	} {
+		cfg := test.cfg
+		cfg.Template = tmplFile
+		cfg.Dest = filepath.Join(dir, fmt.Sprintf("dest%d", i))
+		generator, err := NewGenerator(GeneratorConfig{
+			Endpoint:    serverURL,
+			ConfigFile:  config.ConfigFile{Config: []config.Config{cfg}},
+			ConfigFiles: test.configFiles,
+		})
+		if err != nil {
+			t.Fatalf("Error creating generator: %v\n", err)
+		}
+
+		ctx, cancel := gocontext.WithCancel(gocontext.Background())
+		generator.generateFromSignals(ctx)
+		assert.Eventually(t, func() bool {
+			syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+			_, err := os.Stat(cfg.Dest)
+			return err == nil
+		}, 5*time.Second, 50*time.Millisecond, "SIGUSR1 regenerates the configs, %+v", cfg)
+		cancel()
+		generator.wg.Wait()
+	}
+}
+
+func TestGenerateFromSettledEventsListsOnce(t *testing.T) {
+	log.SetOutput(io.Discard)
+	var lists atomic.Int32
+
+	server, serverURL := newTestDockerServer(t)
+	server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lists.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	configs := []config.Config{
+		{Template: tmplFile, Dest: filepath.Join(dir, "a"), Watch: true},
+		{Template: tmplFile, Dest: filepath.Join(dir, "b"), Watch: true, SkipUnchanged: true},
+	}
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint:   serverURL,
+		ConfigFile: config.ConfigFile{Wait: &config.Wait{Min: time.Millisecond, Max: time.Second}, Config: configs},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	signatures := []*string{new(string), new(string)}
+	generator.generateFromSettledEvents(configs, signatures)
+	assert.Equal(t, int32(1), lists.Load(), "containers are listed once for all the configs")
+	for _, cfg := range configs {
+		contents, err := os.ReadFile(cfg.Dest)
+		assert.NoError(t, err)
+		assert.Equal(t, "0", string(contents))
+	}
+	assert.Empty(t, *signatures[0])
+	assert.NotEmpty(t, *signatures[1])
+
+	assert.NoError(t, os.Remove(configs[1].Dest))
+	generator.generateFromSettledEvents(configs, signatures)
+	assert.Equal(t, int32(2), lists.Load())
+	_, err = os.Stat(configs[1].Dest)
+	assert.True(t, os.IsNotExist(err), "unchanged container list skips the generation")
+}
+
+func TestGetServices(t *testing.T) {
+	log.SetOutput(io.Discard)
+	server, serverURL := newTestDockerServer(t)
+	server.CustomHandler("/services", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"ID":"web","Spec":{"Name":"web","Labels":{"com.example.role":"frontend"},
+				"TaskTemplate":{"ContainerSpec":{"Image":"nginx:1.25@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"}},
+				"Mode":{"Replicated":{"Replicas":3}}},
+				"Endpoint":{"Ports":[{"Protocol":"tcp","TargetPort":80,"PublishedPort":8080,"PublishMode":"ingress"}]}},
+			{"ID":"agent","Spec":{"Name":"agent","TaskTemplate":{"ContainerSpec":{"Image":"agent"}},"Mode":{"Global":{}}}},
+			{"ID":"broken","Spec":{"Name":"broken","Mode":{"Global":{}}}}
+		]`))
+	}))
+	server.CustomHandler("/tasks", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var filters map[string][]string
+		json.Unmarshal([]byte(r.FormValue("filters")), &filters)
+		w.Header().Set("Content-Type", "application/json")
+		switch filters["service"][0] {
+		case "web":
+			w.Write([]byte(`[{"Status":{"State":"running"}},{"Status":{"State":"running"}},{"Status":{"State":"shutdown"}}]`))
+		case "agent":
+			w.Write([]byte(`[{"Status":{"State":"running"}}]`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	generator, err := NewGenerator(GeneratorConfig{Endpoint: serverURL})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	services, err := generator.getServices(config.Config{})
+	assert.NoError(t, err)
+	if !assert.Len(t, services, 2, "services whose tasks cannot be listed are skipped") {
+		return
+	}
+
+	web, agent := services[0], services[1]
+	assert.Equal(t, "web", web.Name)
+	assert.Equal(t, context.DockerImage{Repository: "nginx", Tag: "1.25"}, web.Image)
+	assert.Equal(t, map[string]string{"com.example.role": "frontend"}, web.Labels)
+	assert.Equal(t, "replicated", web.Mode)
+	assert.Equal(t, uint64(3), web.Replicas)
+	assert.Equal(t, 2, web.RunningTasks)
+	assert.Equal(t, []context.ServicePort{{Proto: "tcp", TargetPort: 80, PublishedPort: 8080, PublishMode: "ingress"}}, web.Ports)
+
+	assert.Equal(t, "global", agent.Mode)
+	assert.Equal(t, uint64(1), agent.Replicas)
+	assert.Equal(t, []context.ServicePort{}, agent.Ports)
+}
+
+func TestDryRun(t *testing.T) {
+	log.SetOutput(io.Discard)
+	_, serverURL := newTestDockerServer(t)
+
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	notified := filepath.Join(dir, "notified")
+	cfg := config.Config{
+		Template:  tmplFile,
+		Dest:      filepath.Join(dir, "nginx.conf"),
+		Watch:     true,
+		Interval:  1,
+		NotifyCmd: "echo reload >> " + notified,
+	}
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint:   serverURL,
+		ConfigFile: config.ConfigFile{Config: []config.Config{cfg}},
+		DryRun:     true,
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- generator.Generate() }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("a dry run should not watch events nor generate at intervals")
+	}
+
+	_, err = os.Stat(cfg.Dest)
+	assert.True(t, os.IsNotExist(err), "the destination is not written")
+	_, err = os.Stat(notified)
+	assert.True(t, os.IsNotExist(err), "the notify command is not run")
+}
+
+func TestOnce(t *testing.T) {
+	log.SetOutput(io.Discard)
+	_, serverURL := newTestDockerServer(t)
+
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+
+	for notifyCmd, expectError := range map[string]bool{"true": false, "false": true} {
+		cfg := config.Config{
+			Template:  tmplFile,
+			Dest:      filepath.Join(dir, notifyCmd+".conf"),
+			Watch:     true,
+			Interval:  1,
+			NotifyCmd: notifyCmd,
+		}
+		generator, err := NewGenerator(GeneratorConfig{
+			Endpoint:   serverURL,
+			ConfigFile: config.ConfigFile{Config: []config.Config{cfg}},
+			Once:       true,
+		})
+		if err != nil {
+			t.Fatalf("Error creating generator: %v\n", err)
+		}
+
+		done := make(chan error)
+		go func() { done <- generator.Generate() }()
+		select {
+		case err := <-done:
+			if expectError {
+				assert.EqualError(t, err, "1 notify command(s) failed")
+			} else {
+				assert.NoError(t, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("a single run should not watch events nor generate at intervals")
+		}
+
+		contents, err := os.ReadFile(cfg.Dest)
+		assert.NoError(t, err)
+		assert.Equal(t, "0", string(contents))
+	}
+}
+
+func TestListContainersFilters(t *testing.T) {
+	log.SetOutput(io.Discard)
+	containersByLabel := map[string][]docker.APIContainers{
+		"com.example.app=web": {{ID: "web1"}, {ID: "both"}},
+		"com.example.app=db":  {{ID: "db1"}, {ID: "both"}},
+	}
+	var requested []string
+	server, serverURL := newTestDockerServer(t)
+	server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var filters map[string][]string
+		json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		containers := []docker.APIContainers{{ID: "web1"}, {ID: "db1"}, {ID: "both"}, {ID: "other"}}
+		if labels, ok := filters["label"]; ok {
+			requested = append(requested, labels[0])
+			containers = containersByLabel[labels[0]]
+		} else {
+			requested = append(requested, "")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(containers)
+	}))
+
+	ids := func(listed []listedContainers) []string {
+		ids := []string{}
+		for _, l := range listed {
+			for _, c := range l.containers {
+				ids = append(ids, c.ID)
+			}
+		}
+		return ids
+	}
+	web := config.Config{Template: "web.tmpl", ContainerFilter: map[string][]string{"label": {"com.example.app=web"}}}
+	db := config.Config{Template: "db.tmpl", ContainerFilter: map[string][]string{"label": {"com.example.app=db"}}}
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint:   serverURL,
+		ConfigFile: config.ConfigFile{Config: []config.Config{web, db}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	listed, err := generator.listContainers(web)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web1", "both", "db1"}, ids(listed), "the union of the filtered containers is listed once")
+	assert.Equal(t, []string{"com.example.app=web", "com.example.app=db"}, requested)
+
+	requested = nil
+	generator.Configs.Config = append(generator.Configs.Config, config.Config{Template: "all.tmpl"})
+	listed, err = generator.listContainers(web)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web1", "db1", "both", "other"}, ids(listed), "a config without filter needs all the containers")
+	assert.Equal(t, []string{""}, requested)
+}
+
+// generatorGoroutines returns the number of goroutines running code of the
+// generator package, other than the tests
+func generatorGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	count := 0
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, "internal/generator.") && !strings.Contains(stack, "internal/generator.Test") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestShutdownOnCancel(t *testing.T) {
+	log.SetOutput(io.Discard)
+	before := generatorGoroutines()
+
+	server, serverURL := newTestDockerServer(t)
+	server.CustomHandler("/events", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a stream without events, until the client goes away
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{len .}}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	generator, err := NewGenerator(GeneratorConfig{
+		Endpoint: serverURL,
+		ConfigFile: config.ConfigFile{Config: []config.Config{
+			{Template: tmplFile, Dest: filepath.Join(dir, "watched"), Watch: true, Wait: &config.Wait{Min: time.Second, Max: 2 * time.Second}},
+			{Template: tmplFile, Dest: filepath.Join(dir, "interval"), Interval: 60},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	generator.pauseOnSignal(ctx)
+	generator.generateAtInterval(ctx)
+	generator.generateFromEvents(ctx)
+	generator.generateFromSignals(ctx)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "watched"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "the event listener is connected")
+	assert.Greater(t, generatorGoroutines(), before)
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		generator.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watchers did not stop")
+	}
+	assert.Eventually(t, func() bool {
+		return generatorGoroutines() <= before
+	}, 5*time.Second, 10*time.Millisecond, "no goroutine of the generator remains")
+}
+
+func TestSendSignalToContainerPatterns(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer log.SetOutput(io.Discard)
+
+	var mu sync.Mutex
+	killed := []string{}
+	server, serverURL := newTestDockerServer(t)
+	server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]docker.APIContainers{
+			{ID: "id-web-1", Names: []string{"/web-1"}},
+			{ID: "id-web-2", Names: []string{"/web-2"}},
+			{ID: "id-api", Names: []string{"/api"}},
+		})
+	}))
+	server.CustomHandler("/containers/[^/]+/kill", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		killed = append(killed, strings.Split(r.URL.Path, "/")[2]+":"+r.URL.Query().Get("signal"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	generator, err := NewGenerator(GeneratorConfig{Endpoint: serverURL})
+	if err != nil {
+		t.Fatalf("Error creating generator: %v\n", err)
+	}
+
+	generator.sendSignalToContainer(config.Config{
+		NotifyContainersMatch: "glob",
+		NotifyContainers:      map[string]int{"web-*": 1, "db-*": 1},
+	})
+	assert.ElementsMatch(t, []string{"id-web-1:1", "id-web-2:1"}, killed)
+	assert.Contains(t, buf.String(), "Notify pattern 'web-*' matches containers web-1, web-2")
+	assert.Contains(t, buf.String(), "WARN No container matches notify pattern 'db-*'")
+
+	killed = nil
+	generator.sendSignalToContainer(config.Config{
+		NotifyContainersMatch: "regex",
+		NotifyContainers:      map[string]int{"web-[2-9]|api": 15},
+	})
+	assert.ElementsMatch(t, []string{"id-web-2:15", "id-api:15"}, killed)
+
+	killed = nil
+	generator.sendSignalToContainer(config.Config{NotifyContainers: map[string]int{"web-1": 1}})
+	assert.Equal(t, []string{"web-1:1"}, killed, "keys are used as is by default")
+}
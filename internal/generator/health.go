@@ -0,0 +1,87 @@
+package generator
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// health tracks the last successful contact with docker: a container listing
+// or a liveness ping of the events watcher, and the event listeners that are
+// disconnected from their daemon. It serves /healthz, healthy while that
+// contact is more recent than window and no event listener is disconnected.
+type health struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	// disconnected holds the endpoints of the disconnected event listeners,
+	// by listener
+	disconnected map[string]string
+}
+
+// record marks a successful contact with docker
+func (h *health) record() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = time.Now()
+}
+
+// listening records whether the event listener id, of endpoint, is connected
+// to its daemon. A stopped listener is not disconnected anymore.
+func (h *health) listening(id, endpoint string, connected bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if connected {
+		delete(h.disconnected, id)
+		return
+	}
+	if h.disconnected == nil {
+		h.disconnected = make(map[string]string)
+	}
+	h.disconnected[id] = endpoint
+}
+
+// disconnectedEndpoints returns the sorted endpoints of the disconnected event
+// listeners
+func (h *health) disconnectedEndpoints() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	endpoints := []string{}
+	for _, endpoint := range h.disconnected {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+// since returns the time elapsed since the last successful contact, and
+// whether there was any
+func (h *health) since() (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.last.IsZero() {
+		return 0, false
+	}
+	return time.Since(h.last), true
+}
+
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	elapsed, ok := h.since()
+	if !ok {
+		http.Error(w, "docker was never reached", http.StatusServiceUnavailable)
+		return
+	}
+	if elapsed > h.window {
+		http.Error(w, fmt.Sprintf("docker was last reached %s ago", elapsed.Round(time.Second)), http.StatusServiceUnavailable)
+		return
+	}
+	if endpoints := h.disconnectedEndpoints(); len(endpoints) > 0 {
+		http.Error(w, fmt.Sprintf("docker events of %s are disconnected", strings.Join(endpoints, ", ")), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,55 @@
+package generator
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthz(t *testing.T) {
+	h := &health{window: time.Minute}
+	status := func() int {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, status(), "docker never reached")
+
+	h.record()
+	assert.Equal(t, http.StatusOK, status())
+
+	h.last = time.Now().Add(-2 * time.Minute)
+	assert.Equal(t, http.StatusServiceUnavailable, status(), "last contact out of the window")
+
+	h.record()
+	h.listening("a", "unix:///var/run/docker.sock", false)
+	assert.Equal(t, http.StatusServiceUnavailable, status(), "event listener disconnected, even if docker was listed")
+	h.listening("a", "unix:///var/run/docker.sock", true)
+	assert.Equal(t, http.StatusOK, status())
+}
+
+func TestHealthServer(t *testing.T) {
+	log.SetOutput(io.Discard)
+	h := &health{window: time.Minute}
+	h.record()
+
+	server, err := startServer("127.0.0.1:0", "/healthz", h)
+	if err != nil {
+		t.Fatalf("Error serving health endpoint: %v\n", err)
+	}
+	response, err := http.Get("http://" + server.Addr + "/healthz")
+	if assert.NoError(t, err) {
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+
+	shutdownServer(server)
+	_, err = http.Get("http://" + server.Addr + "/healthz")
+	assert.Error(t, err, "server is shut down")
+}
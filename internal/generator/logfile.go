@@ -0,0 +1,67 @@
+package generator
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/nginx-proxy/docker-gen/internal/logging"
+)
+
+// logFile is the file the logs are written to. It is reopened on SIGUSR1 so
+// that an external tool like logrotate can rotate it.
+type logFile struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openLogFile(path string) (*logFile, error) {
+	l := &logFile{path: path}
+	if err := l.reopen(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Write(p)
+}
+
+// reopen opens the log file path again, creating it if it was moved away,
+// and closes the previous file
+func (l *logFile) reopen() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	previous := l.file
+	l.file = file
+	l.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// reopenOnSignal reopens the log file every time docker-gen receives SIGUSR1
+func (l *logFile) reopenOnSignal() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1)
+	go func() {
+		for range sigChan {
+			if err := l.reopen(); err != nil {
+				logging.Errorf("Error reopening log file %s: %s\n", l.path, err)
+				continue
+			}
+			logging.Infof("Reopened log file %s", l.path)
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "docker-gen.log")
+	l, err := openLogFile(path)
+	if err != nil {
+		t.Fatalf("Error opening log file: %v\n", err)
+	}
+
+	l.Write([]byte("before rotation\n"))
+	assert.NoError(t, os.Rename(path, path+".1"))
+	l.Write([]byte("still in the rotated file\n"))
+
+	l.reopenOnSignal()
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	assert.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		current, err := l.file.Stat()
+		if err != nil {
+			return false
+		}
+		reopened, err := os.Stat(path)
+		return err == nil && os.SameFile(current, reopened)
+	}, time.Second, 10*time.Millisecond)
+	l.Write([]byte("after rotation\n"))
+
+	rotated, err := os.ReadFile(path + ".1")
+	assert.NoError(t, err)
+	assert.Equal(t, "before rotation\nstill in the rotated file\n", string(rotated))
+	contents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "after rotation\n", string(contents))
+
+	_, err = openLogFile(filepath.Join(t.TempDir(), "missing", "docker-gen.log"))
+	assert.Error(t, err)
+}
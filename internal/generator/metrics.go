@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/nginx-proxy/docker-gen/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metrics exposed on /metrics when docker-gen runs with -metrics-listen
+var (
+	generationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "docker_gen",
+		Name:      "generations_total",
+		Help:      "Number of template generations.",
+	})
+	generationsChangedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "docker_gen",
+		Name:      "generations_changed_total",
+		Help:      "Number of template generations that changed the output.",
+	})
+	generationFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "docker_gen",
+		Name:      "generation_failures_total",
+		Help:      "Number of template generations that failed to render or to write a destination, by config.",
+	}, []string{"config"})
+	notifyCommandsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "docker_gen",
+		Name:      "notify_commands_total",
+		Help:      "Number of notify commands run.",
+	})
+	notifyCommandFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "docker_gen",
+		Name:      "notify_command_failures_total",
+		Help:      "Number of notify commands that failed.",
+	})
+	dockerReconnectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "docker_gen",
+		Name:      "docker_reconnections_total",
+		Help:      "Number of docker clients reset after a connection failure.",
+	})
+	getContainersDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "docker_gen",
+		Name:      "get_containers_duration_seconds",
+		Help:      "Duration of the listing and inspection of the containers.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// recordGeneration counts a generation of a template
+func recordGeneration(changed bool) {
+	generationsTotal.Inc()
+	if changed {
+		generationsChangedTotal.Inc()
+	}
+}
+
+// recordGenerationFailure counts a generation of cfg that failed to render its
+// template or to write one of its destinations
+func recordGenerationFailure(cfg config.Config) {
+	generationFailuresTotal.WithLabelValues(configLabel(cfg)).Inc()
+}
+
+// configLabel identifies a config in the metrics, by its template and
+// destinations
+func configLabel(cfg config.Config) string {
+	dests := cfg.Destinations()
+	if len(dests) == 0 {
+		dests = []string{"stdout"}
+	}
+	return cfg.Template + " -> " + strings.Join(dests, ", ")
+}
@@ -0,0 +1,95 @@
+package generator
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nginx-proxy/docker-gen/internal/config"
+	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerationMetrics(t *testing.T) {
+	generations := testutil.ToFloat64(generationsTotal)
+	changed := testutil.ToFloat64(generationsChangedTotal)
+
+	recordGeneration(true)
+	recordGeneration(false)
+
+	assert.Equal(t, generations+2, testutil.ToFloat64(generationsTotal))
+	assert.Equal(t, changed+1, testutil.ToFloat64(generationsChangedTotal))
+}
+
+func TestGenerationFailureMetrics(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{ index . 1 }}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	g := &generator{
+		rendered: make(map[string][]*context.RuntimeContainer),
+		ran:      make(map[string]bool),
+	}
+	failing := config.Config{Template: tmplFile, Dest: filepath.Join(dir, "out")}
+	unwritable := config.Config{Template: tmplFile, Dest: filepath.Join(dir, "missing", "out")}
+
+	assert.Error(t, g.generateConfig(failing, nil), "the template fails to render")
+	assert.Equal(t, 1.0, testutil.ToFloat64(generationFailuresTotal.WithLabelValues(tmplFile+" -> "+failing.Dest)))
+
+	if err := os.WriteFile(tmplFile, []byte("{{ len . }}"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v\n", err)
+	}
+	assert.NoError(t, g.generateConfig(failing, nil))
+	assert.Error(t, g.generateConfig(unwritable, nil), "the destination cannot be written")
+	assert.Equal(t, 1.0, testutil.ToFloat64(generationFailuresTotal.WithLabelValues(tmplFile+" -> "+failing.Dest)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(generationFailuresTotal.WithLabelValues(tmplFile+" -> "+unwritable.Dest)))
+}
+
+func TestNotifyMetrics(t *testing.T) {
+	log.SetOutput(io.Discard)
+	g := &generator{}
+	notified := testutil.ToFloat64(notifyCommandsTotal)
+	failed := testutil.ToFloat64(notifyCommandFailuresTotal)
+
+	g.runNotifyCmd(config.Config{NotifyCmd: "true"}, false)
+	g.runNotifyCmd(config.Config{NotifyCmd: "exit 3"}, false)
+	g.runNotifyCmd(config.Config{}, false)
+
+	assert.Equal(t, notified+2, testutil.ToFloat64(notifyCommandsTotal))
+	assert.Equal(t, failed+1, testutil.ToFloat64(notifyCommandFailuresTotal))
+}
+
+func TestMetricsServer(t *testing.T) {
+	log.SetOutput(io.Discard)
+	server, err := startServer("127.0.0.1:0", "/metrics", promhttp.Handler())
+	if err != nil {
+		t.Fatalf("Error serving metrics endpoint: %v\n", err)
+	}
+	defer shutdownServer(server)
+
+	response, err := http.Get("http://" + server.Addr + "/metrics")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	for _, metric := range []string{
+		"docker_gen_generations_total",
+		"docker_gen_generations_changed_total",
+		"docker_gen_generation_failures_total",
+		"docker_gen_notify_commands_total",
+		"docker_gen_notify_command_failures_total",
+		"docker_gen_get_containers_duration_seconds",
+	} {
+		assert.True(t, strings.Contains(string(body), metric), metric)
+	}
+}
@@ -0,0 +1,38 @@
+package generator
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nginx-proxy/docker-gen/internal/logging"
+)
+
+// startServer serves handler on addr at path until the returned server is
+// shut down
+func startServer(addr, path string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	server := &http.Server{Addr: listener.Addr().String(), Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logging.Errorf("Error serving %s: %s\n", path, err)
+		}
+	}()
+	logging.Infof("Serving %s on %s", path, server.Addr)
+	return server, nil
+}
+
+// shutdownServer stops server, letting the in-flight requests complete
+func shutdownServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logging.Errorf("Error shutting down server: %s\n", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Package logging is the leveled logger of docker-gen. It writes through the
+// standard log package, so that log.SetOutput, like -log-file, applies to it,
+// either as messages prefixed with their level or as JSON lines.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a message. Messages below the level set with
+// SetLevel are dropped.
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s: debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("invalid log level %q: must be one of %s", s, strings.Join(levelNames, ", "))
+}
+
+// Format is the way messages are written.
+type Format int32
+
+const (
+	// TextFormat writes the message prefixed with its level, with the flags of
+	// the standard log package. The fields are not written, the messages
+	// already telling them.
+	TextFormat Format = iota
+	// JSONFormat writes one JSON object per message, with its time, level and
+	// message along with its fields.
+	JSONFormat
+)
+
+// ParseFormat returns the format named s: text or json.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "text":
+		return TextFormat, nil
+	case "json":
+		return JSONFormat, nil
+	}
+	return TextFormat, fmt.Errorf("invalid log format %q: must be text or json", s)
+}
+
+var (
+	level  atomic.Int32
+	format atomic.Int32
+)
+
+func init() {
+	level.Store(int32(InfoLevel))
+}
+
+// SetLevel sets the minimum level of the logged messages, info by default.
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// SetFormat sets the format of the logged messages, text by default. The JSON
+// lines having their own time, JSONFormat clears the flags of the standard
+// logger.
+func SetFormat(f Format) {
+	format.Store(int32(f))
+	if f == JSONFormat {
+		log.SetFlags(0)
+	}
+}
+
+// Enabled returns whether messages of level l are logged.
+func Enabled(l Level) bool {
+	return l >= Level(level.Load())
+}
+
+// Fields are the structured data of a message, like the event status, the
+// container ID or the config destination it is about.
+type Fields map[string]interface{}
+
+// Entry logs messages with fields.
+type Entry struct {
+	fields Fields
+}
+
+// WithFields returns an entry logging its messages with fields.
+func WithFields(fields Fields) Entry {
+	return Entry{fields: fields}
+}
+
+func (e Entry) Debugf(msg string, args ...interface{}) { e.logf(DebugLevel, msg, args...) }
+func (e Entry) Infof(msg string, args ...interface{})  { e.logf(InfoLevel, msg, args...) }
+func (e Entry) Warnf(msg string, args ...interface{})  { e.logf(WarnLevel, msg, args...) }
+func (e Entry) Errorf(msg string, args ...interface{}) { e.logf(ErrorLevel, msg, args...) }
+
+// Fatalf logs an error message and exits with status 1, whatever the level.
+func (e Entry) Fatalf(msg string, args ...interface{}) {
+	e.write(ErrorLevel, fmt.Sprintf(msg, args...))
+	os.Exit(1)
+}
+
+func Debugf(msg string, args ...interface{}) { Entry{}.logf(DebugLevel, msg, args...) }
+func Infof(msg string, args ...interface{})  { Entry{}.logf(InfoLevel, msg, args...) }
+func Warnf(msg string, args ...interface{})  { Entry{}.logf(WarnLevel, msg, args...) }
+func Errorf(msg string, args ...interface{}) { Entry{}.logf(ErrorLevel, msg, args...) }
+
+// Fatalf logs an error message and exits with status 1, whatever the level.
+func Fatalf(msg string, args ...interface{}) { Entry{}.Fatalf(msg, args...) }
+
+func (e Entry) logf(l Level, msg string, args ...interface{}) {
+	if !Enabled(l) {
+		return
+	}
+	e.write(l, fmt.Sprintf(msg, args...))
+}
+
+func (e Entry) write(l Level, msg string) {
+	msg = strings.TrimSuffix(msg, "\n")
+	if Format(format.Load()) != JSONFormat {
+		log.Print(strings.ToUpper(l.String()) + " " + msg)
+		return
+	}
+
+	line := make(map[string]interface{}, len(e.fields)+3)
+	for k, v := range e.fields {
+		line[k] = v
+	}
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["level"] = l.String()
+	line["msg"] = msg
+	data, err := json.Marshal(line)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{
+			"time":  line["time"],
+			"level": line["level"],
+			"msg":   fmt.Sprintf("%s (unable to encode the fields: %s)", msg, err),
+		})
+	}
+	log.Print(string(data))
+}
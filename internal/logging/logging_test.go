@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// capture returns the output of the standard logger written while running fn
+func capture(t *testing.T, fn func()) string {
+	buf := new(bytes.Buffer)
+	flags := log.Flags()
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		SetLevel(InfoLevel)
+		SetFormat(TextFormat)
+	}()
+	fn()
+	return buf.String()
+}
+
+func TestParseLevel(t *testing.T) {
+	l, err := ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, WarnLevel, l)
+	assert.Equal(t, "warn", l.String())
+
+	_, err = ParseLevel("verbose")
+	assert.EqualError(t, err, `invalid log level "verbose": must be one of debug, info, warn, error`)
+
+	f, err := ParseFormat("json")
+	assert.NoError(t, err)
+	assert.Equal(t, JSONFormat, f)
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestText(t *testing.T) {
+	out := capture(t, func() {
+		Debugf("Received event %s", "start")
+		Infof("Generated '%s'\n", "/etc/nginx.conf")
+		WithFields(Fields{"dest": "/etc/nginx.conf"}).Errorf("Error running notify command")
+	})
+	assert.Equal(t, "INFO Generated '/etc/nginx.conf'\nERROR Error running notify command\n", out, "debug is dropped and fields are not written")
+
+	out = capture(t, func() {
+		SetLevel(DebugLevel)
+		Debugf("Debounce minTimer fired")
+	})
+	assert.Equal(t, "DEBUG Debounce minTimer fired\n", out)
+
+	out = capture(t, func() {
+		SetLevel(ErrorLevel)
+		Warnf("No container matches notify pattern")
+	})
+	assert.Empty(t, out)
+}
+
+func TestJSON(t *testing.T) {
+	out := capture(t, func() {
+		log.SetFlags(log.LstdFlags)
+		SetFormat(JSONFormat)
+		SetLevel(DebugLevel)
+		WithFields(Fields{"status": "start", "container": "0123456789ab"}).Debugf("Received event %s for container %s\n", "start", "0123456789ab")
+	})
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &line))
+	assert.Equal(t, "debug", line["level"])
+	assert.Equal(t, "Received event start for container 0123456789ab", line["msg"])
+	assert.Equal(t, "start", line["status"])
+	assert.Equal(t, "0123456789ab", line["container"])
+	assert.NotEmpty(t, line["time"])
+}
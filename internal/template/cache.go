@@ -0,0 +1,131 @@
+package template
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nginx-proxy/docker-gen/internal/context"
+)
+
+// groupsCache memoizes the groupings of container lists, as every config of a
+// generation cycle renders the same containers. Containers are identified by
+// pointer: they are never modified once inspected, and the cache entries keep
+// them alive so that their addresses cannot be reused by other containers.
+type groupsCache struct {
+	mu      sync.Mutex
+	entries map[string]groupsCacheEntry
+}
+
+type groupsCacheEntry struct {
+	containers context.Context
+	groups     map[string][]interface{}
+	err        error
+}
+
+var cache = &groupsCache{entries: make(map[string]groupsCacheEntry)}
+
+// ResetCache drops the memoized groupings. It is called before each
+// generation cycle so that the cache only holds the current containers.
+func ResetCache() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries = make(map[string]groupsCacheEntry)
+}
+
+// containerList returns the containers of entries if it is a container list
+func containerList(entries interface{}) (context.Context, bool) {
+	switch containers := entries.(type) {
+	case context.Context:
+		return containers, true
+	case *context.Context:
+		if containers != nil {
+			return *containers, true
+		}
+	case []*context.RuntimeContainer:
+		return containers, true
+	}
+	return nil, false
+}
+
+// cachedGroups returns the memoized result of group if entries is a container
+// list already grouped by funcName with the same arguments in this cycle
+func cachedGroups(funcName string, entries interface{}, args []string, group func() (map[string][]interface{}, error)) (map[string][]interface{}, error) {
+	containers, ok := containerList(entries)
+	if !ok {
+		return group()
+	}
+
+	var key strings.Builder
+	key.WriteString(funcName)
+	for _, arg := range args {
+		fmt.Fprintf(&key, "\x00%s", arg)
+	}
+	key.WriteString("\x00")
+	for _, container := range containers {
+		fmt.Fprintf(&key, "%p,", container)
+	}
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[key.String()]
+	cache.mu.Unlock()
+	if ok {
+		return entry.groups, entry.err
+	}
+
+	groups, err := group()
+	cache.mu.Lock()
+	cache.entries[key.String()] = groupsCacheEntry{containers: containers, groups: groups, err: err}
+	cache.mu.Unlock()
+	return groups, err
+}
+
+// lruCache holds up to size values, dropping the least recently used ones
+// first. It memoizes values that must outlive the generation cycles, which
+// ResetCache would drop.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the value of key, if cached
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+// add caches the value of key, dropping the least recently used value if the
+// cache is full
+func (c *lruCache) add(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lruEntry).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
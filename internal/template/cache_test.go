@@ -0,0 +1,125 @@
+package template
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nginx-proxy/docker-gen/internal/config"
+	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachedGroups(t *testing.T) {
+	ResetCache()
+	containers := context.Context{
+		{ID: "1", Labels: map[string]string{"group": "a"}},
+		{ID: "2", Labels: map[string]string{"group": "b"}},
+	}
+
+	calls := 0
+	group := func() (map[string][]interface{}, error) {
+		calls++
+		return map[string][]interface{}{}, nil
+	}
+
+	cachedGroups("test", containers, []string{"group"}, group)
+	cachedGroups("test", &containers, []string{"group"}, group)
+	cachedGroups("test", []*context.RuntimeContainer(containers), []string{"group"}, group)
+	assert.Equal(t, 1, calls, "the same containers are only grouped once")
+
+	cachedGroups("test", containers, []string{"other"}, group)
+	cachedGroups("test", containers[:1], []string{"group"}, group)
+	cachedGroups("other", containers, []string{"group"}, group)
+	assert.Equal(t, 4, calls, "other arguments, containers or functions are grouped again")
+
+	cachedGroups("test", []string{"a", "b"}, []string{"group"}, group)
+	cachedGroups("test", []string{"a", "b"}, []string{"group"}, group)
+	assert.Equal(t, 6, calls, "entries which are not containers are not cached")
+
+	ResetCache()
+	cachedGroups("test", containers, []string{"group"}, group)
+	assert.Equal(t, 7, calls)
+}
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("a", 1)
+	c.add("b", 2)
+	value, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	c.add("c", 3)
+	_, ok = c.get("b")
+	assert.False(t, ok, "the least recently used value is dropped")
+	for key, expected := range map[string]int{"a": 1, "c": 3} {
+		value, ok := c.get(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, expected, value, key)
+	}
+
+	c.add("a", 4)
+	value, _ = c.get("a")
+	assert.Equal(t, 4, value)
+	assert.Len(t, c.entries, 2)
+}
+
+func TestGroupByCachedAcrossContainerLists(t *testing.T) {
+	ResetCache()
+	containers := context.Context{
+		{ID: "1", Labels: map[string]string{"group": "a"}},
+		{ID: "2", Labels: map[string]string{"group": "b"}},
+	}
+	// configs filter the containers into new lists of the same containers
+	filtered := append(context.Context{}, containers...)
+
+	groups, err := groupByLabel(containers, "group")
+	assert.NoError(t, err)
+	cached, err := groupByLabel(filtered, "group")
+	assert.NoError(t, err)
+	assert.Equal(t, groups, cached)
+	assert.Len(t, cache.entries, 1)
+
+	keys, err := groupByKeys(containers, "ID")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1", "2"}, keys)
+	groups, err = groupBy(containers, "ID")
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	assert.Len(t, cache.entries, 2, "groupByKeys and groupBy share their groupings")
+}
+
+func BenchmarkExecuteTemplateManyConfigs(b *testing.B) {
+	tmplFile := filepath.Join(b.TempDir(), "tmpl")
+	err := os.WriteFile(tmplFile, []byte(`{{range $group, $containers := groupByLabel $ "com.example.group"}}{{$group}}: {{len $containers}}
+{{end}}{{range $host, $containers := groupByMulti $ "Env.VIRTUAL_HOST" ","}}{{$host}}: {{len $containers}}
+{{end}}{{range $image, $containers := groupBy $ "Image.Repository"}}{{$image}}: {{len $containers}}
+{{end}}`), 0644)
+	if err != nil {
+		b.Fatalf("Failed to write template: %v\n", err)
+	}
+
+	containers := context.Context{}
+	for i := 0; i < 1000; i++ {
+		containers = append(containers, &context.RuntimeContainer{
+			ID:     fmt.Sprintf("%d", i),
+			Image:  context.DockerImage{Repository: fmt.Sprintf("image%d", i%10)},
+			Env:    map[string]string{"VIRTUAL_HOST": fmt.Sprintf("a%d.example.com,b%d.example.com", i, i)},
+			Labels: map[string]string{"com.example.group": fmt.Sprintf("group%d", i%50)},
+		})
+	}
+	configs := make([]config.Config, 50)
+	for i := range configs {
+		configs[i] = config.Config{Template: tmplFile}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ResetCache()
+		for _, config := range configs {
+			executeTemplate(config, containers)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package template
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/nginx-proxy/docker-gen/internal/context"
+)
+
+// caddyUpstream returns the address Caddy should dial to reach a container:
+// its IP (or the IP of its first network) and its VIRTUAL_PORT, its only
+// exposed port, or 80
+func caddyUpstream(container *context.RuntimeContainer) string {
+	ip := container.IP
+	if ip == "" && len(container.Networks) > 0 {
+		ip = container.Networks[0].IP
+	}
+	if ip == "" {
+		return ""
+	}
+
+	port := container.Env["VIRTUAL_PORT"]
+	if port == "" && len(container.Addresses) == 1 {
+		port = container.Addresses[0].Port
+	}
+	if port == "" {
+		port = "80"
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// caddyRoutes builds the routes of a Caddy JSON config (apps.http.servers.*.routes)
+// reverse proxying each host of the containers' VIRTUAL_HOST env var to the
+// containers serving it. Routes are sorted by host and upstreams by address.
+func caddyRoutes(containers context.Context) []map[string]interface{} {
+	upstreams := make(map[string][]string)
+	for _, container := range containers {
+		upstream := caddyUpstream(container)
+		if upstream == "" {
+			continue
+		}
+		for _, host := range strings.Split(container.Env["VIRTUAL_HOST"], ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				upstreams[host] = append(upstreams[host], upstream)
+			}
+		}
+	}
+
+	hosts := make([]string, 0, len(upstreams))
+	for host := range upstreams {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	routes := make([]map[string]interface{}, 0, len(hosts))
+	for _, host := range hosts {
+		sort.Strings(upstreams[host])
+		dials := make([]map[string]interface{}, 0, len(upstreams[host]))
+		for _, upstream := range upstreams[host] {
+			dials = append(dials, map[string]interface{}{"dial": upstream})
+		}
+		routes = append(routes, map[string]interface{}{
+			"match": []map[string]interface{}{
+				{"host": []string{host}},
+			},
+			"handle": []map[string]interface{}{
+				{"handler": "reverse_proxy", "upstreams": dials},
+			},
+			"terminal": true,
+		})
+	}
+	return routes
+}
@@ -0,0 +1,41 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaddyUpstream(t *testing.T) {
+	assert.Equal(t, "10.0.0.2:80", caddyUpstream(&context.RuntimeContainer{IP: "10.0.0.2"}))
+	assert.Equal(t, "10.0.0.2:8080", caddyUpstream(&context.RuntimeContainer{
+		IP:  "10.0.0.2",
+		Env: map[string]string{"VIRTUAL_PORT": "8080"},
+	}))
+	assert.Equal(t, "10.0.0.3:3000", caddyUpstream(&context.RuntimeContainer{
+		Networks:  []context.Network{{Name: "proxy", IP: "10.0.0.3"}},
+		Addresses: []context.Address{{Port: "3000"}},
+	}))
+	assert.Equal(t, "[fd00::2]:80", caddyUpstream(&context.RuntimeContainer{IP: "fd00::2"}))
+	assert.Equal(t, "", caddyUpstream(&context.RuntimeContainer{}))
+}
+
+func TestCaddyRoutes(t *testing.T) {
+	containers := context.Context{
+		{IP: "10.0.0.3", Env: map[string]string{"VIRTUAL_HOST": "b.example.com"}},
+		{IP: "10.0.0.2", Env: map[string]string{"VIRTUAL_HOST": "b.example.com, a.example.com"}},
+		{IP: "10.0.0.4"},
+		{Env: map[string]string{"VIRTUAL_HOST": "c.example.com"}},
+	}
+
+	tests := templateTestList{
+		{`{{ caddyRoutes . | toJson }}`, containers, `[` +
+			`{"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.2:80"}]}],"match":[{"host":["a.example.com"]}],"terminal":true},` +
+			`{"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.2:80"},{"dial":"10.0.0.3:80"}]}],"match":[{"host":["b.example.com"]}],"terminal":true}` +
+			`]`},
+		{`{{ caddyRoutes . | toJson }}`, context.Context{}, `[]`},
+	}
+
+	tests.run(t)
+}
@@ -0,0 +1,70 @@
+package template
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nginx-proxy/docker-gen/internal/config"
+)
+
+// CheckConfigs validates the configs without contacting docker or rendering
+// anything: every config must have a template that parses, and every
+// destination must be writable. It returns all the problems found.
+func CheckConfigs(configFile config.ConfigFile) []error {
+	errs := []error{}
+	for i, config := range configFile.Config {
+		if config.Template == "" {
+			errs = append(errs, fmt.Errorf("config #%d: template is required", i))
+		} else if _, err := parseTemplate(config); err != nil {
+			errs = append(errs, fmt.Errorf("config #%d: unable to parse template: %w", i, err))
+		}
+
+		if _, err := config.DestDirPerm(); err != nil {
+			errs = append(errs, fmt.Errorf("config #%d: %w", i, err))
+		}
+		if _, _, err := config.FilePerm(); err != nil {
+			errs = append(errs, fmt.Errorf("config #%d: %w", i, err))
+		}
+		if _, err := config.NotifyContainerMatcher(); err != nil {
+			errs = append(errs, fmt.Errorf("config #%d: %w", i, err))
+		}
+		if _, err := config.ValidateCommand(""); err != nil {
+			errs = append(errs, fmt.Errorf("config #%d: %w", i, err))
+		}
+		if err := config.CheckDelims(); err != nil {
+			errs = append(errs, fmt.Errorf("config #%d: %w", i, err))
+		}
+		if err := config.CheckCompress(); err != nil {
+			errs = append(errs, fmt.Errorf("config #%d: %w", i, err))
+		}
+		if err := config.CheckBackupCount(); err != nil {
+			errs = append(errs, fmt.Errorf("config #%d: %w", i, err))
+		}
+		for _, dest := range config.Destinations() {
+			if config.CreateDestDir {
+				// the missing directories are created when generating
+				continue
+			}
+			if err := checkWritable(dest); err != nil {
+				errs = append(errs, fmt.Errorf("config #%d: destination %s is not writable: %w", i, dest, err))
+			}
+		}
+	}
+	return errs
+}
+
+// checkWritable checks that the destination can be replaced the way
+// writeFile does it, by renaming a temp file created next to it
+func checkWritable(destPath string) error {
+	if fi, err := os.Stat(destPath); err == nil && fi.IsDir() {
+		return fmt.Errorf("%s is a directory", destPath)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), "docker-gen")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
@@ -0,0 +1,49 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nginx-proxy/docker-gen/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfigs(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.tmpl")
+	invalid := filepath.Join(dir, "invalid.tmpl")
+	unknownFunc := filepath.Join(dir, "unknown.tmpl")
+	for path, contents := range map[string]string{
+		valid:       `{{range .}}{{.Name}}{{end}}`,
+		invalid:     `{{range .}}`,
+		unknownFunc: `{{notAFunction .}}`,
+	} {
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write template: %v\n", err)
+		}
+	}
+
+	errs := CheckConfigs(config.ConfigFile{Config: []config.Config{
+		{Template: valid, Dest: filepath.Join(dir, "out")},
+		{Template: valid},
+	}})
+	assert.Empty(t, errs)
+
+	errs = CheckConfigs(config.ConfigFile{Config: []config.Config{
+		{Template: valid, Dest: filepath.Join(dir, "out")},
+		{},
+		{Template: invalid},
+		{Template: unknownFunc},
+		{Template: filepath.Join(dir, "missing.tmpl")},
+		{Template: valid, Dest: filepath.Join(dir, "missing", "out"), Dests: []string{dir}},
+	}})
+	if assert.Len(t, errs, 6) {
+		assert.Contains(t, errs[0].Error(), "config #1: template is required")
+		assert.Contains(t, errs[1].Error(), "config #2: unable to parse template")
+		assert.Contains(t, errs[2].Error(), "config #3: unable to parse template")
+		assert.Contains(t, errs[3].Error(), "config #4: unable to parse template")
+		assert.Contains(t, errs[4].Error(), "config #5: destination "+filepath.Join(dir, "missing", "out"))
+		assert.Contains(t, errs[5].Error(), "is a directory")
+	}
+}
@@ -2,14 +2,39 @@ package template
 
 import (
 	"bytes"
+	"crypto/md5"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"log"
+	"math/rand"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/nginx-proxy/docker-gen/internal/logging"
+	"github.com/nginx-proxy/docker-gen/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// htpasswdCacheSize is the number of htpasswd lines kept by htpasswd
+const htpasswdCacheSize = 1024
+
+var (
+	htpasswdMu    sync.Mutex
+	htpasswdCache = newLRUCache(htpasswdCacheSize)
 )
 
 func keys(input interface{}) (interface{}, error) {
@@ -31,6 +56,94 @@ func keys(input interface{}) (interface{}, error) {
 	return k, nil
 }
 
+// mapLookup returns the value of key in the map m, and whether it is present.
+// m can be any map with string keys, like the Labels or Env of a container.
+func mapLookup(fn string, m interface{}, key string) (interface{}, bool, error) {
+	if m == nil {
+		return nil, false, nil
+	}
+	val := reflect.ValueOf(m)
+	if val.Kind() != reflect.Map || val.Type().Key().Kind() != reflect.String {
+		return nil, false, fmt.Errorf("cannot call %s on a value that is not a map with string keys: %v", fn, m)
+	}
+	v := val.MapIndex(reflect.ValueOf(key).Convert(val.Type().Key()))
+	if !v.IsValid() {
+		return nil, false, nil
+	}
+	return v.Interface(), true, nil
+}
+
+// hasKey returns whether key is present in the map m, even with an empty value
+func hasKey(m interface{}, key string) (bool, error) {
+	_, ok, err := mapLookup("hasKey", m, key)
+	return ok, err
+}
+
+// mapValue returns the value of key in the map m, or defaultValue when the key
+// is absent. A present key with an empty value returns that empty value.
+func mapValue(m interface{}, key string, defaultValue interface{}) (interface{}, error) {
+	v, ok, err := mapLookup("mapValue", m, key)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	return v, nil
+}
+
+// copyDict returns a copy of the map m, any map with string keys like a dict
+// or the Labels of a container, as a dict
+func copyDict(fn string, m interface{}) (map[string]interface{}, error) {
+	dict := make(map[string]interface{})
+	if m == nil {
+		return dict, nil
+	}
+	val := reflect.ValueOf(m)
+	if val.Kind() != reflect.Map || val.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("cannot call %s on a value that is not a map with string keys: %v", fn, m)
+	}
+	iter := val.MapRange()
+	for iter.Next() {
+		dict[iter.Key().String()] = iter.Value().Interface()
+	}
+	return dict, nil
+}
+
+// dictMerge returns a new dict with the keys of all the maps, the later maps
+// overriding the earlier ones. The maps are left untouched.
+func dictMerge(maps ...interface{}) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, m := range maps {
+		dict, err := copyDict("dictMerge", m)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range dict {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+// dictSet returns a copy of the map m with key set to value
+func dictSet(m interface{}, key string, value interface{}) (map[string]interface{}, error) {
+	dict, err := copyDict("dictSet", m)
+	if err != nil {
+		return nil, err
+	}
+	dict[key] = value
+	return dict, nil
+}
+
+// dictUnset returns a copy of the map m without key
+func dictUnset(m interface{}, key string) (map[string]interface{}, error) {
+	dict, err := copyDict("dictUnset", m)
+	if err != nil {
+		return nil, err
+	}
+	delete(dict, key)
+	return dict, nil
+}
+
+// intersect returns the distinct strings of both lists, sorted
 func intersect(l1, l2 []string) []string {
 	m := make(map[string]bool)
 	m2 := make(map[string]bool)
@@ -46,9 +159,107 @@ func intersect(l1, l2 []string) []string {
 	for k := range m {
 		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stringDifference returns the distinct strings of l1 that are not in l2, sorted
+func stringDifference(l1, l2 []string) []string {
+	m := make(map[string]bool)
+	m2 := make(map[string]bool)
+	for _, v := range l2 {
+		m2[v] = true
+	}
+	for _, v := range l1 {
+		if !m2[v] {
+			m[v] = true
+		}
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 	return keys
 }
 
+// toStringSlice converts a slice, like the output of split or keys, to strings
+func toStringSlice(funcName string, input interface{}) ([]string, error) {
+	switch input := input.(type) {
+	case nil:
+		return []string{}, nil
+	case []string:
+		return input, nil
+	}
+	inputVal, err := getArrayValues(funcName, input)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, inputVal.Len())
+	for i := range values {
+		values[i] = fmt.Sprint(inputVal.Index(i).Interface())
+	}
+	return values, nil
+}
+
+// joinStrings joins the strings of a slice with a separator, like sprig's
+// join with its arguments in the order of split
+func joinStrings(list interface{}, sep string) (string, error) {
+	values, err := toStringSlice("joinStrings", list)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(values, sep), nil
+}
+
+// trimStrings trims the leading and trailing whitespace of every string of a
+// slice
+func trimStrings(list interface{}) ([]string, error) {
+	values, err := toStringSlice("trimStrings", list)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.TrimSpace(v)
+	}
+	return trimmed, nil
+}
+
+// intersectValues is the intersect template function, accepting any slices
+func intersectValues(l1, l2 interface{}) ([]string, error) {
+	s1, err := toStringSlice("intersect", l1)
+	if err != nil {
+		return nil, err
+	}
+	s2, err := toStringSlice("intersect", l2)
+	if err != nil {
+		return nil, err
+	}
+	return intersect(s1, s2), nil
+}
+
+// differenceValues is the difference template function: the difference of
+// two container lists, or else the sorted difference of two slices of strings
+func differenceValues(first, second interface{}) (interface{}, error) {
+	if containers, ok := containerList(first); ok {
+		others, ok := containerList(second)
+		if !ok && second != nil {
+			return nil, fmt.Errorf("must pass two container lists to 'difference'; received %v", second)
+		}
+		return difference(containers, others), nil
+	}
+	s1, err := toStringSlice("difference", first)
+	if err != nil {
+		return nil, err
+	}
+	s2, err := toStringSlice("difference", second)
+	if err != nil {
+		return nil, err
+	}
+	return stringDifference(s1, s2), nil
+}
+
 func contains(input interface{}, key interface{}) bool {
 	if input == nil {
 		return false
@@ -72,6 +283,45 @@ func hashSha1(input string) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
+func hashSha256(input string) string {
+	h := sha256.New()
+	io.WriteString(h, input)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func hashMd5(input string) string {
+	h := md5.New()
+	io.WriteString(h, input)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func base64Encode(input string) string {
+	return base64.StdEncoding.EncodeToString([]byte(input))
+}
+
+func base64Decode(input string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(input)
+	if err != nil {
+		return "", fmt.Errorf("base64Decode: invalid input: %w", err)
+	}
+	return string(decoded), nil
+}
+
+// base64UrlEncode encodes with the URL-safe alphabet and without padding, as
+// the segments of a JWT
+func base64UrlEncode(input string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(input))
+}
+
+// base64UrlDecode decodes the URL-safe alphabet, padded or not
+func base64UrlDecode(input string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(input, "="))
+	if err != nil {
+		return "", fmt.Errorf("base64UrlDecode: invalid input: %w", err)
+	}
+	return string(decoded), nil
+}
+
 func marshalJson(input interface{}) (string, error) {
 	var buf bytes.Buffer
 	enc := json.NewEncoder(&buf)
@@ -89,6 +339,40 @@ func unmarshalJson(input string) (interface{}, error) {
 	return v, nil
 }
 
+// unmarshalJsonArray parses a JSON array, e.g. embedded in a label. A blank
+// or malformed input results in an empty slice, malformed inputs being
+// logged, so that the rest of the template still renders.
+func unmarshalJsonArray(input string) []interface{} {
+	var values []interface{}
+	if strings.TrimSpace(input) == "" {
+		return []interface{}{}
+	}
+	if err := json.Unmarshal([]byte(input), &values); err != nil {
+		logging.Warnf("parseJsonArray: ignoring invalid JSON array %q: %s\n", input, err)
+		return []interface{}{}
+	}
+	if values == nil {
+		return []interface{}{}
+	}
+	return values
+}
+
+// marshalYaml serializes input as a YAML document, without trailing newline
+// so that it can be piped to indent. Map keys are sorted, so the output of a
+// same value is stable across runs.
+func marshalYaml(input interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(input); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
 // arrayClosest find the longest matching substring in values
 // that matches input
 func arrayClosest(values []string, input string) string {
@@ -101,12 +385,30 @@ func arrayClosest(values []string, input string) string {
 	return best
 }
 
+// closestDomain returns the domain of values that is the longest suffix of
+// the input domain, on a label boundary: example.com matches a.example.com but
+// not a.myexample.com. Domains are compared case-insensitively.
+func closestDomain(values []string, input string) string {
+	input = strings.ToLower(input)
+	best := ""
+	for _, v := range values {
+		domain := strings.ToLower(v)
+		if domain == "" || len(v) <= len(best) {
+			continue
+		}
+		if input == domain || strings.HasSuffix(input, "."+domain) {
+			best = v
+		}
+	}
+	return best
+}
+
 // dirList returns a list of files in the specified path
 func dirList(path string) ([]string, error) {
 	names := []string{}
 	files, err := os.ReadDir(path)
 	if err != nil {
-		log.Printf("Template error: %v", err)
+		logging.Errorf("Template error: %v", err)
 		return names, nil
 	}
 	for _, f := range files {
@@ -115,16 +417,31 @@ func dirList(path string) ([]string, error) {
 	return names, nil
 }
 
-// coalesce returns the first non nil argument
+// coalesce returns the first argument that is neither nil, a nil pointer, nor
+// an empty string (or a pointer to one), or nil if there is none. Other zero
+// values, like 0 or false, are returned.
 func coalesce(input ...interface{}) interface{} {
 	for _, v := range input {
-		if v != nil {
+		if !isNilOrEmpty(v) {
 			return v
 		}
 	}
 	return nil
 }
 
+// isNilOrEmpty returns whether v is nil, a nil pointer, an empty string, or a
+// pointer to an empty string
+func isNilOrEmpty(v interface{}) bool {
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return true
+		}
+		val = val.Elem()
+	}
+	return !val.IsValid() || (val.Kind() == reflect.String && val.Len() == 0)
+}
+
 // trimPrefix returns a string without the prefix, if present
 func trimPrefix(prefix, s string) string {
 	return strings.TrimPrefix(s, prefix)
@@ -135,6 +452,30 @@ func trimSuffix(suffix, s string) string {
 	return strings.TrimSuffix(s, suffix)
 }
 
+// hasPrefix returns whether s begins with prefix
+func hasPrefix(prefix, s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// hasSuffix returns whether s ends with suffix
+func hasSuffix(suffix, s string) bool {
+	return strings.HasSuffix(s, suffix)
+}
+
+// toTitle returns the string with the first letter of each word in upper
+// case, the words being separated by anything but letters and digits
+func toTitle(s string) string {
+	previous := ' '
+	return strings.Map(func(r rune) rune {
+		start := !unicode.IsLetter(previous) && !unicode.IsDigit(previous)
+		previous = r
+		if start {
+			return unicode.ToTitle(r)
+		}
+		return r
+	}, s)
+}
+
 // toLower return the string in lower case
 func toLower(s string) string {
 	return strings.ToLower(s)
@@ -145,6 +486,495 @@ func toUpper(s string) string {
 	return strings.ToUpper(s)
 }
 
+// regexReplace replaces the matches of pattern in s with replacement, which
+// can refer to submatches as $1 or ${name}. The compiled pattern is cached.
+func regexReplace(s, pattern, replacement string) (string, error) {
+	rx, err := compileRegexp(pattern)
+	if err != nil {
+		return "", fmt.Errorf("regexReplace: invalid pattern %q: %w", pattern, err)
+	}
+	return rx.ReplaceAllString(s, replacement), nil
+}
+
+// envOr returns the value of the environment variable of docker-gen, or
+// defaultValue when it is unset or empty
+func envOr(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// mask returns a string of the same length as s made only of asterisks
+func mask(s string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(s))
+}
+
+// redact replaces all but the last visible characters of s with asterisks
+func redact(visible int, s string) string {
+	runes := []rune(s)
+	if visible < 0 {
+		visible = 0
+	}
+	if visible >= len(runes) {
+		return mask(s)
+	}
+	hidden := len(runes) - visible
+	return strings.Repeat("*", hidden) + string(runes[hidden:])
+}
+
+// htpasswd returns an htpasswd line for user with a bcrypt hash of password.
+// Hashes are salted randomly, so the lines of the most recently used
+// credentials are cached, keyed by a digest of the credentials, to keep the
+// generated output stable across renders.
+func htpasswd(user, password string) (string, error) {
+	if user == "" {
+		return "", errors.New("htpasswd: empty user")
+	}
+	if strings.Contains(user, ":") {
+		return "", fmt.Errorf("htpasswd: invalid user %q: must not contain ':'", user)
+	}
+	if password == "" {
+		return "", fmt.Errorf("htpasswd: empty password for user %q", user)
+	}
+
+	digest := sha256.Sum256([]byte(user + ":" + password))
+	key := string(digest[:])
+	htpasswdMu.Lock()
+	cached, ok := htpasswdCache.get(key)
+	htpasswdMu.Unlock()
+	if ok {
+		return cached.(string), nil
+	}
+	// bcrypt is slow on purpose, other renders are not blocked meanwhile
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("htpasswd: %w", err)
+	}
+
+	htpasswdMu.Lock()
+	defer htpasswdMu.Unlock()
+	// a concurrent render may have hashed the same credentials, keep its line
+	if cached, ok := htpasswdCache.get(key); ok {
+		return cached.(string), nil
+	}
+	line := user + ":" + string(hash)
+	htpasswdCache.add(key, line)
+	return line, nil
+}
+
+// pickByHash deterministically picks one of the entries for key using
+// rendezvous hashing: each entry is scored by hashing key with the entry's
+// identity (the container ID for containers), and the highest score wins.
+// Adding or removing entries only moves the keys assigned to them.
+func pickByHash(key string, entries interface{}) (interface{}, error) {
+	entriesVal, err := getArrayValues("pickByHash", entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		picked    interface{}
+		bestScore uint64
+	)
+	for i := 0; i < entriesVal.Len(); i++ {
+		v := entriesVal.Index(i).Interface()
+		identity := fmt.Sprint(v)
+		if container, ok := v.(*context.RuntimeContainer); ok {
+			identity = container.ID
+		}
+
+		h := fnv.New64a()
+		io.WriteString(h, key)
+		h.Write([]byte{0})
+		io.WriteString(h, identity)
+		if score := h.Sum64(); picked == nil || score > bestScore {
+			picked, bestScore = v, score
+		}
+	}
+	return picked, nil
+}
+
+// shuffleSeeded returns a copy of the entries shuffled deterministically for
+// the seed: the same seed and the same set of entries, in any order, always
+// give the same order. Containers are identified by their ID.
+func shuffleSeeded(seed string, entries interface{}) (interface{}, error) {
+	entriesVal, err := getArrayValues("shuffleSeeded", entries)
+	if err != nil {
+		return nil, err
+	}
+
+	shuffled := reflect.MakeSlice(reflect.SliceOf(entriesVal.Type().Elem()), entriesVal.Len(), entriesVal.Len())
+	reflect.Copy(shuffled, *entriesVal)
+	identities := make([]string, shuffled.Len())
+	for i := range identities {
+		v := shuffled.Index(i).Interface()
+		identities[i] = fmt.Sprint(v)
+		if container, ok := v.(*context.RuntimeContainer); ok {
+			identities[i] = container.ID
+		}
+	}
+
+	swap := reflect.Swapper(shuffled.Interface())
+	sort.Sort(identitySorter{identities, swap})
+
+	h := fnv.New64a()
+	io.WriteString(h, seed)
+	rand.New(rand.NewSource(int64(h.Sum64()))).Shuffle(len(identities), swap)
+	return shuffled.Interface(), nil
+}
+
+// identitySorter sorts a slice by the identities of its entries
+type identitySorter struct {
+	identities []string
+	swap       func(i, j int)
+}
+
+func (s identitySorter) Len() int           { return len(s.identities) }
+func (s identitySorter) Less(i, j int) bool { return s.identities[i] < s.identities[j] }
+func (s identitySorter) Swap(i, j int) {
+	s.identities[i], s.identities[j] = s.identities[j], s.identities[i]
+	s.swap(i, j)
+}
+
+// pickByCount returns the value of the largest threshold which is lower than or
+// equal to the number of entries, or nil if there is none. Thresholds are the
+// keys of the map, given as integers or as strings holding integers.
+func pickByCount(entries interface{}, thresholds interface{}) (interface{}, error) {
+	entriesVal, err := getArrayValues("pickByCount", entries)
+	if err != nil {
+		return nil, err
+	}
+	thresholdsVal := reflect.ValueOf(thresholds)
+	if thresholdsVal.Kind() != reflect.Map {
+		return nil, fmt.Errorf("must pass a map of thresholds to 'pickByCount'; received %v", thresholds)
+	}
+
+	count := entriesVal.Len()
+	var (
+		picked    interface{}
+		threshold = -1
+	)
+	iter := thresholdsVal.MapRange()
+	for iter.Next() {
+		t, err := strconv.Atoi(fmt.Sprint(iter.Key().Interface()))
+		if err != nil {
+			return nil, fmt.Errorf("pickByCount: invalid threshold %v: must be an integer", iter.Key().Interface())
+		}
+		if t <= count && t > threshold {
+			picked, threshold = iter.Value().Interface(), t
+		}
+	}
+	return picked, nil
+}
+
+// HealthCheck describes how a proxy should check a container
+type HealthCheck struct {
+	Path     string
+	Interval time.Duration
+	Status   int
+}
+
+// healthCheck returns the health check of a container from its
+// docker-gen.healthcheck.path, .interval and .status labels, defaulting to
+// a check of / every 10s expecting a 200 status
+func healthCheck(container *context.RuntimeContainer) (HealthCheck, error) {
+	check := HealthCheck{Path: "/", Interval: 10 * time.Second, Status: 200}
+	if path, ok := container.Labels["docker-gen.healthcheck.path"]; ok && path != "" {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		check.Path = path
+	}
+	if interval, ok := container.Labels["docker-gen.healthcheck.interval"]; ok && interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return HealthCheck{}, fmt.Errorf("healthCheck: invalid interval %q for container %s", interval, container.Name)
+		}
+		check.Interval = d
+	}
+	if status, ok := container.Labels["docker-gen.healthcheck.status"]; ok && status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 100 || code > 599 {
+			return HealthCheck{}, fmt.Errorf("healthCheck: invalid status %q for container %s", status, container.Name)
+		}
+		check.Status = code
+	}
+	return check, nil
+}
+
+// hasIPv6 returns whether the container has a global IPv6 address, on the
+// default bridge or on any of its networks
+func hasIPv6(container *context.RuntimeContainer) bool {
+	if container == nil {
+		return false
+	}
+	if container.IP6Global != "" {
+		return true
+	}
+	for _, network := range container.Networks {
+		if network.GlobalIPv6Address != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryIP returns the main IP of a container: its IP on the first of the
+// preferred networks it is connected to, else its IP on the default bridge,
+// else its IP on its network with the lowest name. The preferred networks
+// are given as a slice or as a comma separated string.
+func primaryIP(container *context.RuntimeContainer, preferredNetworks interface{}) (string, error) {
+	if container == nil {
+		return "", nil
+	}
+
+	preferred := []string{}
+	if names, ok := preferredNetworks.(string); ok {
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				preferred = append(preferred, name)
+			}
+		}
+	} else if preferredNetworks != nil {
+		namesVal, err := getArrayValues("primaryIP", preferredNetworks)
+		if err != nil {
+			return "", err
+		}
+		for i := 0; i < namesVal.Len(); i++ {
+			preferred = append(preferred, fmt.Sprint(namesVal.Index(i).Interface()))
+		}
+	}
+
+	for _, name := range preferred {
+		for _, network := range container.Networks {
+			if network.Name == name && network.IP != "" {
+				return network.IP, nil
+			}
+		}
+	}
+	if container.IP != "" {
+		return container.IP, nil
+	}
+
+	ip, lowest := "", ""
+	for _, network := range container.Networks {
+		if network.IP != "" && (ip == "" || network.Name < lowest) {
+			ip, lowest = network.IP, network.Name
+		}
+	}
+	return ip, nil
+}
+
+// publishedAddresses returns the addresses of the container published on the
+// host, sorted by container port and protocol
+func publishedAddresses(container *context.RuntimeContainer) []context.Address {
+	if container == nil {
+		return []context.Address{}
+	}
+	published := container.PublishedAddresses()
+	sort.SliceStable(published, func(i, j int) bool {
+		pi, _ := strconv.Atoi(published[i].Port)
+		pj, _ := strconv.Atoi(published[j].Port)
+		if pi != pj {
+			return pi < pj
+		}
+		return published[i].Proto < published[j].Proto
+	})
+	return published
+}
+
+// firstPublished returns the published address of the container with the
+// lowest container port, or nil if it publishes none
+func firstPublished(container *context.RuntimeContainer) *context.Address {
+	published := publishedAddresses(container)
+	if len(published) == 0 {
+		return nil
+	}
+	return &published[0]
+}
+
+// portRanges collapses the contiguous host ports published by the container
+// into ranges, returned as "8000-8005/tcp" or "53/udp" for a single port,
+// sorted by protocol and port
+func portRanges(container *context.RuntimeContainer) []string {
+	ports := make(map[string][]int)
+	for _, address := range container.PublishedAddresses() {
+		port, err := strconv.Atoi(address.HostPort)
+		if err != nil {
+			continue
+		}
+		ports[address.Proto] = append(ports[address.Proto], port)
+	}
+
+	protos := make([]string, 0, len(ports))
+	for proto := range ports {
+		protos = append(protos, proto)
+	}
+	sort.Strings(protos)
+
+	ranges := []string{}
+	for _, proto := range protos {
+		sort.Ints(ports[proto])
+		start, end := -1, -1
+		flush := func() {
+			if start == end {
+				ranges = append(ranges, fmt.Sprintf("%d/%s", start, proto))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("%d-%d/%s", start, end, proto))
+			}
+		}
+		for _, port := range ports[proto] {
+			switch {
+			case start == -1:
+				start, end = port, port
+			case port == end || port == end+1:
+				end = port
+			default:
+				flush()
+				start, end = port, port
+			}
+		}
+		flush()
+	}
+	return ranges
+}
+
+// labelTree returns the labels of the container under prefix as a nested map,
+// built by splitting the label keys on dots. When a key is both a value and a
+// branch (e.g. proxy.tls and proxy.tls.cert), the branch wins and the value is
+// kept in the branch under the empty key.
+func labelTree(container *context.RuntimeContainer, prefix string) map[string]interface{} {
+	tree := make(map[string]interface{})
+	for key, value := range container.Labels {
+		if prefix != "" {
+			if key == prefix {
+				key = ""
+			} else if strings.HasPrefix(key, prefix+".") {
+				key = strings.TrimPrefix(key, prefix+".")
+			} else {
+				continue
+			}
+		}
+
+		node := tree
+		path := strings.Split(key, ".")
+		for _, name := range path[:len(path)-1] {
+			switch child := node[name].(type) {
+			case map[string]interface{}:
+				node = child
+			case string:
+				node[name] = map[string]interface{}{"": child}
+				node = node[name].(map[string]interface{})
+			default:
+				node[name] = make(map[string]interface{})
+				node = node[name].(map[string]interface{})
+			}
+		}
+
+		leaf := path[len(path)-1]
+		if branch, ok := node[leaf].(map[string]interface{}); ok {
+			branch[""] = value
+		} else {
+			node[leaf] = value
+		}
+	}
+	return tree
+}
+
+// labelMap collects the labels starting with prefix of all the containers
+// into a single map, keyed by the rest of the label keys. Containers are read
+// in name order; when several containers set different values for the same
+// key, onConflict decides: "last" (the default) keeps the value of the last
+// container, "first" the value of the first one, and "error" fails.
+func labelMap(containers context.Context, prefix string, onConflict ...string) (map[string]string, error) {
+	conflict := "last"
+	if len(onConflict) > 0 {
+		conflict = onConflict[0]
+	}
+	if conflict != "last" && conflict != "first" && conflict != "error" {
+		return nil, fmt.Errorf("labelMap: unknown conflict resolution %q, must be last, first or error", conflict)
+	}
+
+	sorted := append(context.Context{}, containers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	result := make(map[string]string)
+	owners := make(map[string]string)
+	for _, container := range sorted {
+		for label, value := range container.Labels {
+			key, ok := strings.CutPrefix(label, prefix)
+			if !ok || key == "" {
+				continue
+			}
+			previous, exists := result[key]
+			if exists && previous == value {
+				continue
+			}
+			if exists {
+				switch conflict {
+				case "first":
+					continue
+				case "error":
+					return nil, fmt.Errorf("labelMap: conflicting values for %s%s on containers %s and %s", prefix, key, owners[key], container.Name)
+				}
+			}
+			result[key] = value
+			owners[key] = container.Name
+		}
+	}
+	return result, nil
+}
+
+// fromEnvList converts KEY=VALUE entries, given as a slice or as a string
+// with one entry per line, to a map. Entries are split at their first `=`
+// like the container environment.
+func fromEnvList(input interface{}) (map[string]string, error) {
+	entries := []string{}
+	switch input := input.(type) {
+	case string:
+		for _, line := range strings.Split(input, "\n") {
+			if line = strings.TrimSuffix(line, "\r"); strings.TrimSpace(line) != "" {
+				entries = append(entries, line)
+			}
+		}
+	case []string:
+		entries = input
+	default:
+		entriesVal, err := getArrayValues("fromEnvList", input)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < entriesVal.Len(); i++ {
+			entries = append(entries, fmt.Sprint(entriesVal.Index(i).Interface()))
+		}
+	}
+	return utils.SplitKeyValueSlice(entries), nil
+}
+
+// toEnvList converts a map to KEY=VALUE entries sorted by key
+func toEnvList(input interface{}) ([]string, error) {
+	inputVal := reflect.ValueOf(input)
+	if inputVal.Kind() != reflect.Map || inputVal.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("must pass a map with string keys to 'toEnvList'; received %v", input)
+	}
+
+	keys := inputVal.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	entries := []string{}
+	for _, key := range keys {
+		entries = append(entries, fmt.Sprintf("%s=%v", key.String(), inputVal.MapIndex(key).Interface()))
+	}
+	return entries, nil
+}
+
 // when returns the trueValue when the condition is true and the falseValue otherwise
 func when(condition bool, trueValue, falseValue interface{}) interface{} {
 	if condition {
@@ -3,13 +3,20 @@ package template
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"os"
 	"path"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nginx-proxy/docker-gen/internal/context"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestContainsString(t *testing.T) {
@@ -91,6 +98,28 @@ func TestIntersect(t *testing.T) {
 	assert.Len(t, i, 2, "Expected exactly two matches")
 }
 
+func TestSetFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"allowed": map[string]bool{"b": true, "a": true, "d": true},
+		"list":    "d,b,c,b,a",
+	}
+
+	tests := templateTestList{
+		{`{{intersect (split .list ",") (keys .allowed)}}`, data, `[a b d]`},
+		{`{{intersect (keys .allowed) (split .list ",")}}`, data, `[a b d]`},
+		{`{{difference (split .list ",") (keys .allowed)}}`, data, `[c]`},
+		{`{{difference (keys .allowed) (split "a" ",")}}`, data, `[b d]`},
+		{`{{difference (split .list ",") (split "" ",")}}`, data, `[a b c d]`},
+	}
+
+	tests.run(t)
+
+	_, err := intersectValues("a", []string{"a"})
+	assert.Error(t, err)
+	_, err = differenceValues([]string{"a"}, 42)
+	assert.Error(t, err)
+}
+
 func TestSplitN(t *testing.T) {
 	tests := templateTestList{
 		{`{{index (splitN . "/" 2) 0}}`, "example.com/path", `example.com`},
@@ -134,6 +163,29 @@ func TestToUpper(t *testing.T) {
 	assert.Equal(t, uppered, toUpper(str), "Unexpected value from toUpper()")
 }
 
+func TestToTitle(t *testing.T) {
+	assert.Equal(t, "My-App Api", toTitle("my-app api"))
+	assert.Equal(t, "Web_1.Example.Com", toTitle("web_1.example.com"))
+	assert.Equal(t, "ÉTé 2x", toTitle("éTé 2x"), "only the first letters change")
+	assert.Equal(t, "", toTitle(""))
+}
+
+func TestStringPrefixSuffix(t *testing.T) {
+	tests := templateTestList{
+		{`{{ hasPrefix "/api" "/api/v1" }}`, nil, `true`},
+		{`{{ hasPrefix "/v1" "/api/v1" }}`, nil, `false`},
+		{`{{ hasSuffix ".local" "web.local" }}`, nil, `true`},
+		{`{{ hasSuffix ".com" "web.local" }}`, nil, `false`},
+		{`{{ trimPrefix "www." "www.example.com" }}`, nil, `example.com`},
+		{`{{ "example.com" | trimPrefix "www." }}`, nil, `example.com`},
+		{`{{ trimSuffix ".local" "web.local" }}`, nil, `web`},
+		{`{{ "web" | trimSuffix ".local" }}`, nil, `web`},
+		{`{{ "my-app" | toTitle }}`, nil, `My-App`},
+	}
+
+	tests.run(t)
+}
+
 func TestSha1(t *testing.T) {
 	sum := hashSha1("/path")
 	if sum != "4f26609ad3f5185faaa9edf1e93aa131e2131352" {
@@ -141,6 +193,46 @@ func TestSha1(t *testing.T) {
 	}
 }
 
+func TestSha256Md5(t *testing.T) {
+	tests := templateTestList{
+		{`{{ sha256 "/path" }}`, nil, `379c9f23425a38698d164abeb339116b9295b8fa7ea8747a92d74fd7885beef0`},
+		{`{{ md5 "/path" }}`, nil, `c55cc3282a38277657035e8e64b48b60`},
+	}
+
+	tests.run(t)
+}
+
+func TestReplace(t *testing.T) {
+	tests := templateTestList{
+		{`{{replaceAll "a.b.c" "." "_"}}`, nil, `a_b_c`},
+		{`{{replaceAll "abc" "x" "_"}}`, nil, `abc`},
+		{`{{regexReplace "app.example.com:8080" "[^a-zA-Z0-9_]" "_"}}`, nil, `app_example_com_8080`},
+		{`{{regexReplace "web-1" "^(?P<name>[a-z]+)-([0-9]+)$" "${name}_$2"}}`, nil, `web_1`},
+		{`{{regexReplace "web" "[" "_"}}`, nil, errors.New("")},
+	}
+	tests.run(t)
+
+	_, err := regexReplace("web", "[", "_")
+	assert.ErrorContains(t, err, `regexReplace: invalid pattern "["`)
+}
+
+func TestBase64(t *testing.T) {
+	tests := templateTestList{
+		{`{{base64Encode "user:secret"}}`, nil, `dXNlcjpzZWNyZXQ=`},
+		{`{{base64Decode "dXNlcjpzZWNyZXQ="}}`, nil, `user:secret`},
+		{`{{base64UrlEncode "subjects?_d"}}`, nil, `c3ViamVjdHM_X2Q`},
+		{`{{base64UrlDecode "c3ViamVjdHM_X2Q"}}`, nil, `subjects?_d`},
+		{`{{base64UrlDecode "dXNlcjpzZWNyZXQ="}}`, nil, `user:secret`},
+		{`{{"user:secret" | base64UrlEncode | base64UrlDecode}}`, nil, `user:secret`},
+		{`{{base64Decode "not base64!"}}`, nil, errors.New("")},
+		{`{{base64UrlDecode "c3ViamVjdHM/X2Q"}}`, nil, errors.New("")},
+	}
+	tests.run(t)
+
+	_, err := base64Decode("dXNlcjpzZWNyZXQ")
+	assert.ErrorContains(t, err, "base64Decode: invalid input")
+}
+
 func TestJson(t *testing.T) {
 	containers := []*context.RuntimeContainer{
 		{
@@ -194,6 +286,38 @@ func TestParseJson(t *testing.T) {
 	tests.run(t)
 }
 
+func TestParseJsonArray(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := templateTestList{
+		{`{{range parseJsonArray .}}{{.host}} {{end}}`, `[{"host":"a"},{"host":"b"}]`, `a b `},
+		{`{{parseJsonArray . | len}}`, `[1, "two", null]`, `3`},
+		{`{{parseJsonArray . | len}}`, ``, `0`},
+		{`{{parseJsonArray . | len}}`, `null`, `0`},
+		{`{{parseJsonArray . | len}}`, `[{"host":`, `0`},
+		{`{{parseJsonArray . | len}}`, `{"host":"a"}`, `0`},
+		{`{{index (parseJsonArray .) 0 | toPrettyJson}}`, `[{"host":"a"}]`, "{\n  \"host\": \"a\"\n}"},
+	}
+
+	tests.run(t)
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv("DOCKER_GEN_TEST_CLUSTER", "prod-eu")
+	t.Setenv("DOCKER_GEN_TEST_EMPTY", "")
+
+	tests := templateTestList{
+		{`{{env "DOCKER_GEN_TEST_CLUSTER"}}`, nil, `prod-eu`},
+		{`{{env "DOCKER_GEN_TEST_UNSET"}}`, nil, ``},
+		{`{{envOr "DOCKER_GEN_TEST_CLUSTER" "dev"}}`, nil, `prod-eu`},
+		{`{{envOr "DOCKER_GEN_TEST_EMPTY" "dev"}}`, nil, `dev`},
+		{`{{envOr "DOCKER_GEN_TEST_UNSET" "dev"}}`, nil, `dev`},
+	}
+
+	tests.run(t)
+}
+
 func TestQueryEscape(t *testing.T) {
 	tests := templateTestList{
 		{`{{queryEscape .}}`, `example.com`, `example.com`},
@@ -205,6 +329,39 @@ func TestQueryEscape(t *testing.T) {
 	tests.run(t)
 }
 
+func TestToYaml(t *testing.T) {
+	container := &context.RuntimeContainer{
+		Labels: map[string]string{
+			"com.example.foo": "bar",
+			"com.example.baz": "1",
+			"a.first":         "yes",
+		},
+	}
+
+	tests := templateTestList{
+		{`{{toYaml .Labels}}`, container, "a.first: \"yes\"\ncom.example.baz: \"1\"\ncom.example.foo: bar"},
+		{"labels:\n{{toYaml .Labels | indent 2}}", container, "labels:\n  a.first: \"yes\"\n  com.example.baz: \"1\"\n  com.example.foo: bar"},
+		{`{{toYaml (list "a" (dict "b" (list 1 2)))}}`, container, "- a\n- b:\n    - 1\n    - 2"},
+	}
+
+	tests.run(t)
+}
+
+func TestClosestDomain(t *testing.T) {
+	domains := []string{"example.com", "b.example.com", "ample.com", "Other.org"}
+	assert.Equal(t, "b.example.com", closestDomain(domains, "a.b.example.com"))
+	assert.Equal(t, "example.com", closestDomain(domains, "x.example.com"), "matches end on a dot")
+	assert.Equal(t, "b.example.com", closestDomain(domains, "b.example.com"))
+	assert.Equal(t, "Other.org", closestDomain(domains, "www.other.ORG"))
+	assert.Equal(t, "", closestDomain(domains, "myexample.net"))
+	assert.Equal(t, "", closestDomain([]string{""}, "example.com"))
+
+	tests := templateTestList{
+		{`{{ closestDomain (split "example.com,b.example.com" ",") "a.b.example.com" }}`, nil, `b.example.com`},
+	}
+	tests.run(t)
+}
+
 func TestArrayClosestExact(t *testing.T) {
 	if arrayClosest([]string{"foo.bar.com", "bar.com"}, "foo.bar.com") != "foo.bar.com" {
 		t.Fatal("Expected foo.bar.com")
@@ -297,4 +454,513 @@ func TestCoalesce(t *testing.T) {
 
 	v = coalesce(nil, nil, nil)
 	assert.Nil(t, v, "Expected nil value")
+
+	empty, host := "", "example.com"
+	var nilString *string
+	var nilContainer *context.RuntimeContainer
+	assert.Equal(t, "default", coalesce("", nil, nilString, &empty, nilContainer, "default"))
+	assert.Equal(t, &host, coalesce(&empty, &host, "default"))
+	assert.Equal(t, 0, coalesce("", 0, 1), "other zero values are kept")
+	assert.Nil(t, coalesce("", &empty, nilContainer))
+
+	container := &context.RuntimeContainer{
+		Env:    map[string]string{"VIRTUAL_HOST": ""},
+		Labels: map[string]string{"com.example.host": ""},
+	}
+	tests := templateTestList{
+		{`{{ coalesce (index .Labels "com.example.host") .Env.VIRTUAL_HOST "localhost" }}`, container, `localhost`},
+		{`{{ coalesce .Labels.missing .Env.missing }}`, container, `<no value>`},
+	}
+	tests.run(t)
+}
+
+func TestMask(t *testing.T) {
+	assert.Equal(t, "", mask(""))
+	assert.Equal(t, "******", mask("secret"))
+	assert.Equal(t, "****", mask("ünïc"))
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "********cdef", redact(4, "456789abcdef"))
+	assert.Equal(t, "****", redact(4, "abcd"))
+	assert.Equal(t, "***", redact(10, "abc"))
+	assert.Equal(t, "***", redact(-1, "abc"))
+	assert.Equal(t, "**c", redact(1, "abc"))
+
+	tests := templateTestList{
+		{`{{ redact 2 . }}`, "token", `***en`},
+		{`{{ . | redact 3 }}`, "password", `*****ord`},
+		{`{{ mask . }}`, "password", `********`},
+	}
+
+	tests.run(t)
+}
+
+func TestPickByHash(t *testing.T) {
+	containers := []*context.RuntimeContainer{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}}
+
+	picked := map[string]interface{}{}
+	for _, key := range []string{"tenant-a", "tenant-b", "tenant-c", "tenant-d", "tenant-e", "tenant-f"} {
+		p, err := pickByHash(key, containers)
+		assert.NoError(t, err)
+		assert.NotNil(t, p)
+		again, _ := pickByHash(key, containers)
+		assert.Same(t, p, again, "picks must be stable")
+		picked[key] = p
+	}
+
+	// removing a container only moves the keys that were assigned to it
+	removed := containers[1]
+	remaining := []*context.RuntimeContainer{containers[0], containers[2], containers[3]}
+	for key, p := range picked {
+		q, _ := pickByHash(key, remaining)
+		if p != removed {
+			assert.Same(t, p, q, key)
+		} else {
+			assert.NotSame(t, removed, q, key)
+		}
+	}
+
+	p, err := pickByHash("tenant-a", []*context.RuntimeContainer{})
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	_, err = pickByHash("tenant-a", "not a slice")
+	assert.Error(t, err)
+
+	tests := templateTestList{
+		{`{{ (pickByHash "tenant-a" .).ID }}`, containers, picked["tenant-a"].(*context.RuntimeContainer).ID},
+		{`{{ pickByHash "key" . }}`, []string{"only"}, `only`},
+		{`{{ with pickByHash "key" . }}{{ . }}{{ else }}none{{ end }}`, []string{}, `none`},
+	}
+
+	tests.run(t)
+}
+
+func TestHtpasswd(t *testing.T) {
+	line, err := htpasswd("user", "password")
+	assert.NoError(t, err)
+	user, hash, _ := strings.Cut(line, ":")
+	assert.Equal(t, "user", user)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte("password")))
+
+	again, err := htpasswd("user", "password")
+	assert.NoError(t, err)
+	assert.Equal(t, line, again, "hashes must be stable across renders")
+
+	for _, invalid := range [][2]string{{"", "password"}, {"us:er", "password"}, {"user", ""}} {
+		_, err := htpasswd(invalid[0], invalid[1])
+		assert.Error(t, err, invalid)
+	}
+
+	lines := make([]string, 4)
+	var wg sync.WaitGroup
+	for i := range lines {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lines[i], _ = htpasswd("concurrent", "password")
+		}(i)
+	}
+	wg.Wait()
+	for _, l := range lines {
+		assert.Equal(t, lines[0], l, "concurrent renders must agree on the line")
+	}
+
+	tests := templateTestList{
+		{`{{ htpasswd "user" "password" }}`, nil, line},
+		{`{{ htpasswd "" "password" }}`, nil, errors.New("")},
+	}
+
+	tests.run(t)
+}
+
+func TestFromEnvList(t *testing.T) {
+	expected := map[string]string{"FOO": "bar", "URL": "http://host/?a=b", "EMPTY": "", "BARE": ""}
+
+	env, err := fromEnvList([]string{"FOO=bar", "URL=http://host/?a=b", "EMPTY=", "BARE"})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, env)
+
+	env, err = fromEnvList("FOO=bar\r\nURL=http://host/?a=b\n\nEMPTY=\nBARE\n")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, env)
+
+	env, err = fromEnvList([]interface{}{"FOO=bar", "URL=http://host/?a=b", "EMPTY=", "BARE"})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, env)
+
+	_, err = fromEnvList(42)
+	assert.Error(t, err)
+}
+
+func TestToEnvList(t *testing.T) {
+	entries, err := toEnvList(map[string]string{"B": "2", "A": "x=y", "C": ""})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A=x=y", "B=2", "C="}, entries)
+
+	entries, err = toEnvList(map[string]interface{}{"PORT": 80, "HOST": "example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HOST=example.com", "PORT=80"}, entries)
+
+	_, err = toEnvList([]string{"A=1"})
+	assert.Error(t, err)
+
+	tests := templateTestList{
+		{`{{ range toEnvList .Env }}{{ . }};{{ end }}`, &context.RuntimeContainer{Env: map[string]string{"B": "2", "A": "1"}}, `A=1;B=2;`},
+		{`{{ range toEnvList (fromEnvList (index .Labels "env")) }}{{ . }};{{ end }}`, &context.RuntimeContainer{Labels: map[string]string{"env": "B=x=y\nA=1"}}, `A=1;B=x=y;`},
+		{`{{ (fromEnvList (list "A=1" "A=2")).A }}`, nil, `2`},
+	}
+
+	tests.run(t)
+}
+
+func TestPickByCount(t *testing.T) {
+	thresholds := map[string]interface{}{"0": "maintenance", "1": "single", "3": "ha"}
+	for count, expected := range map[int]string{0: "maintenance", 1: "single", 2: "single", 3: "ha", 10: "ha"} {
+		picked, err := pickByCount(make([]*context.RuntimeContainer, count), thresholds)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, picked, "%d containers", count)
+	}
+
+	picked, err := pickByCount([]string{}, map[int]string{1: "single"})
+	assert.NoError(t, err)
+	assert.Nil(t, picked)
+
+	picked, err = pickByCount([]string{"a", "b"}, map[int]string{1: "single", 2: "pair"})
+	assert.NoError(t, err)
+	assert.Equal(t, "pair", picked)
+
+	_, err = pickByCount([]string{}, map[string]string{"many": "ha"})
+	assert.Error(t, err)
+	_, err = pickByCount([]string{}, "not a map")
+	assert.Error(t, err)
+	_, err = pickByCount("not a slice", thresholds)
+	assert.Error(t, err)
+
+	containers := []*context.RuntimeContainer{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	tests := templateTestList{
+		{`{{ pickByCount . (dict 0 "maintenance" 1 "single" 3 "ha") }}`, containers, `ha`},
+		{`{{ pickByCount . (dict "0" "maintenance" "1" "single" "3" "ha") }}`, containers[:2], `single`},
+		{`{{ with pickByCount . (dict "1" "single") }}{{ . }}{{ else }}none{{ end }}`, containers[:0], `none`},
+	}
+
+	tests.run(t)
+}
+
+func TestShuffleSeeded(t *testing.T) {
+	values := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	shuffled, err := shuffleSeeded("proxy-1", values)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, values, shuffled)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g", "h"}, values, "the input must not be modified")
+
+	reversed := []string{"h", "g", "f", "e", "d", "c", "b", "a"}
+	again, _ := shuffleSeeded("proxy-1", reversed)
+	assert.Equal(t, shuffled, again, "the order must only depend on the seed and the set")
+
+	seeds := map[string]bool{}
+	for _, seed := range []string{"proxy-1", "proxy-2", "proxy-3", "proxy-4"} {
+		s, _ := shuffleSeeded(seed, values)
+		seeds[strings.Join(s.([]string), "")] = true
+	}
+	assert.Greater(t, len(seeds), 1, "different seeds should give different orders")
+
+	containers := []*context.RuntimeContainer{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}}
+	shuffledContainers, err := shuffleSeeded("proxy-1", containers)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, containers, shuffledContainers)
+	againContainers, _ := shuffleSeeded("proxy-1", []*context.RuntimeContainer{containers[3], containers[1], containers[2], containers[0]})
+	assert.Equal(t, shuffledContainers, againContainers)
+
+	_, err = shuffleSeeded("proxy-1", "not a slice")
+	assert.Error(t, err)
+
+	expected := ""
+	for _, container := range shuffledContainers.([]*context.RuntimeContainer) {
+		expected += container.ID
+	}
+	tests := templateTestList{
+		{`{{ range shuffleSeeded "proxy-1" . }}{{ .ID }}{{ end }}`, containers, expected},
+	}
+
+	tests.run(t)
+}
+
+func TestPortRanges(t *testing.T) {
+	address := func(hostPort, proto string) context.Address {
+		return context.Address{Port: "1", HostPort: hostPort, Proto: proto}
+	}
+	container := &context.RuntimeContainer{Addresses: []context.Address{
+		address("8002", "tcp"), address("8000", "tcp"), address("8001", "tcp"),
+		address("9000", "tcp"), address("8003", "tcp"), address("8001", "tcp"),
+		address("53", "udp"), address("54", "udp"), address("60", "udp"),
+		address("", "tcp"),
+	}}
+
+	assert.Equal(t, []string{"8000-8003/tcp", "9000/tcp", "53-54/udp", "60/udp"}, portRanges(container))
+	assert.Equal(t, []string{}, portRanges(&context.RuntimeContainer{}))
+
+	tests := templateTestList{
+		{`{{ range portRanges . }}{{ . }} {{ end }}`, container, `8000-8003/tcp 9000/tcp 53-54/udp 60/udp `},
+	}
+
+	tests.run(t)
+}
+
+func TestPublishedAddresses(t *testing.T) {
+	container := &context.RuntimeContainer{Addresses: []context.Address{
+		{Port: "8080", Proto: "tcp", HostPort: "18080", HostIP: "0.0.0.0"},
+		{Port: "9000", Proto: "tcp"},
+		{Port: "443", Proto: "udp", HostPort: "443", HostIP: "0.0.0.0"},
+		{Port: "443", Proto: "tcp", HostPort: "443", HostIP: "0.0.0.0"},
+	}}
+
+	assert.Equal(t, []context.Address{
+		{Port: "443", Proto: "tcp", HostPort: "443", HostIP: "0.0.0.0"},
+		{Port: "443", Proto: "udp", HostPort: "443", HostIP: "0.0.0.0"},
+		{Port: "8080", Proto: "tcp", HostPort: "18080", HostIP: "0.0.0.0"},
+	}, publishedAddresses(container))
+	assert.Equal(t, &context.Address{Port: "443", Proto: "tcp", HostPort: "443", HostIP: "0.0.0.0"}, firstPublished(container))
+
+	unpublished := &context.RuntimeContainer{Addresses: []context.Address{{Port: "80", Proto: "tcp"}}}
+	assert.Equal(t, []context.Address{}, publishedAddresses(unpublished))
+	assert.Nil(t, firstPublished(unpublished))
+	assert.Equal(t, []context.Address{}, publishedAddresses(nil))
+	assert.Nil(t, firstPublished(nil))
+
+	tests := templateTestList{
+		{`{{ range publishedAddresses . }}{{ .HostPort }}/{{ .Proto }} {{ end }}`, container, `443/tcp 443/udp 18080/tcp `},
+		{`{{ with firstPublished . }}{{ .HostIP }}:{{ .HostPort }}{{ end }}`, container, `0.0.0.0:443`},
+		{`{{ with firstPublished . }}{{ .HostPort }}{{ else }}none{{ end }}`, unpublished, `none`},
+	}
+
+	tests.run(t)
+}
+
+func TestLabelTree(t *testing.T) {
+	container := &context.RuntimeContainer{Labels: map[string]string{
+		"proxy.http.routers.web.rule":     "Host(`example.com`)",
+		"proxy.http.routers.web.tls":      "true",
+		"proxy.http.routers.web.tls.cert": "web.pem",
+		"proxy.http.services.web.port":    "8080",
+		"proxy":                           "enabled",
+		"proxyother":                      "ignored",
+		"com.example.foo":                 "ignored",
+	}}
+
+	expected := map[string]interface{}{
+		"": "enabled",
+		"http": map[string]interface{}{
+			"routers": map[string]interface{}{
+				"web": map[string]interface{}{
+					"rule": "Host(`example.com`)",
+					"tls":  map[string]interface{}{"": "true", "cert": "web.pem"},
+				},
+			},
+			"services": map[string]interface{}{
+				"web": map[string]interface{}{"port": "8080"},
+			},
+		},
+	}
+	// the collision is resolved the same way whatever the order of the labels
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, expected, labelTree(container, "proxy"))
+	}
+
+	assert.Equal(t, map[string]interface{}{"foo": "ignored"}, labelTree(container, "com.example"))
+	assert.Equal(t, map[string]interface{}{}, labelTree(container, "missing"))
+	assert.Equal(t, map[string]interface{}{}, labelTree(&context.RuntimeContainer{}, "proxy"))
+
+	tests := templateTestList{
+		{`{{ (labelTree . "proxy").http.routers.web.rule }}`, container, "Host(`example.com`)"},
+		{`{{ range $name, $router := (labelTree . "proxy").http.routers }}{{ $name }}={{ $router.tls.cert }}{{ end }}`, container, `web=web.pem`},
+		{`{{ index (labelTree . "proxy").http.routers.web.tls "" }}`, container, `true`},
+	}
+
+	tests.run(t)
+}
+
+func TestHasIPv6(t *testing.T) {
+	assert.False(t, hasIPv6(&context.RuntimeContainer{IP: "10.0.0.1", IP6LinkLocal: "fe80::1"}))
+	assert.False(t, hasIPv6(&context.RuntimeContainer{Networks: []context.Network{{Name: "proxy", IP: "10.0.0.1"}}}))
+	assert.False(t, hasIPv6(nil))
+	assert.True(t, hasIPv6(&context.RuntimeContainer{IP6Global: "2001:db8::1"}))
+	assert.True(t, hasIPv6(&context.RuntimeContainer{Networks: []context.Network{
+		{Name: "proxy", IP: "10.0.0.1"},
+		{Name: "dual", IP: "10.0.1.1", GlobalIPv6Address: "2001:db8::2"},
+	}}))
+
+	tests := templateTestList{
+		{`listen 80;{{ if hasIPv6 . }} listen [::]:80;{{ end }}`, &context.RuntimeContainer{IP6Global: "2001:db8::1"}, `listen 80; listen [::]:80;`},
+		{`listen 80;{{ if hasIPv6 . }} listen [::]:80;{{ end }}`, &context.RuntimeContainer{}, `listen 80;`},
+	}
+
+	tests.run(t)
+}
+
+func TestHealthCheck(t *testing.T) {
+	check, err := healthCheck(&context.RuntimeContainer{Labels: map[string]string{}})
+	assert.NoError(t, err)
+	assert.Equal(t, HealthCheck{Path: "/", Interval: 10 * time.Second, Status: 200}, check)
+
+	check, err = healthCheck(&context.RuntimeContainer{Labels: map[string]string{
+		"docker-gen.healthcheck.path":     "healthz",
+		"docker-gen.healthcheck.interval": "30s",
+		"docker-gen.healthcheck.status":   "204",
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, HealthCheck{Path: "/healthz", Interval: 30 * time.Second, Status: 204}, check)
+
+	for label, value := range map[string]string{
+		"docker-gen.healthcheck.interval": "often",
+		"docker-gen.healthcheck.status":   "ok",
+	} {
+		_, err := healthCheck(&context.RuntimeContainer{Labels: map[string]string{label: value}})
+		assert.Error(t, err, label)
+	}
+	_, err = healthCheck(&context.RuntimeContainer{Labels: map[string]string{"docker-gen.healthcheck.status": "999"}})
+	assert.Error(t, err)
+
+	tests := templateTestList{
+		{`{{ with healthCheck . }}health_check uri={{ .Path }} interval={{ .Interval }} match={{ .Status }};{{ end }}`, &context.RuntimeContainer{
+			Labels: map[string]string{"docker-gen.healthcheck.path": "/status"},
+		}, `health_check uri=/status interval=10s match=200;`},
+	}
+
+	tests.run(t)
+}
+
+func TestPrimaryIP(t *testing.T) {
+	container := &context.RuntimeContainer{
+		IP: "172.17.0.2",
+		Networks: []context.Network{
+			{Name: "backend", IP: "10.0.2.2"},
+			{Name: "proxy", IP: "10.0.1.2"},
+			{Name: "empty"},
+		},
+	}
+	noBridge := &context.RuntimeContainer{Networks: container.Networks}
+
+	for _, tc := range []struct {
+		container *context.RuntimeContainer
+		preferred interface{}
+		expected  string
+	}{
+		{container, []string{"proxy", "backend"}, "10.0.1.2"},
+		{container, []interface{}{"missing", "backend"}, "10.0.2.2"},
+		{container, "empty, backend", "10.0.2.2"},
+		{container, []string{"missing"}, "172.17.0.2"},
+		{container, nil, "172.17.0.2"},
+		{noBridge, []string{"missing"}, "10.0.2.2"},
+		{noBridge, "", "10.0.2.2"},
+		{&context.RuntimeContainer{}, []string{"proxy"}, ""},
+		{nil, []string{"proxy"}, ""},
+	} {
+		ip, err := primaryIP(tc.container, tc.preferred)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, ip, "%v", tc.preferred)
+	}
+
+	_, err := primaryIP(container, 42)
+	assert.Error(t, err)
+
+	tests := templateTestList{
+		{`{{ primaryIP . (list "proxy" "backend") }}`, container, `10.0.1.2`},
+		{`{{ primaryIP . "missing" }}`, container, `172.17.0.2`},
+	}
+
+	tests.run(t)
+}
+
+func TestLabelMap(t *testing.T) {
+	containers := context.Context{
+		{Name: "web", Labels: map[string]string{"export.web/port": "80", "export.shared": "web", "other": "x"}},
+		{Name: "api", Labels: map[string]string{"export.api/port": "8080", "export.shared": "api", "export.": "empty key"}},
+		{Name: "db", Labels: map[string]string{"export.shared": "api"}},
+	}
+
+	m, err := labelMap(containers, "export.")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"web/port": "80", "api/port": "8080", "shared": "web"}, m)
+
+	m, err = labelMap(containers, "export.", "first")
+	assert.NoError(t, err)
+	assert.Equal(t, "api", m["shared"])
+
+	_, err = labelMap(containers, "export.", "error")
+	assert.ErrorContains(t, err, "conflicting values for export.shared on containers api and web")
+
+	m, err = labelMap(containers[1:], "export.", "error")
+	assert.NoError(t, err, "identical values do not conflict")
+	assert.Equal(t, "api", m["shared"])
+
+	_, err = labelMap(containers, "export.", "random")
+	assert.Error(t, err)
+
+	tests := templateTestList{
+		{`{{ toJson (labelMap . "export.") }}`, containers, `{"api/port":"8080","shared":"web","web/port":"80"}`},
+	}
+
+	tests.run(t)
+}
+
+func TestHasKeyMapValue(t *testing.T) {
+	container := &context.RuntimeContainer{
+		Env:    map[string]string{"EMPTY": ""},
+		Labels: map[string]string{"com.example.empty": "", "com.example.port": "8080"},
+	}
+	tests := templateTestList{
+		{`{{ hasKey .Labels "com.example.empty" }}`, container, `true`},
+		{`{{ hasKey .Labels "com.example.missing" }}`, container, `false`},
+		{`{{ hasKey .Env "EMPTY" }}`, container, `true`},
+		{`{{ hasKey (dict "a" "") "a" }}`, container, `true`},
+		{`{{ hasKey nil "a" }}`, container, `false`},
+		{`{{ mapValue .Labels "com.example.port" "80" }}`, container, `8080`},
+		{`{{ mapValue .Labels "com.example.missing" "80" }}`, container, `80`},
+		{`[{{ mapValue .Labels "com.example.empty" "80" }}]`, container, `[]`},
+		{`{{ mapValue .Env "MISSING" "default" }}`, container, `default`},
+		{`{{ hasKey .Name "a" }}`, container, errors.New("")},
+	}
+
+	tests.run(t)
+}
+
+func TestDictMergeSetUnset(t *testing.T) {
+	container := &context.RuntimeContainer{
+		Labels: map[string]string{"com.example.port": "8080"},
+	}
+	tests := templateTestList{
+		{`{{ $d := dictMerge (dict "a" 1 "b" 2) (dict "b" 3 "c" 4) }}{{ $d.a }}{{ $d.b }}{{ $d.c }}`, container, `134`},
+		{`{{ $d := dict "a" 1 }}{{ $m := dictMerge $d (dict "a" 2) }}{{ $d.a }}{{ $m.a }}`, container, `12`},
+		{`{{ index (dictMerge .Labels nil) "com.example.port" }}`, container, `8080`},
+		{`{{ len dictMerge }}`, container, `0`},
+		{`{{ $d := dict "a" 1 }}{{ $s := dictSet $d "b" 2 }}{{ len $d }}{{ len $s }}{{ $s.b }}`, container, `122`},
+		{`{{ $d := dict }}{{ range list "x" "y" }}{{ $d = dictSet $d . true }}{{ end }}{{ keys $d | sortAlpha | join "," }}`, container, `x,y`},
+		{`{{ $d := dict "a" 1 "b" 2 }}{{ $u := dictUnset $d "a" }}{{ len $d }}{{ len $u }}{{ hasKey $u "a" }}`, container, `21false`},
+		{`{{ len (dictUnset nil "a") }}`, container, `0`},
+		{`{{ dictSet .Name "a" 1 }}`, container, errors.New("")},
+	}
+
+	tests.run(t)
+}
+
+func TestJoinStringsTrimStrings(t *testing.T) {
+	labels := map[string]string{"backend": "web : 8080 : 10 : 1", "hosts": " a.com, b.com ,c.com"}
+	tests := templateTestList{
+		{`{{ splitN .backend ":" 3 | len }}`, labels, `3`},
+		{`{{ joinStrings (trimStrings (splitN .backend ":" 3)) "|" }}`, labels, `web|8080|10 : 1`},
+		{`{{ joinStrings (trimStrings (split .hosts ",")) "," }}`, labels, `a.com,b.com,c.com`},
+		{`{{ split .hosts "," | trimStrings | join "," }}`, labels, `a.com,b.com,c.com`},
+		{`{{ joinStrings (list "a" 1) "-" }}`, labels, `a-1`},
+		{`{{ joinStrings nil "," }}`, labels, ``},
+		{`{{ joinStrings (list "a") 1 }}`, labels, errors.New("")},
+		{`{{ trimStrings "a" }}`, labels, errors.New("")},
+		// sprig's join and trimAll are left as is
+		{`{{ list "a" "b" | join "," }}`, labels, `a,b`},
+		{`{{ trimAll "$" "$5.00$" }}`, labels, `5.00`},
+	}
+
+	tests.run(t)
 }
@@ -2,6 +2,7 @@ package template
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/nginx-proxy/docker-gen/internal/context"
@@ -37,25 +38,31 @@ func generalizedGroupByKey(funcName string, entries interface{}, key string, add
 }
 
 func groupByMulti(entries interface{}, key, sep string) (map[string][]interface{}, error) {
-	return generalizedGroupByKey("groupByMulti", entries, key, func(groups map[string][]interface{}, value interface{}, v interface{}) {
-		items := strings.Split(value.(string), sep)
-		for _, item := range items {
-			groups[item] = append(groups[item], v)
-		}
+	return cachedGroups("groupByMulti", entries, []string{key, sep}, func() (map[string][]interface{}, error) {
+		return generalizedGroupByKey("groupByMulti", entries, key, func(groups map[string][]interface{}, value interface{}, v interface{}) {
+			items := strings.Split(value.(string), sep)
+			for _, item := range items {
+				groups[item] = append(groups[item], v)
+			}
+		})
 	})
 }
 
 // groupBy groups a generic array or slice by the path property key
 func groupBy(entries interface{}, key string) (map[string][]interface{}, error) {
-	return generalizedGroupByKey("groupBy", entries, key, func(groups map[string][]interface{}, value interface{}, v interface{}) {
-		groups[value.(string)] = append(groups[value.(string)], v)
+	return cachedGroups("groupBy", entries, []string{key}, func() (map[string][]interface{}, error) {
+		return generalizedGroupByKey("groupBy", entries, key, func(groups map[string][]interface{}, value interface{}, v interface{}) {
+			groups[value.(string)] = append(groups[value.(string)], v)
+		})
 	})
 }
 
 // groupByKeys is the same as groupBy but only returns a list of keys
 func groupByKeys(entries interface{}, key string) ([]string, error) {
-	keys, err := generalizedGroupByKey("groupByKeys", entries, key, func(groups map[string][]interface{}, value interface{}, v interface{}) {
-		groups[value.(string)] = append(groups[value.(string)], v)
+	keys, err := cachedGroups("groupBy", entries, []string{key}, func() (map[string][]interface{}, error) {
+		return generalizedGroupByKey("groupByKeys", entries, key, func(groups map[string][]interface{}, value interface{}, v interface{}) {
+			groups[value.(string)] = append(groups[value.(string)], v)
+		})
 	})
 
 	if err != nil {
@@ -71,16 +78,75 @@ func groupByKeys(entries interface{}, key string) ([]string, error) {
 
 // groupByLabel is the same as groupBy but over a given label
 func groupByLabel(entries interface{}, label string) (map[string][]interface{}, error) {
-	getLabel := func(v interface{}) (interface{}, error) {
+	return cachedGroups("groupByLabel", entries, []string{label}, func() (map[string][]interface{}, error) {
+		return generalizedGroupBy("groupByLabel", entries, containerLabel("groupByLabel", label, nil), addLabelEntry)
+	})
+}
+
+// groupByLabelWithDefault is the same as groupByLabel but groups the
+// containers without the label under defaultValue instead of dropping them
+func groupByLabelWithDefault(entries interface{}, label, defaultValue string) (map[string][]interface{}, error) {
+	return cachedGroups("groupByLabelWithDefault", entries, []string{label, defaultValue}, func() (map[string][]interface{}, error) {
+		return generalizedGroupBy("groupByLabelWithDefault", entries, containerLabel("groupByLabelWithDefault", label, &defaultValue), addLabelEntry)
+	})
+}
+
+// containerLabel returns a getValue function of generalizedGroupBy returning
+// the label of a container, or defaultValue when it is missing. Without
+// defaultValue, the containers missing the label are not grouped.
+func containerLabel(funcName, label string, defaultValue *string) func(interface{}) (interface{}, error) {
+	return func(v interface{}) (interface{}, error) {
 		if container, ok := v.(*context.RuntimeContainer); ok {
 			if value, ok := container.Labels[label]; ok {
 				return value, nil
 			}
+			if defaultValue != nil {
+				return *defaultValue, nil
+			}
 			return nil, nil
 		}
-		return nil, fmt.Errorf("must pass an array or slice of *RuntimeContainer to 'groupByLabel'; received %v", v)
+		return nil, fmt.Errorf("must pass an array or slice of *RuntimeContainer to '%s'; received %v", funcName, v)
+	}
+}
+
+func addLabelEntry(groups map[string][]interface{}, value interface{}, v interface{}) {
+	groups[value.(string)] = append(groups[value.(string)], v)
+}
+
+// VhostGroup is a virtual host and the containers serving it
+type VhostGroup struct {
+	Host       string
+	Containers []*context.RuntimeContainer
+}
+
+// vhostGroups groups the containers by the comma separated hosts of their
+// hostLabel label, ignoring the containers without it. The groups are sorted
+// by host and the containers of each group by name and ID, so that the output
+// only changes when the containers do.
+func vhostGroups(containers context.Context, hostLabel string) []VhostGroup {
+	hosts := make(map[string][]*context.RuntimeContainer)
+	for _, container := range containers {
+		seen := make(map[string]bool)
+		for _, host := range strings.Split(container.Labels[hostLabel], ",") {
+			if host = strings.TrimSpace(host); host != "" && !seen[host] {
+				seen[host] = true
+				hosts[host] = append(hosts[host], container)
+			}
+		}
+	}
+
+	groups := make([]VhostGroup, 0, len(hosts))
+	for host, members := range hosts {
+		sort.Slice(members, func(i, j int) bool {
+			if members[i].Name != members[j].Name {
+				return members[i].Name < members[j].Name
+			}
+			return members[i].ID < members[j].ID
+		})
+		groups = append(groups, VhostGroup{Host: host, Containers: members})
 	}
-	return generalizedGroupBy("groupByLabel", entries, getLabel, func(groups map[string][]interface{}, value interface{}, v interface{}) {
-		groups[value.(string)] = append(groups[value.(string)], v)
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Host < groups[j].Host
 	})
+	return groups
 }
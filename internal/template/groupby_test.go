@@ -152,6 +152,28 @@ func TestGroupByLabel(t *testing.T) {
 	assert.Equal(t, "2", groups["two"][0].(*context.RuntimeContainer).ID)
 }
 
+func TestGroupByLabelWithDefault(t *testing.T) {
+	containers := []*context.RuntimeContainer{
+		{Labels: map[string]string{"com.docker.compose.project": "one"}, ID: "1"},
+		{ID: "2"},
+		{Labels: map[string]string{"com.docker.compose.project": ""}, ID: "3"},
+		{Labels: map[string]string{"other": "one"}, ID: "4"},
+	}
+
+	groups, err := groupByLabelWithDefault(containers, "com.docker.compose.project", "standalone")
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 3)
+	assert.Len(t, groups["one"], 1)
+	assert.Len(t, groups[""], 1, "an empty label is not missing")
+	assert.Len(t, groups["standalone"], 2)
+	assert.Equal(t, "2", groups["standalone"][0].(*context.RuntimeContainer).ID)
+	assert.Equal(t, "4", groups["standalone"][1].(*context.RuntimeContainer).ID)
+
+	_, err = groupByLabelWithDefault([]string{"foo"}, "com.docker.compose.project", "standalone")
+	assert.ErrorContains(t, err, "groupByLabelWithDefault")
+}
+
 func TestGroupByLabelError(t *testing.T) {
 	strings := []string{"foo", "bar", "baz"}
 	groups, err := groupByLabel(strings, "")
@@ -203,3 +225,26 @@ func TestGroupByMulti(t *testing.T) {
 		t.Fatalf("expected 2 got %s", groups["demo3.localhost"][0].(*context.RuntimeContainer).ID)
 	}
 }
+
+func TestVhostGroups(t *testing.T) {
+	a := &context.RuntimeContainer{ID: "1", Name: "web-2", Labels: map[string]string{"vhost": "example.com, www.example.com"}}
+	b := &context.RuntimeContainer{ID: "2", Name: "web-1", Labels: map[string]string{"vhost": "example.com,example.com"}}
+	c := &context.RuntimeContainer{ID: "3", Name: "api", Labels: map[string]string{"vhost": "api.example.com,"}}
+	d := &context.RuntimeContainer{ID: "4", Name: "db", Labels: map[string]string{}}
+	e := &context.RuntimeContainer{ID: "0", Name: "web-1", Labels: map[string]string{"vhost": "example.com"}}
+
+	expected := []VhostGroup{
+		{Host: "api.example.com", Containers: []*context.RuntimeContainer{c}},
+		{Host: "example.com", Containers: []*context.RuntimeContainer{e, b, a}},
+		{Host: "www.example.com", Containers: []*context.RuntimeContainer{a}},
+	}
+	assert.Equal(t, expected, vhostGroups(context.Context{a, b, c, d, e}, "vhost"))
+	assert.Equal(t, expected, vhostGroups(context.Context{e, d, c, b, a}, "vhost"), "the input order does not matter")
+	assert.Equal(t, []VhostGroup{}, vhostGroups(context.Context{d}, "vhost"))
+
+	tests := templateTestList{
+		{`{{ range vhostGroups . "vhost" }}{{ .Host }}:{{ range .Containers }} {{ .Name }}{{ end }};{{ end }}`, context.Context{a, b, c, d}, `api.example.com: api;example.com: web-1 web-2;www.example.com: web-2;`},
+	}
+
+	tests.run(t)
+}
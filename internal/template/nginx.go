@@ -0,0 +1,55 @@
+package template
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/nginx-proxy/docker-gen/internal/context"
+)
+
+// serverNames returns the space separated host names of a container, ready
+// for an nginx server_name directive: the comma separated names of its label
+// key (or, if there is no such label, of its environment variable key), and
+// its network aliases. Every name equal to one of the wildcard domains is
+// completed with the matching wildcard name (example.com and *.example.com).
+// The names are deduplicated and sorted.
+func serverNames(container *context.RuntimeContainer, key string, wildcardDomains ...string) string {
+	if container == nil {
+		return ""
+	}
+
+	value, ok := container.Labels[key]
+	if !ok {
+		value = container.Env[key]
+	}
+	candidates := strings.Split(value, ",")
+	for _, network := range container.Networks {
+		for _, alias := range network.Aliases {
+			// docker aliases containers on user defined networks with their short ID
+			if len(container.ID) < 12 || alias != container.ID[:12] {
+				candidates = append(candidates, alias)
+			}
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, name := range candidates {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		names[name] = true
+		for _, domain := range wildcardDomains {
+			if name == strings.ToLower(domain) {
+				names["*."+name] = true
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, " ")
+}
@@ -0,0 +1,33 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerNames(t *testing.T) {
+	container := &context.RuntimeContainer{
+		ID:     "0123456789abcdef",
+		Env:    map[string]string{"VIRTUAL_HOST": "www.example.com, Example.com,,api.example.com"},
+		Labels: map[string]string{"proxy.hosts": "labeled.example.com"},
+		Networks: []context.Network{
+			{Name: "proxy", Aliases: []string{"web", "0123456789ab", "www.example.com"}},
+			{Name: "other", Aliases: []string{"web.internal", "0123"}},
+		},
+	}
+
+	assert.Equal(t, "0123 api.example.com example.com web web.internal www.example.com", serverNames(container, "VIRTUAL_HOST"))
+	assert.Equal(t, "*.example.com 0123 api.example.com example.com web web.internal www.example.com", serverNames(container, "VIRTUAL_HOST", "example.com"))
+	assert.Equal(t, "0123 labeled.example.com web web.internal www.example.com", serverNames(container, "proxy.hosts"))
+	assert.Equal(t, "", serverNames(&context.RuntimeContainer{}, "VIRTUAL_HOST"))
+	assert.Equal(t, "", serverNames(nil, "VIRTUAL_HOST"))
+
+	tests := templateTestList{
+		{`server_name {{ serverNames . "VIRTUAL_HOST" "example.com" }};`, container, `server_name *.example.com 0123 api.example.com example.com web web.internal www.example.com;`},
+		{`{{ with serverNames . "VIRTUAL_HOST" }}server_name {{ . }};{{ else }}# no server name{{ end }}`, &context.RuntimeContainer{}, `# no server name`},
+	}
+
+	tests.run(t)
+}
@@ -1,11 +1,12 @@
 package template
 
 import (
-	"log"
 	"math"
 	"reflect"
 	"strconv"
 	"strings"
+
+	"github.com/nginx-proxy/docker-gen/internal/logging"
 )
 
 func deepGetImpl(v reflect.Value, path []string) interface{} {
@@ -19,18 +20,29 @@ func deepGetImpl(v reflect.Value, path []string) interface{} {
 		v = v.Elem()
 	}
 	if v.Kind() == reflect.Pointer {
-		log.Printf("unable to descend into pointer of a pointer\n")
+		logging.Warnf("unable to descend into pointer of a pointer\n")
 		return nil
 	}
 	switch v.Kind() {
 	case reflect.Struct:
 		return deepGetImpl(v.FieldByName(path[0]), path[1:])
 	case reflect.Map:
+		// map keys may contain dots, like label keys (e.g. com.example.foo):
+		// the longest key made of the next path elements joined with dots wins
+		if v.Type().Key().Kind() == reflect.String {
+			for n := len(path); n >= 1; n-- {
+				key := reflect.ValueOf(strings.Join(path[:n], ".")).Convert(v.Type().Key())
+				if value := v.MapIndex(key); value.IsValid() {
+					return deepGetImpl(value, path[n:])
+				}
+			}
+			return nil
+		}
 		return deepGetImpl(v.MapIndex(reflect.ValueOf(path[0])), path[1:])
 	case reflect.Slice, reflect.Array:
 		iu64, err := strconv.ParseUint(path[0], 10, 64)
 		if err != nil {
-			log.Printf("non-negative decimal number required for array/slice index, got %#v\n", path[0])
+			logging.Warnf("non-negative decimal number required for array/slice index, got %#v\n", path[0])
 			return nil
 		}
 		if iu64 > math.MaxInt {
@@ -38,12 +50,12 @@ func deepGetImpl(v reflect.Value, path []string) interface{} {
 		}
 		i := int(iu64)
 		if i >= v.Len() {
-			log.Printf("index %v out of bounds", i)
+			logging.Warnf("index %v out of bounds", i)
 			return nil
 		}
 		return deepGetImpl(v.Index(i), path[1:])
 	default:
-		log.Printf("unable to index by %s (value %v, kind %s)\n", path[0], v, v.Kind())
+		logging.Warnf("unable to index by %s (value %v, kind %s)\n", path[0], v, v.Kind())
 		return nil
 	}
 }
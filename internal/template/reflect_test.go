@@ -93,3 +93,18 @@ func TestDeepGet(t *testing.T) {
 		})
 	}
 }
+
+func TestDeepGetDottedMapKey(t *testing.T) {
+	item := context.RuntimeContainer{
+		Labels: map[string]string{
+			"com.example.priority": "10",
+			"com":                  "short",
+		},
+		Env: map[string]string{"VIRTUAL_HOST": "example.com"},
+	}
+	assert.Equal(t, "10", deepGet(item, ".Labels.com.example.priority"))
+	assert.Equal(t, "short", deepGet(item, "Labels.com"))
+	assert.Equal(t, "example.com", deepGet(item, "Env.VIRTUAL_HOST"))
+	assert.Nil(t, deepGet(item, "Labels.com.example.missing"))
+	assert.Nil(t, deepGet(item, "Env.MISSING"))
+}
@@ -0,0 +1,128 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nginx-proxy/docker-gen/internal/config"
+)
+
+// remoteClient fetches the templates given as http(s) URLs
+var remoteClient = &http.Client{Timeout: 30 * time.Second}
+
+var (
+	remoteMu        sync.RWMutex
+	remoteTemplates = make(map[string][]byte)
+)
+
+// stdin is where the template given as "-" is read from, once
+var stdin io.Reader = os.Stdin
+
+// isRemote returns whether the template is fetched from an http(s) URL
+func isRemote(templatePath string) bool {
+	return strings.HasPrefix(templatePath, "http://") || strings.HasPrefix(templatePath, "https://")
+}
+
+// isStdin returns whether the template is read from the standard input
+func isStdin(templatePath string) bool {
+	return templatePath == "-"
+}
+
+// templateName returns the name of the template, the base name of its path
+func templateName(templatePath string) string {
+	if isRemote(templatePath) {
+		if u, err := url.Parse(templatePath); err == nil {
+			return path.Base(u.Path)
+		}
+	}
+	return filepath.Base(templatePath)
+}
+
+// FetchRemoteTemplates fetches the templates of the configs given as http(s)
+// URLs and verifies their checksums. The fetched templates are rendered until
+// they are fetched again; if a fetch fails, the previous version is kept.
+// The template given as "-" is read from the standard input the first time
+// only, as it cannot be read again.
+func FetchRemoteTemplates(configFile config.ConfigFile) error {
+	for i, config := range configFile.Config {
+		var err error
+		switch {
+		case isRemote(config.Template):
+			_, err = fetchRemoteTemplate(config)
+		case isStdin(config.Template):
+			_, err = remoteTemplate(config)
+		}
+		if err != nil {
+			return fmt.Errorf("config #%d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// readStdinTemplate reads the template from the standard input
+func readStdinTemplate() ([]byte, error) {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	if contents, ok := remoteTemplates["-"]; ok {
+		return contents, nil
+	}
+	contents, err := io.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template from stdin: %w", err)
+	}
+	remoteTemplates["-"] = contents
+	return contents, nil
+}
+
+func fetchRemoteTemplate(config config.Config) ([]byte, error) {
+	resp, err := remoteClient.Get(config.Template)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch template: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch template %s: %s", config.Template, resp.Status)
+	}
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch template %s: %w", config.Template, err)
+	}
+
+	if config.TemplateChecksum != "" {
+		sum := sha256.Sum256(contents)
+		expected := strings.ToLower(strings.TrimPrefix(config.TemplateChecksum, "sha256:"))
+		if actual := hex.EncodeToString(sum[:]); actual != expected {
+			return nil, fmt.Errorf("checksum mismatch for template %s: expected sha256:%s, got sha256:%s", config.Template, expected, actual)
+		}
+	}
+
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	remoteTemplates[config.Template] = contents
+	return contents, nil
+}
+
+// remoteTemplate returns the fetched (or read from stdin) template, fetching
+// it if needed
+func remoteTemplate(config config.Config) ([]byte, error) {
+	remoteMu.RLock()
+	contents, ok := remoteTemplates[config.Template]
+	remoteMu.RUnlock()
+	if ok {
+		return contents, nil
+	}
+	if isStdin(config.Template) {
+		return readStdinTemplate()
+	}
+	return fetchRemoteTemplate(config)
+}
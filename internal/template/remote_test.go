@@ -0,0 +1,75 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nginx-proxy/docker-gen/internal/config"
+	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteTemplate(t *testing.T) {
+	contents := `{{ range . }}{{ .Name }};{{ end }}`
+	available := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available || r.URL.Path != "/templates/nginx.tmpl" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(contents))
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256([]byte(contents))
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+	url := server.URL + "/templates/nginx.tmpl"
+	containers := context.Context{{Name: "a"}, {Name: "b"}}
+
+	assert.Equal(t, "nginx.tmpl", templateName(url))
+	assert.Equal(t, "nginx.tmpl", templateName("/etc/docker-gen/nginx.tmpl"))
+
+	cfg := config.Config{Template: url, TemplateChecksum: checksum}
+	assert.NoError(t, FetchRemoteTemplates(config.ConfigFile{Config: []config.Config{{Template: "local.tmpl"}, cfg}}))
+	assert.Equal(t, "a;b;", executeString(t, cfg, containers))
+
+	// the fetched version is kept when fetching again fails
+	available = false
+	err := FetchRemoteTemplates(config.ConfigFile{Config: []config.Config{cfg}})
+	assert.ErrorContains(t, err, "config #0: unable to fetch template")
+	assert.Equal(t, "a;b;", executeString(t, cfg, containers))
+
+	available = true
+	err = FetchRemoteTemplates(config.ConfigFile{Config: []config.Config{{Template: url, TemplateChecksum: "sha256:0000"}}})
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	missing := config.Config{Template: server.URL + "/templates/missing.tmpl"}
+	assert.Len(t, CheckConfigs(config.ConfigFile{Config: []config.Config{cfg, missing}}), 1)
+}
+
+func TestStdinTemplate(t *testing.T) {
+	previous := stdin
+	defer func() {
+		stdin = previous
+		remoteMu.Lock()
+		delete(remoteTemplates, "-")
+		remoteMu.Unlock()
+	}()
+	input := strings.NewReader(`{{ range . }}{{ .Name }};{{ end }}`)
+	stdin = input
+
+	configs := config.ConfigFile{Config: []config.Config{{Template: "-"}, {Template: "-"}}}
+	assert.NoError(t, FetchRemoteTemplates(configs))
+	assert.Zero(t, input.Len(), "stdin is consumed")
+
+	// the template read once is kept, as stdin cannot be read again
+	assert.NoError(t, FetchRemoteTemplates(configs))
+	containers := context.Context{{Name: "a"}, {Name: "b"}}
+	for _, cfg := range configs.Config {
+		assert.Equal(t, "a;b;", executeString(t, cfg, containers))
+	}
+}
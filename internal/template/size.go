@@ -0,0 +1,69 @@
+package template
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// binarySizeUnits are the units of humanSize, each 1024 times the previous one
+var binarySizeUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// sizeMultipliers are the units accepted by parseSize, lowercased: IEC units
+// and docker's single letters are powers of 1024, SI units powers of 1000
+var sizeMultipliers = map[string]float64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kib": 1 << 10, "kb": 1e3,
+	"m": 1 << 20, "mib": 1 << 20, "mb": 1e6,
+	"g": 1 << 30, "gib": 1 << 30, "gb": 1e9,
+	"t": 1 << 40, "tib": 1 << 40, "tb": 1e12,
+	"p": 1 << 50, "pib": 1 << 50, "pb": 1e15,
+	"e": 1 << 60, "eib": 1 << 60, "eb": 1e18,
+}
+
+// humanSize formats a byte count, given as a number or a string holding a
+// number, with the largest binary unit keeping it at least 1, and up to two
+// decimals: 1610612736 is "1.5GiB"
+func humanSize(input interface{}) (string, error) {
+	size, ok := toFloat(input)
+	if !ok {
+		return "", fmt.Errorf("humanSize: invalid size %v", input)
+	}
+	sign := ""
+	if size < 0 {
+		sign, size = "-", -size
+	}
+	unit := 0
+	for size >= 1024 && unit < len(binarySizeUnits)-1 {
+		size /= 1024
+		unit++
+	}
+	return sign + strconv.FormatFloat(math.Round(size*100)/100, 'f', -1, 64) + binarySizeUnits[unit], nil
+}
+
+// parseSize returns the number of bytes of a size like "1.5GiB", "512m" or
+// "10MB", the inverse of humanSize. The unit is case-insensitive.
+func parseSize(s string) (int64, error) {
+	trimmed := strings.TrimSpace(s)
+	i := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(trimmed)
+	}
+	value, err := strconv.ParseFloat(trimmed[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("parseSize: invalid size %q", s)
+	}
+	multiplier, ok := sizeMultipliers[strings.ToLower(strings.TrimSpace(trimmed[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("parseSize: invalid unit in size %q", s)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, the first overflowing size
+	size := value * multiplier
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("parseSize: size %q overflows int64", s)
+	}
+	return int64(size), nil
+}
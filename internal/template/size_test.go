@@ -0,0 +1,59 @@
+package template
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHumanSize(t *testing.T) {
+	tests := templateTestList{
+		{`{{ humanSize 0 }}`, nil, `0B`},
+		{`{{ humanSize 512 }}`, nil, `512B`},
+		{`{{ humanSize 1536 }}`, nil, `1.5KiB`},
+		{`{{ humanSize 536870912 }}`, nil, `512MiB`},
+		{`{{ humanSize 1610612736 }}`, nil, `1.5GiB`},
+		{`{{ humanSize 1234567890 }}`, nil, `1.15GiB`},
+		{`{{ humanSize "1073741824" }}`, nil, `1GiB`},
+		{`{{ humanSize .SizeRw }}`, map[string]int64{"SizeRw": 2048}, `2KiB`},
+		{`{{ humanSize -2048 }}`, nil, `-2KiB`},
+		{`{{ humanSize "lots" }}`, nil, errors.New("")},
+		{`{{ humanSize "NaN" }}`, nil, errors.New("")},
+		{`{{ humanSize "-Inf" }}`, nil, errors.New("")},
+		{`{{ humanSize .Size }}`, map[string]float64{"Size": math.Inf(1)}, errors.New("")},
+	}
+
+	tests.run(t)
+}
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"0":       0,
+		"512":     512,
+		"512B":    512,
+		"1.5KiB":  1536,
+		"512MiB":  536870912,
+		"512m":    536870912,
+		"1.5GiB":  1610612736,
+		"1g":      1073741824,
+		"10MB":    10000000,
+		" 2 gib ": 2147483648,
+		"7EiB":    8070450532247928832,
+	} {
+		size, err := parseSize(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, size, s)
+	}
+
+	for _, s := range []string{"", "GiB", "1.5 parsecs", "-1GiB", "8EiB", "10EB", "99999999999999999999"} {
+		_, err := parseSize(s)
+		assert.Error(t, err, s)
+	}
+
+	tests := templateTestList{
+		{`{{ parseSize "1.5GiB" | humanSize }}`, nil, `1.5GiB`},
+	}
+	tests.run(t)
+}
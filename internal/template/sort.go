@@ -1,8 +1,16 @@
 package template
 
 import (
+	"fmt"
+	"math"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/nginx-proxy/docker-gen/internal/logging"
 )
 
 // sortStrings returns a sorted array of strings in increasing order
@@ -38,6 +46,8 @@ func (s sortableData) Swap(i, j int) { s.data[i], s.data[j] = s.data[j], s.data[
 type sortableByKey struct {
 	sortableData
 	key string
+	// typed compares the values with compareValues, rather than as strings
+	typed bool
 }
 
 func (s *sortableByKey) set(funcName string, entries interface{}) (err error) {
@@ -54,13 +64,22 @@ func (s *sortableByKey) set(funcName string, entries interface{}) (err error) {
 
 // method required to implement sort.Interface
 func (s sortableByKey) Less(i, j int) bool {
-	values := map[int]string{i: "", j: ""}
-	for k := range values {
-		if v := reflect.ValueOf(deepGet(s.data[k], s.key)); v.Kind() != reflect.Invalid {
-			values[k] = v.Interface().(string)
-		}
+	a, b := deepGet(s.data[i], s.key), deepGet(s.data[j], s.key)
+	if s.typed {
+		return compareValues(a, b) < 0
 	}
-	return values[i] < values[j]
+	return lexicalValue(a) < lexicalValue(b)
+}
+
+// lexicalValue returns the string a value is sorted by, "" for a missing one
+func lexicalValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
 }
 
 // Generalized SortBy function
@@ -77,6 +96,13 @@ func generalizedSortBy(funcName string, entries interface{}, s sortable, reverse
 	return s.get(), nil
 }
 
+// sortObjectsByKeys returns a sorted array of objects, sorted by object's key
+// field in ascending order, comparing the values with compareValues
+func sortObjectsByKeys(objs interface{}, key string) ([]interface{}, error) {
+	s := &sortableByKey{key: key, typed: true}
+	return generalizedSortBy("sortObjectsByKeys", objs, s, false)
+}
+
 // sortObjectsByKeysAsc returns a sorted array of objects, sorted by object's key field in ascending order
 func sortObjectsByKeysAsc(objs interface{}, key string) ([]interface{}, error) {
 	s := &sortableByKey{key: key}
@@ -88,3 +114,172 @@ func sortObjectsByKeysDesc(objs interface{}, key string) ([]interface{}, error)
 	s := &sortableByKey{key: key}
 	return generalizedSortBy("sortObjsByKey", objs, s, true)
 }
+
+type sortableByKeys struct {
+	sortableByKey
+	keys []string
+}
+
+// method required to implement sort.Interface
+func (s sortableByKeys) Less(i, j int) bool {
+	for _, key := range s.keys {
+		if c := compareValues(deepGet(s.data[i], key), deepGet(s.data[j], key)); c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+// compareValues compares two values chronologically if they are both times,
+// numerically if they are both numbers or strings holding numbers, and as
+// strings otherwise. A missing value is the zero of the other one.
+func compareValues(a, b interface{}) int {
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			return at.Compare(bt)
+		}
+	}
+
+	af, aNumber := toFloat(a)
+	bf, bNumber := toFloat(b)
+	switch {
+	case aNumber && bNumber, aNumber && b == nil, a == nil && bNumber:
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+
+	as, bs := "", ""
+	if a != nil {
+		as = fmt.Sprint(a)
+	}
+	if b != nil {
+		bs = fmt.Sprint(b)
+	}
+	return strings.Compare(as, bs)
+}
+
+// toFloat returns the value of a number of any type, or of a string holding
+// a number, as a float64. NaN and infinities are not numbers to it.
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+		return f, err == nil && isFinite(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), isFinite(rv.Float())
+	}
+	return 0, false
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// sortByKeys returns a sorted array of objects, sorted by the values of the
+// key paths in ascending order: by the first key, then by the second key for
+// the objects with the same first key value, and so on. Objects with the same
+// values for every key keep their order.
+func sortByKeys(objs interface{}, keys interface{}) ([]interface{}, error) {
+	keysVal, err := getArrayValues("sortByKeys", keys)
+	if err != nil {
+		return nil, err
+	}
+	s := &sortableByKeys{}
+	for i := 0; i < keysVal.Len(); i++ {
+		s.keys = append(s.keys, fmt.Sprint(keysVal.Index(i).Interface()))
+	}
+	return generalizedSortBy("sortByKeys", objs, s, false)
+}
+
+const (
+	composeProjectLabel   = "com.docker.compose.project"
+	composeServiceLabel   = "com.docker.compose.service"
+	composeDependsOnLabel = "com.docker.compose.depends_on"
+)
+
+// composeService returns the compose project and service of a container, or
+// its name, as a key telling apart the services of different projects
+func composeService(container *context.RuntimeContainer) string {
+	if service, ok := container.Labels[composeServiceLabel]; ok {
+		return container.Labels[composeProjectLabel] + "\x00" + service
+	}
+	return container.Name
+}
+
+// composeDependencies returns the keys of the services a container depends on,
+// in its own project, read from the compose depends_on label
+// (e.g. "db:service_started:false,cache:service_healthy:false")
+func composeDependencies(container *context.RuntimeContainer) []string {
+	dependencies := []string{}
+	for _, dependency := range strings.Split(container.Labels[composeDependsOnLabel], ",") {
+		if service, _, _ := strings.Cut(strings.TrimSpace(dependency), ":"); service != "" {
+			dependencies = append(dependencies, container.Labels[composeProjectLabel]+"\x00"+service)
+		}
+	}
+	return dependencies
+}
+
+// sortByDependency returns the containers topologically sorted so that every
+// container comes after the containers it depends on through compose depends_on.
+// Containers that are otherwise unordered are sorted by name. If the dependencies
+// contain a cycle, the containers are sorted by name only.
+func sortByDependency(containers context.Context) context.Context {
+	byName := make(context.Context, len(containers))
+	copy(byName, containers)
+	sort.SliceStable(byName, func(i, j int) bool {
+		return byName[i].Name < byName[j].Name
+	})
+
+	services := make(map[string][]*context.RuntimeContainer)
+	for _, container := range byName {
+		services[composeService(container)] = append(services[composeService(container)], container)
+	}
+
+	// dependents maps each container to the containers depending on it
+	dependents := make(map[*context.RuntimeContainer][]*context.RuntimeContainer)
+	pending := make(map[*context.RuntimeContainer]int)
+	for _, container := range byName {
+		for _, service := range composeDependencies(container) {
+			for _, dependency := range services[service] {
+				if dependency == container {
+					continue
+				}
+				dependents[dependency] = append(dependents[dependency], container)
+				pending[container]++
+			}
+		}
+	}
+
+	sorted := make(context.Context, 0, len(byName))
+	done := make(map[*context.RuntimeContainer]bool)
+	for len(sorted) < len(byName) {
+		progress := false
+		for _, container := range byName {
+			if done[container] || pending[container] > 0 {
+				continue
+			}
+			done[container] = true
+			sorted = append(sorted, container)
+			for _, dependent := range dependents[container] {
+				pending[dependent]--
+			}
+			progress = true
+			break
+		}
+		if !progress {
+			logging.Warnf("Dependency cycle detected between containers, sorting them by name\n")
+			return byName
+		}
+	}
+	return sorted
+}
@@ -2,6 +2,7 @@ package template
 
 import (
 	"testing"
+	"time"
 
 	"github.com/nginx-proxy/docker-gen/internal/context"
 	"github.com/stretchr/testify/assert"
@@ -64,3 +65,156 @@ func TestSortObjectsByKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestSortByDependency(t *testing.T) {
+	service := func(name, dependsOn string) *context.RuntimeContainer {
+		labels := map[string]string{"com.docker.compose.service": name}
+		if dependsOn != "" {
+			labels["com.docker.compose.depends_on"] = dependsOn
+		}
+		return &context.RuntimeContainer{Name: "project-" + name + "-1", Labels: labels}
+	}
+	names := func(containers context.Context) []string {
+		result := []string{}
+		for _, container := range containers {
+			result = append(result, container.Name)
+		}
+		return result
+	}
+
+	app := service("app", "db:service_started:false,cache:service_healthy:false")
+	worker := service("worker", "db:service_started:false")
+	db := service("db", "")
+	cache := service("cache", "")
+	standalone := &context.RuntimeContainer{Name: "standalone"}
+
+	sorted := sortByDependency(context.Context{app, worker, standalone, db, cache})
+	assert.Equal(t, []string{"project-cache-1", "project-db-1", "project-app-1", "project-worker-1", "standalone"}, names(sorted))
+
+	// dependencies outside of the container set are ignored
+	sorted = sortByDependency(context.Context{worker, app})
+	assert.Equal(t, []string{"project-app-1", "project-worker-1"}, names(sorted))
+
+	// cycles fall back to name ordering
+	a := service("a", "b:service_started:false")
+	b := service("b", "a:service_started:false")
+	sorted = sortByDependency(context.Context{b, db, a})
+	assert.Equal(t, []string{"project-a-1", "project-b-1", "project-db-1"}, names(sorted))
+
+	// services are only depended on in their own project
+	otherDB := &context.RuntimeContainer{Name: "z-other-db-1", Labels: map[string]string{
+		"com.docker.compose.project": "other",
+		"com.docker.compose.service": "db",
+	}}
+	otherApp := &context.RuntimeContainer{Name: "other-app-1", Labels: map[string]string{
+		"com.docker.compose.project":    "other",
+		"com.docker.compose.service":    "app",
+		"com.docker.compose.depends_on": "db:service_started:false",
+	}}
+	sorted = sortByDependency(context.Context{otherApp, worker, otherDB})
+	assert.Equal(t, []string{"project-worker-1", "z-other-db-1", "other-app-1"}, names(sorted))
+
+	assert.Empty(t, sortByDependency(context.Context{}))
+}
+
+func TestSortByKeys(t *testing.T) {
+	type task struct {
+		Service string
+		Slot    int
+		Labels  map[string]string
+	}
+	t0 := &task{Service: "web", Slot: 10}
+	t1 := &task{Service: "api", Slot: 2}
+	t2 := &task{Service: "web", Slot: 9}
+	t3 := &task{Service: "api", Slot: 1}
+	t4 := &task{Service: "web", Slot: 9, Labels: map[string]string{"tie": "first"}}
+	t5 := &task{Slot: 3}
+	tasks := []*task{t0, t1, t2, t3, t4, t5}
+
+	sorted, err := sortByKeys(tasks, []string{"Service", "Slot"})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{t5, t3, t1, t2, t4, t0}, sorted, "slots are compared numerically and ties keep their order")
+
+	sorted, err = sortByKeys(tasks, []interface{}{".Slot"})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{t3, t1, t5, t2, t4, t0}, sorted)
+
+	// a missing value is the zero of the other one
+	containers := []*context.RuntimeContainer{
+		{Name: "b", Env: map[string]string{"PRIORITY": "2"}},
+		{Name: "a", Env: map[string]string{}},
+		{Name: "c", Env: map[string]string{"PRIORITY": "1"}},
+	}
+	sorted, err = sortByKeys(containers, []string{"Env.PRIORITY", "Name"})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{containers[1], containers[2], containers[0]}, sorted)
+	assert.Equal(t, -1, compareValues(nil, 1))
+	assert.Equal(t, 0, compareValues(nil, 0))
+	assert.Equal(t, 1, compareValues("b", nil))
+	assert.Equal(t, -1, compareValues(uint8(2), 10.5))
+
+	_, err = sortByKeys(containers, "Name")
+	assert.Error(t, err)
+
+	tests := templateTestList{
+		{`{{ range sortByKeys . (list "Env.PRIORITY" "Name") }}{{ .Name }}{{ end }}`, containers, `acb`},
+	}
+
+	tests.run(t)
+}
+
+func TestSortObjectsByKeysNumericAndMissing(t *testing.T) {
+	o0 := &context.RuntimeContainer{Name: "ten", Labels: map[string]string{"com.example.priority": "10"}}
+	o1 := &context.RuntimeContainer{Name: "nine", Labels: map[string]string{"com.example.priority": "9"}}
+	o2 := &context.RuntimeContainer{Name: "none", Labels: map[string]string{}}
+	o3 := &context.RuntimeContainer{Name: "word", Labels: map[string]string{"com.example.priority": "high"}}
+	o4 := &context.RuntimeContainer{Name: "nine-bis", Labels: map[string]string{"com.example.priority": "9"}}
+	containers := []*context.RuntimeContainer{o0, o1, o2, o3, o4}
+
+	sorted, err := sortObjectsByKeys(containers, ".Labels.com.example.priority")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{o2, o1, o4, o0, o3}, sorted)
+
+	// the Asc and Desc variants compare the values as strings
+	sorted, err = sortObjectsByKeysAsc(containers, ".Labels.com.example.priority")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{o2, o0, o1, o4, o3}, sorted)
+
+	sorted, err = sortObjectsByKeysDesc(containers, ".Labels.com.example.priority")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{o3, o1, o4, o0, o2}, sorted)
+
+	// values of other types than strings do not panic
+	sizes := []*context.RuntimeContainer{{Name: "a", SizeRw: 20}, {Name: "b", SizeRw: 3}}
+	sorted, err = sortObjectsByKeys(sizes, "SizeRw")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", sorted[0].(*context.RuntimeContainer).Name)
+	sorted, err = sortObjectsByKeysAsc(sizes, "SizeRw")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", sorted[0].(*context.RuntimeContainer).Name)
+
+	tests := templateTestList{
+		{`{{ range sortObjectsByKeys . "Labels.com.example.priority" }}{{ .Name }} {{ end }}`, containers, `none nine nine-bis ten word `},
+		{`{{ range reverse (sortObjectsByKeys . "Labels.com.example.priority") }}{{ .Name }} {{ end }}`, containers, `word ten nine-bis nine none `},
+		{`{{ range sortObjectsByKeysAsc . "Labels.com.example.priority" }}{{ .Name }} {{ end }}`, containers, `none ten nine nine-bis word `},
+	}
+
+	tests.run(t)
+}
+
+func TestSortObjectsByStartTime(t *testing.T) {
+	old := &context.RuntimeContainer{Name: "old", StartedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	recent := &context.RuntimeContainer{Name: "recent", StartedAt: time.Date(2024, 1, 2, 3, 4, 5, 500, time.UTC)}
+	never := &context.RuntimeContainer{Name: "never"}
+	containers := []*context.RuntimeContainer{recent, never, old}
+
+	tests := templateTestList{
+		{`{{ range sortObjectsByKeysDesc . "StartedAt" }}{{ .Name }} {{ end }}`, containers, `recent old never `},
+		{`{{ range sortByKeys . (list "StartedAt") }}{{ .Name }} {{ end }}`, containers, `never old recent `},
+		{`{{ range sortObjectsByKeys . "StartedAt" }}{{ .Name }} {{ end }}`, containers, `never old recent `},
+		{`{{ range . }}{{ .StartedAt.IsZero }} {{ end }}`, containers, `false true false `},
+		{`{{ range . }}{{ .StartedAt | date "2006-01-02" }} {{ end }}`, containers, `2024-01-02 0001-01-01 2024-01-02 `},
+	}
+
+	tests.run(t)
+}
@@ -3,23 +3,29 @@ package template
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"text/template"
+	"time"
 	"unicode"
 
 	sprig "github.com/Masterminds/sprig/v3"
 	"github.com/nginx-proxy/docker-gen/internal/config"
 	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/nginx-proxy/docker-gen/internal/logging"
 	"github.com/nginx-proxy/docker-gen/internal/utils"
 )
 
@@ -59,44 +65,104 @@ func newTemplate(name string) *template.Template {
 		return buf.String(), nil
 	}
 	tmpl.Funcs(sprig.TxtFuncMap()).Funcs(template.FuncMap{
-		"closest":                arrayClosest,
-		"coalesce":               coalesce,
-		"contains":               contains,
-		"dir":                    dirList,
-		"eval":                   eval,
-		"exists":                 utils.PathExists,
-		"groupBy":                groupBy,
-		"groupByKeys":            groupByKeys,
-		"groupByMulti":           groupByMulti,
-		"groupByLabel":           groupByLabel,
-		"json":                   marshalJson,
-		"intersect":              intersect,
-		"keys":                   keys,
-		"replace":                strings.Replace,
-		"parseBool":              strconv.ParseBool,
-		"parseJson":              unmarshalJson,
-		"queryEscape":            url.QueryEscape,
-		"sha1":                   hashSha1,
-		"split":                  strings.Split,
-		"splitN":                 strings.SplitN,
-		"sortStringsAsc":         sortStringsAsc,
-		"sortStringsDesc":        sortStringsDesc,
-		"sortObjectsByKeysAsc":   sortObjectsByKeysAsc,
-		"sortObjectsByKeysDesc":  sortObjectsByKeysDesc,
-		"trimPrefix":             trimPrefix,
-		"trimSuffix":             trimSuffix,
-		"toLower":                toLower,
-		"toUpper":                toUpper,
-		"when":                   when,
-		"where":                  where,
-		"whereNot":               whereNot,
-		"whereExist":             whereExist,
-		"whereNotExist":          whereNotExist,
-		"whereAny":               whereAny,
-		"whereAll":               whereAll,
-		"whereLabelExists":       whereLabelExists,
-		"whereLabelDoesNotExist": whereLabelDoesNotExist,
-		"whereLabelValueMatches": whereLabelValueMatches,
+		"base64Decode":            base64Decode,
+		"base64Encode":            base64Encode,
+		"base64UrlDecode":         base64UrlDecode,
+		"base64UrlEncode":         base64UrlEncode,
+		"caddyRoutes":             caddyRoutes,
+		"closest":                 arrayClosest,
+		"closestDomain":           closestDomain,
+		"coalesce":                coalesce,
+		"config":                  configData(config.Config{}),
+		"contains":                contains,
+		"defaultBackend":          defaultBackend,
+		"dictMerge":               dictMerge,
+		"dictSet":                 dictSet,
+		"dictUnset":               dictUnset,
+		"difference":              differenceValues,
+		"dir":                     dirList,
+		"env":                     os.Getenv,
+		"envOr":                   envOr,
+		"eval":                    eval,
+		"exists":                  utils.PathExists,
+		"firstPublished":          firstPublished,
+		"fromEnvList":             fromEnvList,
+		"groupBy":                 groupBy,
+		"groupByKeys":             groupByKeys,
+		"groupByLabel":            groupByLabel,
+		"groupByLabelWithDefault": groupByLabelWithDefault,
+		"groupByMulti":            groupByMulti,
+		"hasIPv6":                 hasIPv6,
+		"hasKey":                  hasKey,
+		"hasPrefix":               hasPrefix,
+		"hasSuffix":               hasSuffix,
+		"healthCheck":             healthCheck,
+		"healthy":                 healthy,
+		"htpasswd":                htpasswd,
+		"humanSize":               humanSize,
+		"intersect":               intersectValues,
+		"intersection":            intersection,
+		"joinStrings":             joinStrings,
+		"json":                    marshalJson,
+		"keys":                    keys,
+		"labelMap":                labelMap,
+		"labelTree":               labelTree,
+		"mapValue":                mapValue,
+		"mask":                    mask,
+		"md5":                     hashMd5,
+		"parseBool":               strconv.ParseBool,
+		"parseJson":               unmarshalJson,
+		"parseJsonArray":          unmarshalJsonArray,
+		"parseSize":               parseSize,
+		"pickByCount":             pickByCount,
+		"pickByHash":              pickByHash,
+		"portRanges":              portRanges,
+		"primaryIP":               primaryIP,
+		"publishedAddresses":      publishedAddresses,
+		"queryEscape":             url.QueryEscape,
+		"redact":                  redact,
+		"regexReplace":            regexReplace,
+		"replace":                 strings.Replace,
+		"replaceAll":              strings.ReplaceAll,
+		"serverNames":             serverNames,
+		"services":                configServices(config.Config{}),
+		"sha1":                    hashSha1,
+		"sha1sum":                 hashSha1,
+		"sha256":                  hashSha256,
+		"shuffleSeeded":           shuffleSeeded,
+		"sortByDependency":        sortByDependency,
+		"sortByKeys":              sortByKeys,
+		"sortObjectsByKeys":       sortObjectsByKeys,
+		"sortObjectsByKeysAsc":    sortObjectsByKeysAsc,
+		"sortObjectsByKeysDesc":   sortObjectsByKeysDesc,
+		"sortStringsAsc":          sortStringsAsc,
+		"sortStringsDesc":         sortStringsDesc,
+		"split":                   strings.Split,
+		"splitN":                  strings.SplitN,
+		"toEnvList":               toEnvList,
+		"toLower":                 toLower,
+		"toTitle":                 toTitle,
+		"toUpper":                 toUpper,
+		"toYaml":                  marshalYaml,
+		"trimPrefix":              trimPrefix,
+		"trimStrings":             trimStrings,
+		"trimSuffix":              trimSuffix,
+		"vhostGroups":             vhostGroups,
+		"when":                    when,
+		"where":                   where,
+		"whereAll":                whereAll,
+		"whereAllOf":              whereAllOf,
+		"whereAny":                whereAny,
+		"whereAnyOf":              whereAnyOf,
+		"whereExist":              whereExist,
+		"whereLabelDoesNotExist":  whereLabelDoesNotExist,
+		"whereLabelExists":        whereLabelExists,
+		"whereLabelMatches":       whereLabelMatches,
+		"whereLabelValueMatches":  whereLabelValueMatches,
+		"whereNetworkExists":      whereNetworkExists,
+		"whereNot":                whereNot,
+		"whereNotExist":           whereNotExist,
+		"wherePort":               wherePort,
 	})
 	return tmpl
 }
@@ -129,109 +195,513 @@ func removeBlankLines(reader io.Reader, writer io.Writer) {
 	bwriter.Flush()
 }
 
-func filterRunning(config config.Config, containers context.Context) context.Context {
-	if config.IncludeStopped {
-		return containers
-	} else {
-		filteredContainers := context.Context{}
-		for _, container := range containers {
-			if container.State.Running {
-				filteredContainers = append(filteredContainers, container)
-			}
-		}
-		return filteredContainers
+// normalizeJSON re-encodes JSON contents canonically, with sorted object keys
+// and a fixed indentation, so that semantically identical outputs are written
+// identically. Contents which are not valid JSON are returned unchanged, and
+// ok is false.
+func normalizeJSON(contents []byte) (normalized []byte, ok bool) {
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return contents, false
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		// trailing data after the JSON value
+		return contents, false
+	}
+
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return contents, false
 	}
+	return buf.Bytes(), true
 }
 
-func GenerateFile(config config.Config, containers context.Context) bool {
-	filteredRunningContainers := filterRunning(config, containers)
+// filterContainers returns the containers included in the template of the
+// config. When config.LogExcluded is set, every excluded container is logged
+// along with the reason of its exclusion.
+func filterContainers(config config.Config, containers context.Context) context.Context {
 	filteredContainers := context.Context{}
-	if config.OnlyPublished {
-		for _, container := range filteredRunningContainers {
-			if len(container.PublishedAddresses()) > 0 {
-				filteredContainers = append(filteredContainers, container)
+	for _, container := range containers {
+		if reason := exclusionReason(config, container); reason != "" {
+			if config.LogExcluded {
+				logging.Infof("Excluded container %s from '%s': %s", describeContainer(container), config.Template, reason)
 			}
+			continue
 		}
-	} else if config.OnlyExposed {
-		for _, container := range filteredRunningContainers {
-			if len(container.Addresses) > 0 {
-				filteredContainers = append(filteredContainers, container)
-			}
-		}
-	} else {
-		filteredContainers = filteredRunningContainers
+		filteredContainers = append(filteredContainers, container)
+	}
+	return filteredContainers
+}
+
+// exclusionReason returns why the config excludes the container, naming every
+// filter it fails, or an empty string if the container is included
+func exclusionReason(config config.Config, container *context.RuntimeContainer) string {
+	reasons := []string{}
+	if !config.IncludeStopped && !container.State.Running {
+		reasons = append(reasons, "not running")
+	}
+	if config.OnlyExposed && len(container.Addresses) == 0 {
+		reasons = append(reasons, "no exposed ports")
+	}
+	if config.OnlyPublished && len(container.PublishedAddresses()) == 0 {
+		reasons = append(reasons, "no published ports")
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// describeContainer identifies a container in logs as "name (short ID)"
+func describeContainer(container *context.RuntimeContainer) string {
+	id := container.ID
+	if len(id) > 12 {
+		id = id[:12]
 	}
+	return fmt.Sprintf("%s (%s)", container.Name, id)
+}
+
+// stdout is where the output of configs without destination, and of dry runs,
+// is written
+var stdout io.Writer = os.Stdout
+
+// ErrRender is wrapped by the errors of the templates that could not be
+// parsed or executed, nothing being written then
+var ErrRender = errors.New("unable to render template")
 
-	contents := executeTemplate(config.Template, filteredContainers)
+// renderFile renders the template of config with the containers it includes,
+// and returns the output along with the number of these containers
+func renderFile(config config.Config, containers context.Context) ([]byte, int, error) {
+	filteredContainers := filterContainers(config, containers)
 
-	if !config.KeepBlankLines {
+	contents, err := executeTemplate(config, filteredContainers)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w %s: %w", ErrRender, config.Template, err)
+	}
+
+	normalized := false
+	if config.NormalizeJSON {
+		contents, normalized = normalizeJSON(contents)
+	}
+	// an output that is not JSON falls back to the blank lines removal
+	if !normalized && !config.KeepBlankLines {
 		buf := new(bytes.Buffer)
 		removeBlankLines(bytes.NewReader(contents), buf)
 		contents = buf.Bytes()
 	}
+	return contents, len(filteredContainers), nil
+}
+
+// PrintFile renders config like GenerateFile, but writes the output to stdout
+// after a header naming the template and its destinations, leaving the
+// destinations untouched. It returns the error of a template that could not
+// be rendered.
+func PrintFile(config config.Config, containers context.Context) error {
+	contents, _, err := renderFile(config, containers)
+	if err != nil {
+		return err
+	}
 
-	if config.Dest != "" {
-		dest, err := os.CreateTemp(filepath.Dir(config.Dest), "docker-gen")
-		defer func() {
-			dest.Close()
-			os.Remove(dest.Name())
-		}()
+	dests := config.Destinations()
+	if len(dests) == 0 {
+		dests = []string{"stdout"}
+	}
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "==> %s -> %s <==\n", config.Template, strings.Join(dests, ", "))
+	buf.Write(contents)
+	if len(contents) > 0 && contents[len(contents)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	stdout.Write(buf.Bytes())
+	return nil
+}
+
+func GenerateFile(config config.Config, containers context.Context) bool {
+	changed, _, _ := GenerateFileCount(config, containers)
+	return changed
+}
+
+// GenerateFileCount is GenerateFile, also returning the number of containers
+// included in the template, and the errors of the destinations that could not
+// be written. These are logged and skipped, the other destinations being
+// written anyway. A template that cannot be rendered writes nothing, and
+// returns an error wrapping ErrRender.
+func GenerateFileCount(config config.Config, containers context.Context) (bool, int, error) {
+	contents, count, err := renderFile(config, containers)
+	if err != nil {
+		logging.WithFields(logging.Fields{"dest": config.Dest}).Errorf("%s", err)
+		return false, 0, err
+	}
+
+	dests := config.Destinations()
+	if len(dests) == 0 {
+		stdout.Write(contents)
+		return true, count, nil
+	}
+
+	changed := false
+	var errs []error
+	for _, dest := range dests {
+		if err := ensureDestDir(config, dest); err != nil {
+			if reportDestDir(dest, err) {
+				logging.WithFields(logging.Fields{"dest": dest}).Errorf("Unable to write %s, skipping it: %s", dest, err)
+			}
+			errs = append(errs, fmt.Errorf("unable to write %s: %w", dest, err))
+			continue
+		}
+		reportDestDir(dest, nil)
+		written, err := writeFile(config, dest, contents)
 		if err != nil {
-			log.Fatalf("Unable to create temp file: %s\n", err)
+			logging.WithFields(logging.Fields{"dest": dest}).Errorf("Unable to write %s, skipping it: %s", dest, err)
+			errs = append(errs, fmt.Errorf("unable to write %s: %w", dest, err))
+			continue
 		}
+		if written {
+			logging.WithFields(logging.Fields{"dest": dest}).Infof("Generated '%s' from %d containers", dest, count)
+			changed = true
+		}
+	}
+	return changed, count, errors.Join(errs...)
+}
 
-		if n, err := dest.Write(contents); n != len(contents) || err != nil {
-			log.Fatalf("Failed to write to temp file: wrote %d, exp %d, err=%v", n, len(contents), err)
+// ensureDestDir creates the directory of the destination if the config asks
+// for it, and otherwise returns a clear error if the directory is missing
+func ensureDestDir(config config.Config, destPath string) error {
+	dir := filepath.Dir(destPath)
+	if config.CreateDestDir {
+		perm, err := config.DestDirPerm()
+		if err != nil {
+			return fmt.Errorf("unable to create destination directory %s: %w", dir, err)
+		}
+		if err := os.MkdirAll(dir, perm); err != nil {
+			return fmt.Errorf("unable to create destination directory %s: %w", dir, err)
 		}
+		return nil
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return fmt.Errorf("destination directory %s does not exist, create it or set createdestdir = true", dir)
+	}
+	return nil
+}
 
-		oldContents := []byte{}
-		if fi, err := os.Stat(config.Dest); err == nil || os.IsNotExist(err) {
-			if err != nil && os.IsNotExist(err) {
-				emptyFile, err := os.Create(config.Dest)
-				if err != nil {
-					log.Fatalf("Unable to create empty destination file: %s\n", err)
-				} else {
-					emptyFile.Close()
-					fi, _ = os.Stat(config.Dest)
-				}
-			}
-			if err := dest.Chmod(fi.Mode()); err != nil {
-				log.Fatalf("Unable to chmod temp file: %s\n", err)
-			}
-			if err := dest.Chown(int(fi.Sys().(*syscall.Stat_t).Uid), int(fi.Sys().(*syscall.Stat_t).Gid)); err != nil {
-				log.Fatalf("Unable to chown temp file: %s\n", err)
-			}
-			oldContents, err = os.ReadFile(config.Dest)
+// destDirErrors holds the destinations whose directory error was logged, so
+// that it is logged once rather than on every generation, until the
+// directory is fixed
+var (
+	destDirErrorsMu sync.Mutex
+	destDirErrors   = make(map[string]bool)
+)
+
+// reportDestDir records the directory error of dest, nil once fixed, and
+// returns whether it must be logged: the first time it fails
+func reportDestDir(dest string, err error) bool {
+	destDirErrorsMu.Lock()
+	defer destDirErrorsMu.Unlock()
+	if err == nil {
+		delete(destDirErrors, dest)
+		return false
+	}
+	if destDirErrors[dest] {
+		return false
+	}
+	destDirErrors[dest] = true
+	return true
+}
+
+// writeFile atomically replaces the destination file with contents, through
+// a temp file renamed over it, and returns whether its contents changed. The
+// file keeps the mode and ownership of the destination, unless the config
+// sets them, in which case they are also applied to an unchanged destination.
+// When the config compresses its files, contents are gzipped, and compared
+// uncompressed to the destination. On error, the temp file is removed and the
+// destination is left as is.
+func writeFile(config config.Config, destPath string, contents []byte) (changed bool, err error) {
+	perm, setPerm, err := config.FilePerm()
+	if err != nil {
+		return false, err
+	}
+
+	dir := filepath.Dir(destPath)
+	dest, err := os.CreateTemp(dir, "docker-gen")
+	if err != nil {
+		return false, fmt.Errorf("unable to create temp file in %s to replace %s: %w", dir, destPath, err)
+	}
+	defer func() {
+		dest.Close()
+		os.Remove(dest.Name())
+	}()
+
+	data := contents
+	if config.Compress == "gzip" {
+		data = gzipContents(contents)
+	}
+	if n, err := dest.Write(data); n != len(data) || err != nil {
+		return false, fmt.Errorf("unable to write temp file %s: wrote %d, exp %d, err=%v", dest.Name(), n, len(data), err)
+	}
+
+	fi, err := os.Stat(destPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("unable to stat %s: %w", destPath, err)
+	}
+	// a destination created empty below has nothing worth a backup
+	existed := err == nil
+	if !existed {
+		emptyFile, createErr := os.Create(destPath)
+		if createErr != nil {
+			return false, fmt.Errorf("unable to create empty destination file: %w", createErr)
+		}
+		emptyFile.Close()
+		// the empty destination is not left behind on error
+		defer func() {
 			if err != nil {
-				log.Fatalf("Unable to compare current file contents: %s: %s\n", config.Dest, err)
+				os.Remove(destPath)
 			}
+		}()
+		if fi, err = os.Stat(destPath); err != nil {
+			return false, fmt.Errorf("unable to stat %s: %w", destPath, err)
+		}
+	}
+
+	if err := dest.Chmod(fi.Mode()); err != nil {
+		return false, fmt.Errorf("unable to chmod temp file: %w", err)
+	}
+	stat := fi.Sys().(*syscall.Stat_t)
+	if err := dest.Chown(int(stat.Uid), int(stat.Gid)); err != nil {
+		return false, fmt.Errorf("unable to chown temp file: %w", err)
+	}
+	oldContents, err := os.ReadFile(destPath)
+	if err != nil {
+		return false, fmt.Errorf("unable to compare current file contents: %s: %w", destPath, err)
+	}
+	// a destination that is not gzip yet is replaced even if empty
+	replace := false
+	if config.Compress == "gzip" {
+		var ok bool
+		oldContents, ok = gunzipContents(oldContents)
+		replace = !ok
+	}
+
+	if setPerm {
+		if err := dest.Chmod(perm); err != nil {
+			return false, fmt.Errorf("unable to chmod temp file: %w", err)
+		}
+	}
+	uid, gid := ownership(config)
+	if uid != -1 || gid != -1 {
+		if err := dest.Chown(uid, gid); err != nil {
+			return false, fmt.Errorf("unable to chown temp file: %w", err)
 		}
+	}
 
-		if !bytes.Equal(oldContents, contents) {
-			err = os.Rename(dest.Name(), config.Dest)
-			if err != nil {
-				log.Fatalf("Unable to create dest file %s: %s\n", config.Dest, err)
-			}
-			log.Printf("Generated '%s' from %d containers", config.Dest, len(filteredContainers))
-			return true
+	if !replace && bytes.Equal(oldContents, contents) {
+		return false, updateModeAndOwner(destPath, fi, perm, setPerm, uid, gid)
+	}
+	if config.ValidateCmd != "" && !validateFile(config, dest.Name(), destPath) {
+		// a new destination is not left empty when its first render is rejected
+		if !existed {
+			os.Remove(destPath)
+		}
+		return false, nil
+	}
+	if config.BackupCount > 0 && existed {
+		backupFile(destPath, config.BackupCount)
+	}
+	if err = os.Rename(dest.Name(), destPath); err != nil {
+		return false, fmt.Errorf("unable to create dest file %s: %w", destPath, err)
+	}
+	return true, nil
+}
+
+// ownership returns the uid and gid set by the config, -1 for those it does
+// not set
+func ownership(config config.Config) (int, int) {
+	uid, gid := -1, -1
+	if config.Uid != nil {
+		uid = *config.Uid
+	}
+	if config.Gid != nil {
+		gid = *config.Gid
+	}
+	return uid, gid
+}
+
+// updateModeAndOwner applies the mode, uid and gid set by the config to an
+// unchanged destination, of mode and ownership fi, if they differ
+func updateModeAndOwner(destPath string, fi os.FileInfo, perm os.FileMode, setPerm bool, uid, gid int) error {
+	if setPerm && fi.Mode().Perm() != perm {
+		if err := os.Chmod(destPath, perm); err != nil {
+			return fmt.Errorf("unable to chmod %s: %w", destPath, err)
+		}
+		logging.WithFields(logging.Fields{"dest": destPath}).Infof("Changed the mode of '%s' to %04o", destPath, perm)
+	}
+	stat := fi.Sys().(*syscall.Stat_t)
+	if (uid != -1 && uint32(uid) != stat.Uid) || (gid != -1 && uint32(gid) != stat.Gid) {
+		if err := os.Chown(destPath, uid, gid); err != nil {
+			return fmt.Errorf("unable to chown %s: %w", destPath, err)
+		}
+		logging.WithFields(logging.Fields{"dest": destPath}).Infof("Changed the owner of '%s' to %d:%d", destPath, uid, gid)
+	}
+	return nil
+}
+
+// backupTimeFormat timestamps the backups of a destination, sorting them from
+// the oldest to the newest
+const backupTimeFormat = "20060102T150405.000000000"
+
+// backupFile copies destPath, about to be replaced, to destPath.<timestamp>.bak
+// and removes the oldest backups beyond count. A failed backup is logged and
+// does not prevent the replacement.
+func backupFile(destPath string, count int) {
+	contents, err := os.ReadFile(destPath)
+	if err != nil {
+		logging.Errorf("Unable to back up %s: %s\n", destPath, err)
+		return
+	}
+	fi, err := os.Stat(destPath)
+	if err != nil {
+		logging.Errorf("Unable to back up %s: %s\n", destPath, err)
+		return
+	}
+	backupPath := fmt.Sprintf("%s.%s.bak", destPath, time.Now().UTC().Format(backupTimeFormat))
+	if err := os.WriteFile(backupPath, contents, fi.Mode().Perm()); err != nil {
+		logging.Errorf("Unable to back up %s: %s\n", destPath, err)
+		return
+	}
+
+	backups, err := listBackups(destPath)
+	if err != nil {
+		logging.Errorf("Unable to list the backups of %s: %s\n", destPath, err)
+		return
+	}
+	for len(backups) > count {
+		if err := os.Remove(backups[0]); err != nil {
+			logging.Errorf("Unable to remove backup %s: %s\n", backups[0], err)
 		}
+		backups = backups[1:]
+	}
+}
+
+// listBackups returns the backups of destPath made by backupFile, from the
+// oldest to the newest. Other files of the directory are never listed.
+func listBackups(destPath string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(destPath))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(destPath) + "."
+	backups := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".bak")
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(destPath), name))
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// gzipContents returns contents compressed with gzip
+func gzipContents(contents []byte) []byte {
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	writer.Write(contents)
+	writer.Close()
+	return buf.Bytes()
+}
+
+// gunzipContents returns the uncompressed gzip contents, and false if they
+// are not valid gzip, like an empty or uncompressed destination
+func gunzipContents(contents []byte) ([]byte, bool) {
+	reader, err := gzip.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		return nil, false
+	}
+	uncompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, false
+	}
+	return uncompressed, true
+}
+
+// validateFile runs the validate command of the config against tempPath, the
+// new contents of destPath, and returns whether it succeeded. On failure, the
+// output of the command is logged and destPath is left untouched.
+func validateFile(config config.Config, tempPath, destPath string) bool {
+	validateCmd, err := config.ValidateCommand(tempPath)
+	if err != nil {
+		logging.Errorf("Error validating %s: %s", destPath, err)
 		return false
-	} else {
-		os.Stdout.Write(contents)
 	}
-	return true
+	out, err := exec.Command("/bin/sh", "-c", validateCmd).CombinedOutput()
+	if err == nil {
+		return true
+	}
+	logging.Errorf("Error validating %s: '%s' failed: %s, keeping the current file", destPath, validateCmd, err)
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			logging.Infof("[%s]: %s", validateCmd, line)
+		}
+	}
+	return false
 }
 
-func executeTemplate(templatePath string, containers context.Context) []byte {
-	tmpl, err := newTemplate(filepath.Base(templatePath)).ParseFiles(templatePath)
+// parseTemplate parses the template of the config, read from a file, fetched
+// from an http(s) URL or read from stdin, with the delimiters of the config
+func parseTemplate(config config.Config) (*template.Template, error) {
+	if !isRemote(config.Template) && !isStdin(config.Template) {
+		return newTemplate(filepath.Base(config.Template)).Delims(config.LeftDelim, config.RightDelim).ParseFiles(config.Template)
+	}
+	contents, err := remoteTemplate(config)
 	if err != nil {
-		log.Fatalf("Unable to parse template: %s", err)
+		return nil, err
 	}
+	return newTemplate(templateName(config.Template)).Delims(config.LeftDelim, config.RightDelim).Parse(string(contents))
+}
+
+// configData returns the config function of the templates of cfg, returning
+// its per-config template data
+func configData(cfg config.Config) func() map[string]interface{} {
+	return func() map[string]interface{} {
+		return cfg.Data
+	}
+}
+
+// configServices returns the services function of the templates of cfg,
+// returning the swarm services of its endpoint when it enables them
+func configServices(cfg config.Config) func() []*context.RuntimeService {
+	return func() []*context.RuntimeService {
+		if !cfg.Services {
+			return nil
+		}
+		return context.Services(cfg.Endpoint)
+	}
+}
+
+// executeTemplate executes the template of the config with the containers,
+// and returns its output
+func executeTemplate(config config.Config, containers context.Context) ([]byte, error) {
+	tmpl, err := parseTemplate(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template: %w", err)
+	}
+
+	// the data and the services of the config are bound to this execution
+	tmpl.Funcs(template.FuncMap{
+		"config":   configData(config),
+		"services": configServices(config),
+	})
 
 	buf := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(buf, filepath.Base(templatePath), &containers)
+	err = tmpl.ExecuteTemplate(buf, templateName(config.Template), &containers)
 	if err != nil {
-		log.Fatalf("Template error: %s\n", err)
+		return nil, fmt.Errorf("template error: %w", err)
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
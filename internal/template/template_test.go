@@ -2,12 +2,21 @@ package template
 
 import (
 	"bytes"
+	"compress/gzip"
 	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 
+	"github.com/nginx-proxy/docker-gen/internal/config"
+	"github.com/nginx-proxy/docker-gen/internal/context"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -152,6 +161,19 @@ func TestSprig(t *testing.T) {
 			{`{{ if eq (last $) "b"}}ok{{ end }}`, []string{"a", "b"}, `ok`},
 			{`{{ if eq (last $) "b"}}ok{{ end }}`, [2]string{"a", "b"}, `ok`},
 		}},
+		{"b64enc", templateTestList{
+			{`{{ b64enc "user:password" }}`, nil, `dXNlcjpwYXNzd29yZA==`},
+		}},
+		{"b64dec", templateTestList{
+			{`{{ b64dec "dXNlcjpwYXNzd29yZA==" }}`, nil, `user:password`},
+		}},
+		{"sha1sum", templateTestList{
+			{`{{ sha1sum "/path" }}`, nil, `4f26609ad3f5185faaa9edf1e93aa131e2131352`},
+			{`{{ if eq (sha1sum "/path") (sha1 "/path") }}ok{{ end }}`, nil, `ok`},
+		}},
+		{"sha256sum", templateTestList{
+			{`{{ sha256sum "/path" }}`, nil, `379c9f23425a38698d164abeb339116b9295b8fa7ea8747a92d74fd7885beef0`},
+		}},
 		{"trim", templateTestList{
 			{`{{ if eq (trim "  myhost.local  ") "myhost.local" }}ok{{ end }}`, nil, `ok`},
 		}},
@@ -193,3 +215,534 @@ func TestEval(t *testing.T) {
 		})
 	}
 }
+
+// executeString executes the template of cfg, failing the test on error
+func executeString(t *testing.T, cfg config.Config, containers context.Context) string {
+	t.Helper()
+	contents, err := executeTemplate(cfg, containers)
+	assert.NoError(t, err)
+	return string(contents)
+}
+
+func TestExecuteTemplateConfigData(t *testing.T) {
+	tmplPath := filepath.Join(t.TempDir(), "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ (config).prefix }}-{{ len $ }}`), 0644)
+	assert.NoError(t, err)
+
+	cfg := config.Config{
+		Template: tmplPath,
+		Data:     map[string]interface{}{"prefix": "upstream"},
+	}
+	containers := context.Context{&context.RuntimeContainer{ID: "1"}}
+	assert.Equal(t, "upstream-1", executeString(t, cfg, containers))
+
+	cfg.Data = nil
+	assert.Equal(t, "<no value>-1", executeString(t, cfg, containers))
+
+	// the configs rendered concurrently with the same containers keep their data
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(prefix string) {
+			defer wg.Done()
+			cfg := config.Config{Template: tmplPath, Data: map[string]interface{}{"prefix": prefix}}
+			assert.Equal(t, prefix+"-1", executeString(t, cfg, containers))
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+}
+
+func TestDockerAPIVersion(t *testing.T) {
+	docker := map[string]interface{}{"Docker": context.Docker{Version: "24.0.5", ApiVersion: "1.43"}}
+	tests := templateTestList{
+		{`{{ .Docker.APIVersion }}`, docker, `1.43`},
+		{`{{ .Docker.ApiVersion }}`, docker, `1.43`},
+		{`{{ if semverCompare ">=1.41" .Docker.APIVersion }}swarm{{ end }}`, docker, `swarm`},
+	}
+
+	tests.run(t)
+}
+
+func TestExecuteTemplateServices(t *testing.T) {
+	tmplPath := filepath.Join(t.TempDir(), "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ range services }}{{ .Name }}:{{ range .PublishedPorts }}{{ .PublishedPort }}{{ end }};{{ end }}`), 0644)
+	assert.NoError(t, err)
+
+	context.SetServices("", []*context.RuntimeService{
+		{Name: "web", Ports: []context.ServicePort{{Proto: "tcp", TargetPort: 80, PublishedPort: 8080}}},
+	})
+	defer context.SetServices("", nil)
+
+	containers := context.Context{}
+	cfg := config.Config{Template: tmplPath, Services: true}
+	assert.Equal(t, "web:8080;", executeString(t, cfg, containers))
+
+	cfg.Services = false
+	assert.Equal(t, "", executeString(t, cfg, containers), "services are only rendered when enabled")
+}
+
+func TestGenerateFileTemplateErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	dir := t.TempDir()
+	destPath := filepath.Join(dir, "out")
+	assert.NoError(t, os.WriteFile(destPath, []byte("previous"), 0644))
+
+	for _, contents := range []string{`{{ .Name `, `{{ index . 1 }}`} {
+		tmplPath := filepath.Join(dir, "test.tmpl")
+		assert.NoError(t, os.WriteFile(tmplPath, []byte(contents), 0644))
+		cfg := config.Config{Template: tmplPath, Dest: destPath}
+		changed, _, err := GenerateFileCount(cfg, context.Context{})
+		assert.False(t, changed, contents)
+		assert.ErrorIs(t, err, ErrRender, contents)
+		assert.ErrorContains(t, PrintFile(cfg, context.Context{}), tmplPath, contents)
+	}
+
+	out, err := os.ReadFile(destPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "previous", string(out), "nothing is written")
+}
+
+func TestGenerateFileMultipleDestinations(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ range . }}{{ .ID }}{{ end }}`), 0644)
+	assert.NoError(t, err)
+
+	cfg := config.Config{
+		Template: tmplPath,
+		Dest:     filepath.Join(dir, "first"),
+		Dests:    []string{filepath.Join(dir, "second")},
+	}
+	containers := context.Context{&context.RuntimeContainer{ID: "1", State: context.State{Running: true}}}
+
+	assert.True(t, GenerateFile(cfg, containers))
+	for _, dest := range cfg.Destinations() {
+		contents, err := os.ReadFile(dest)
+		assert.NoError(t, err)
+		assert.Equal(t, "1", string(contents))
+	}
+	assert.False(t, GenerateFile(cfg, containers))
+
+	// a single outdated destination is enough to report a change
+	assert.NoError(t, os.WriteFile(cfg.Dests[0], []byte("outdated"), 0644))
+	assert.True(t, GenerateFile(cfg, containers))
+	contents, err := os.ReadFile(cfg.Dests[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(contents))
+}
+
+func TestPrintFile(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ range . }}{{ .ID }}{{ end }}`), 0644)
+	assert.NoError(t, err)
+
+	out := new(bytes.Buffer)
+	stdout = out
+	defer func() { stdout = os.Stdout }()
+
+	cfg := config.Config{
+		Template: tmplPath,
+		Dest:     filepath.Join(dir, "first"),
+		Dests:    []string{filepath.Join(dir, "second")},
+	}
+	containers := context.Context{&context.RuntimeContainer{ID: "1", State: context.State{Running: true}}}
+
+	PrintFile(cfg, containers)
+	assert.Equal(t, "==> "+tmplPath+" -> "+cfg.Dest+", "+cfg.Dests[0]+" <==\n1\n", out.String())
+	for _, dest := range cfg.Destinations() {
+		_, err := os.Stat(dest)
+		assert.True(t, os.IsNotExist(err), "destinations are not written")
+	}
+
+	out.Reset()
+	PrintFile(config.Config{Template: tmplPath}, containers)
+	assert.Equal(t, "==> "+tmplPath+" -> stdout <==\n1\n", out.String())
+}
+
+func TestFilterContainers(t *testing.T) {
+	running := &context.RuntimeContainer{Name: "running", ID: "0123456789abcdef", State: context.State{Running: true}}
+	stopped := &context.RuntimeContainer{Name: "stopped", ID: "2", State: context.State{Running: false}}
+	exposed := &context.RuntimeContainer{Name: "exposed", ID: "3", State: context.State{Running: true},
+		Addresses: []context.Address{{Port: "80"}}}
+	published := &context.RuntimeContainer{Name: "published", ID: "4", State: context.State{Running: true},
+		Addresses: []context.Address{{Port: "80", HostPort: "8080"}}}
+	containers := context.Context{running, stopped, exposed, published}
+
+	assert.Equal(t, context.Context{running, exposed, published}, filterContainers(config.Config{}, containers))
+	assert.Equal(t, containers, filterContainers(config.Config{IncludeStopped: true}, containers))
+	assert.Equal(t, context.Context{exposed, published}, filterContainers(config.Config{OnlyExposed: true}, containers))
+	assert.Equal(t, context.Context{published}, filterContainers(config.Config{OnlyPublished: true}, containers))
+	assert.Equal(t, context.Context{published}, filterContainers(config.Config{OnlyExposed: true, OnlyPublished: true}, containers))
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	filterContainers(config.Config{Template: "foo.tmpl", OnlyPublished: true}, containers)
+	assert.Empty(t, buf.String(), "exclusions are only logged when enabled")
+
+	filterContainers(config.Config{Template: "foo.tmpl", OnlyPublished: true, LogExcluded: true}, containers)
+	assert.Contains(t, buf.String(), "Excluded container running (0123456789ab) from 'foo.tmpl': no published ports")
+	assert.Contains(t, buf.String(), "Excluded container stopped (2) from 'foo.tmpl': not running, no published ports")
+	assert.Contains(t, buf.String(), "Excluded container exposed (3) from 'foo.tmpl': no published ports")
+	assert.NotContains(t, buf.String(), "published (4)")
+
+	buf.Reset()
+	filterContainers(config.Config{Template: "foo.tmpl", OnlyExposed: true, OnlyPublished: true, LogExcluded: true}, containers)
+	assert.Contains(t, buf.String(), "Excluded container stopped (2) from 'foo.tmpl': not running, no exposed ports, no published ports")
+	assert.Contains(t, buf.String(), "Excluded container exposed (3) from 'foo.tmpl': no published ports")
+}
+
+func TestGenerateFileCreateDestDir(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ len . }}`), 0644)
+	assert.NoError(t, err)
+
+	cfg := config.Config{
+		Template:      tmplPath,
+		Dest:          filepath.Join(dir, "conf.d", "sites", "default.conf"),
+		CreateDestDir: true,
+		DestDirMode:   "0700",
+	}
+	assert.True(t, GenerateFile(cfg, context.Context{}))
+
+	contents, err := os.ReadFile(cfg.Dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", string(contents))
+	fi, err := os.Stat(filepath.Dir(cfg.Dest))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+
+	// destinations of configs creating their directory are not checked
+	assert.Empty(t, CheckConfigs(config.ConfigFile{Config: []config.Config{
+		{Template: tmplPath, Dest: filepath.Join(dir, "missing", "default.conf"), CreateDestDir: true},
+	}}))
+}
+
+func TestGenerateFileMissingDestDir(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ len . }}`), 0644)
+	assert.NoError(t, err)
+
+	missing := filepath.Join(dir, "missing", "default.conf")
+	cfg := config.Config{Template: tmplPath, Dests: []string{missing, filepath.Join(dir, "default.conf")}}
+	for i := 0; i < 3; i++ {
+		changed, _, err := GenerateFileCount(cfg, context.Context{})
+		assert.Equal(t, i == 0, changed, "the other destinations are written")
+		assert.ErrorContains(t, err, "destination directory "+filepath.Dir(missing)+" does not exist")
+	}
+	assert.Equal(t, 1, strings.Count(buf.String(), "Unable to write "+missing), "the missing directory is logged once")
+
+	// logged again once fixed and missing again
+	assert.NoError(t, os.Mkdir(filepath.Dir(missing), 0755))
+	assert.True(t, GenerateFile(cfg, context.Context{}))
+	assert.NoError(t, os.RemoveAll(filepath.Dir(missing)))
+	GenerateFile(cfg, context.Context{})
+	assert.Equal(t, 2, strings.Count(buf.String(), "Unable to write "+missing))
+
+	cfg = config.Config{Template: tmplPath, Dest: filepath.Join(dir, "created", "default.conf"), CreateDestDir: true, DestDirMode: "foo"}
+	_, _, err = GenerateFileCount(cfg, context.Context{})
+	assert.ErrorContains(t, err, "unable to create destination directory")
+}
+
+func TestGenerateFileValidateCmd(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ len . }}`), 0644)
+	assert.NoError(t, err)
+
+	cfg := config.Config{
+		Template:    tmplPath,
+		Dest:        filepath.Join(dir, "default.conf"),
+		ValidateCmd: `grep -qx 0 {{ .Dest }} || { echo "invalid config"; exit 1; }`,
+	}
+	assert.True(t, GenerateFile(cfg, context.Context{}))
+
+	running := context.Context{{ID: "1", Name: "web", State: context.State{Running: true}}}
+	assert.False(t, GenerateFile(cfg, running), "the validation fails, nothing to notify")
+	contents, err := os.ReadFile(cfg.Dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", string(contents), "the current file is kept")
+	assert.Contains(t, buf.String(), "Error validating "+cfg.Dest)
+	assert.Contains(t, buf.String(), "invalid config")
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2, "the temp file is removed")
+
+	cfg.ValidateCmd = "true {{ .Missing }}"
+	assert.Len(t, CheckConfigs(config.ConfigFile{Config: []config.Config{cfg}}), 1)
+}
+
+func TestGenerateFileValidateCmdFirstRender(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ len . }}`), 0644)
+	assert.NoError(t, err)
+
+	cfg := config.Config{
+		Template:    tmplPath,
+		Dest:        filepath.Join(dir, "default.conf"),
+		ValidateCmd: "false",
+	}
+	assert.False(t, GenerateFile(cfg, context.Context{}), "the validation fails, nothing to notify")
+	_, err = os.Stat(cfg.Dest)
+	assert.True(t, os.IsNotExist(err), "no empty destination is left behind")
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "the temp file is removed")
+}
+
+func TestGenerateFileDelims(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ .Name }}: [[ range . ]][[ .Name ]][[ end ]]`), 0644)
+	assert.NoError(t, err)
+
+	cfg := config.Config{
+		Template:   tmplPath,
+		Dest:       filepath.Join(dir, "out.tmpl"),
+		LeftDelim:  "[[",
+		RightDelim: "]]",
+	}
+	assert.True(t, GenerateFile(cfg, context.Context{{Name: "web", State: context.State{Running: true}}}))
+	contents, err := os.ReadFile(cfg.Dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "{{ .Name }}: web", string(contents))
+
+	cfg.RightDelim = ""
+	assert.Len(t, CheckConfigs(config.ConfigFile{Config: []config.Config{cfg}}), 2, "half-configured delimiters")
+}
+
+func TestGenerateFileGzip(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ range . }}{{ .Name }}{{ end }}`), 0644)
+	assert.NoError(t, err)
+
+	cfg := config.Config{
+		Template: tmplPath,
+		Dest:     filepath.Join(dir, "out.gz"),
+		Compress: "gzip",
+	}
+	assert.True(t, GenerateFile(cfg, context.Context{}), "an empty output is still gzipped")
+	contents, err := os.ReadFile(cfg.Dest)
+	assert.NoError(t, err)
+	uncompressed, ok := gunzipContents(contents)
+	assert.True(t, ok)
+	assert.Empty(t, uncompressed)
+
+	web := context.Context{{Name: "web", State: context.State{Running: true}}}
+	assert.True(t, GenerateFile(cfg, web))
+	contents, err = os.ReadFile(cfg.Dest)
+	assert.NoError(t, err)
+	uncompressed, _ = gunzipContents(contents)
+	assert.Equal(t, "web", string(uncompressed))
+
+	// the destination is compared uncompressed
+	err = os.WriteFile(cfg.Dest, gzipLevel(t, "web", gzip.BestSpeed), 0644)
+	assert.NoError(t, err)
+	assert.False(t, GenerateFile(cfg, web))
+
+	cfg.Compress = "zip"
+	assert.Len(t, CheckConfigs(config.ConfigFile{Config: []config.Config{cfg}}), 1)
+}
+
+func TestGenerateFileBackupCount(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ range . }}{{ .Name }}{{ end }}`), 0644)
+	assert.NoError(t, err)
+	unrelated := filepath.Join(dir, "out.conf.old.bak")
+	err = os.WriteFile(unrelated, []byte("old"), 0644)
+	assert.NoError(t, err)
+
+	cfg := config.Config{
+		Template:    tmplPath,
+		Dest:        filepath.Join(dir, "out.conf"),
+		BackupCount: 2,
+	}
+	generate := func(name string) bool {
+		return GenerateFile(cfg, context.Context{{Name: name, State: context.State{Running: true}}})
+	}
+	backups := func() []string {
+		backups, err := listBackups(cfg.Dest)
+		assert.NoError(t, err)
+		contents := []string{}
+		for _, backup := range backups {
+			b, err := os.ReadFile(backup)
+			assert.NoError(t, err)
+			contents = append(contents, string(b))
+		}
+		return contents
+	}
+
+	assert.True(t, generate("a"))
+	assert.Empty(t, backups(), "a new destination has no backup")
+	assert.False(t, generate("a"))
+	assert.Empty(t, backups(), "an unchanged destination is not backed up")
+
+	assert.True(t, generate("b"))
+	assert.True(t, generate("c"))
+	assert.True(t, generate("d"))
+	assert.Equal(t, []string{"b", "c"}, backups(), "only the latest backups are kept")
+	assert.FileExists(t, unrelated)
+
+	cfg.BackupCount = -1
+	assert.Len(t, CheckConfigs(config.ConfigFile{Config: []config.Config{cfg}}), 1)
+}
+
+// gzipLevel compresses s with the given level, producing other bytes than
+// gzipContents for the same contents
+func gzipLevel(t *testing.T, s string, level int) []byte {
+	buf := new(bytes.Buffer)
+	writer, err := gzip.NewWriterLevel(buf, level)
+	assert.NoError(t, err)
+	writer.Write([]byte(s))
+	writer.Close()
+	return buf.Bytes()
+}
+
+func TestGenerateFileModeAndOwner(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ len . }}`), 0644)
+	assert.NoError(t, err)
+
+	// without filemode, the mode of the replaced destination is kept
+	dest := filepath.Join(dir, "default.conf")
+	assert.NoError(t, os.WriteFile(dest, []byte("old"), 0600))
+	cfg := config.Config{Template: tmplPath, Dest: dest}
+	assert.True(t, GenerateFile(cfg, context.Context{}))
+	fi, err := os.Stat(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	uid, gid := os.Getuid(), os.Getgid()
+	cfg.FileMode = "0640"
+	cfg.Uid, cfg.Gid = &uid, &gid
+	assert.True(t, GenerateFile(cfg, context.Context{{ID: "1", State: context.State{Running: true}}}))
+	fi, err = os.Stat(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+	assert.Equal(t, uint32(uid), fi.Sys().(*syscall.Stat_t).Uid)
+	assert.Equal(t, uint32(gid), fi.Sys().(*syscall.Stat_t).Gid)
+
+	// no temp file is left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	errs := CheckConfigs(config.ConfigFile{Config: []config.Config{{Template: tmplPath, Dest: dest, FileMode: "rw-r--r--"}}})
+	assert.Len(t, errs, 1)
+}
+
+func TestGenerateFileUnchangedModeAndOwner(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ len . }}`), 0644)
+	assert.NoError(t, err)
+
+	dest := filepath.Join(dir, "default.conf")
+	cfg := config.Config{Template: tmplPath, Dest: dest, FileMode: "0600"}
+	assert.True(t, GenerateFile(cfg, context.Context{}))
+
+	cfg.FileMode = "0640"
+	uid, gid := os.Getuid(), os.Getgid()
+	cfg.Uid, cfg.Gid = &uid, &gid
+	assert.False(t, GenerateFile(cfg, context.Context{}), "the contents did not change")
+	fi, err := os.Stat(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm(), "the new filemode is applied anyway")
+}
+
+func TestGenerateFileWriteErrors(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{{ len . }}`), 0644)
+	assert.NoError(t, err)
+	// a destination that is a directory cannot be read nor replaced
+	unreadable := filepath.Join(dir, "unreadable")
+	assert.NoError(t, os.MkdirAll(filepath.Join(unreadable, "child"), 0755))
+	// nor can a destination under a file
+	notDir := filepath.Join(tmplPath, "default.conf")
+	written := filepath.Join(dir, "default.conf")
+
+	cfg := config.Config{Template: tmplPath, Dests: []string{unreadable, notDir, written}}
+	assert.True(t, GenerateFile(cfg, context.Context{}), "the failing destinations are skipped")
+	contents, err := os.ReadFile(written)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", string(contents))
+	assert.Contains(t, buf.String(), "Unable to write "+unreadable)
+	assert.Contains(t, buf.String(), "Unable to write "+notDir)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3, "no temp file is left behind")
+}
+
+func TestNormalizeJSON(t *testing.T) {
+	expected := "{\n  \"a\": [\n    1,\n    2.50\n  ],\n  \"b\": \"<x&y>\"\n}\n"
+	for _, contents := range []string{
+		`{"b": "<x&y>", "a": [1, 2.50]}`,
+		"\n{\n\t\"a\": [1,2.50],\n\n\t\"b\":\"<x&y>\"\n}\n\n",
+	} {
+		normalized, ok := normalizeJSON([]byte(contents))
+		assert.True(t, ok)
+		assert.Equal(t, expected, string(normalized))
+	}
+
+	for _, raw := range []string{"server { listen 80; }\n", `{"a": 1} {"b": 2}`, ""} {
+		normalized, ok := normalizeJSON([]byte(raw))
+		assert.False(t, ok)
+		assert.Equal(t, raw, string(normalized), "invalid JSON is kept as is")
+	}
+}
+
+func TestGenerateFileNormalizeJSON(t *testing.T) {
+	dir := t.TempDir()
+	tmplPath := filepath.Join(dir, "test.tmpl")
+	err := os.WriteFile(tmplPath, []byte(`{ {{ range $i, $c := . }}{{ if $i }}, {{ end }}"{{ $c.Name }}": "{{ $c.ID }}"{{ end }} }`), 0644)
+	assert.NoError(t, err)
+
+	cfg := config.Config{Template: tmplPath, Dest: filepath.Join(dir, "out.json"), NormalizeJSON: true, IncludeStopped: true}
+	assert.True(t, GenerateFile(cfg, context.Context{{Name: "b", ID: "2"}, {Name: "a", ID: "1"}}))
+	contents, err := os.ReadFile(cfg.Dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"a\": \"1\",\n  \"b\": \"2\"\n}\n", string(contents))
+
+	// the same containers in another order render the same JSON
+	assert.False(t, GenerateFile(cfg, context.Context{{Name: "a", ID: "1"}, {Name: "b", ID: "2"}}))
+
+	// an output that is not JSON still has its blank lines removed
+	err = os.WriteFile(tmplPath, []byte("server {\n\n{{ range . }}  # {{ .Name }}\n\n{{ end }}}\n"), 0644)
+	assert.NoError(t, err)
+	assert.True(t, GenerateFile(cfg, context.Context{{Name: "a", ID: "1"}}))
+	contents, err = os.ReadFile(cfg.Dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "server {\n  # a\n}\n", string(contents))
+
+	cfg.KeepBlankLines = true
+	assert.True(t, GenerateFile(cfg, context.Context{{Name: "a", ID: "1"}}))
+	contents, err = os.ReadFile(cfg.Dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "server {\n\n  # a\n\n}\n", string(contents))
+}
@@ -1,8 +1,11 @@
 package template
 
 import (
+	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/nginx-proxy/docker-gen/internal/context"
@@ -82,6 +85,51 @@ func whereAll(entries interface{}, key, sep string, cmp []string) (interface{},
 	})
 }
 
+// whereConditions selects the entries whose values at the field paths of
+// conditions are equal to the condition values: all of them when all is set,
+// any of them otherwise. The conditions are evaluated in the order of their
+// field paths, stopping at the first one deciding the outcome.
+func whereConditions(funcName string, entries interface{}, conditions map[string]interface{}, all bool) (interface{}, error) {
+	entriesVal, err := getArrayValues(funcName, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(conditions))
+	for path := range conditions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	selection := make([]interface{}, 0)
+	for i := 0; i < entriesVal.Len(); i++ {
+		v := entriesVal.Index(i).Interface()
+
+		matched := all
+		for _, path := range paths {
+			if reflect.DeepEqual(deepGet(v, path), conditions[path]) != all {
+				matched = !all
+				break
+			}
+		}
+		if matched {
+			selection = append(selection, v)
+		}
+	}
+
+	return selection, nil
+}
+
+// selects entries matching every condition, a map of field paths to values
+func whereAllOf(entries interface{}, conditions map[string]interface{}) (interface{}, error) {
+	return whereConditions("whereAllOf", entries, conditions, true)
+}
+
+// selects entries matching at least one condition, a map of field paths to values
+func whereAnyOf(entries interface{}, conditions map[string]interface{}) (interface{}, error) {
+	return whereConditions("whereAnyOf", entries, conditions, false)
+}
+
 // generalized whereLabel function
 func generalizedWhereLabel(funcName string, containers context.Context, label string, test func(string, bool) bool) (context.Context, error) {
 	selection := make([]*context.RuntimeContainer, 0)
@@ -123,3 +171,128 @@ func whereLabelValueMatches(containers context.Context, label, pattern string) (
 		return ok && rx.MatchString(value)
 	})
 }
+
+// regexpCacheSize is the number of compiled regular expressions kept by compileRegexp
+const regexpCacheSize = 256
+
+// compiled regular expressions, keyed by pattern, shared across template executions
+var regexpCache = newLRUCache(regexpCacheSize)
+
+// compileRegexp returns the compiled pattern, compiling it only if it is not
+// one of the most recently used ones
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if rx, ok := regexpCache.get(pattern); ok {
+		return rx.(*regexp.Regexp), nil
+	}
+	rx, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexpCache.add(pattern, rx)
+	return rx, nil
+}
+
+// selects containers with a particular label whose value matches a regular expression,
+// the compiled expression being cached across calls
+func whereLabelMatches(containers context.Context, label, pattern string) (context.Context, error) {
+	rx, err := compileRegexp(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("whereLabelMatches: invalid pattern %q: %w", pattern, err)
+	}
+
+	return generalizedWhereLabel("whereLabelMatches", containers, label, func(value string, ok bool) bool {
+		return ok && rx.MatchString(value)
+	})
+}
+
+// selects containers that publish a particular host port, given as a number or a string
+func wherePort(containers context.Context, port interface{}) (context.Context, error) {
+	hostPort := fmt.Sprint(port)
+	selection := make([]*context.RuntimeContainer, 0)
+
+	for _, container := range containers {
+		for _, address := range container.Addresses {
+			if address.HostPort == hostPort {
+				selection = append(selection, container)
+				break
+			}
+		}
+	}
+
+	return selection, nil
+}
+
+// selects containers attached to the network with exactly the given name
+func whereNetworkExists(containers context.Context, name string) (context.Context, error) {
+	selection := make([]*context.RuntimeContainer, 0)
+
+	for _, container := range containers {
+		for _, network := range container.Networks {
+			if network.Name == name {
+				selection = append(selection, container)
+				break
+			}
+		}
+	}
+
+	return selection, nil
+}
+
+// selects containers that are usable backends: running, not paused, and
+// healthy if they have a healthcheck
+func healthy(containers context.Context) (context.Context, error) {
+	selection := make([]*context.RuntimeContainer, 0)
+
+	for _, container := range containers {
+		state := container.State
+		if state.Running && !state.Paused && (state.Health == "" || state.Health == "healthy") {
+			selection = append(selection, container)
+		}
+	}
+
+	return selection, nil
+}
+
+// defaultBackend returns the container designated as the default backend by
+// having label set to a true value, or nil if there is none. If several
+// containers are designated, the one with the lowest name is returned.
+func defaultBackend(containers context.Context, label string) *context.RuntimeContainer {
+	var backend *context.RuntimeContainer
+	for _, container := range containers {
+		if isDefault, err := strconv.ParseBool(container.Labels[label]); err != nil || !isDefault {
+			continue
+		}
+		if backend == nil || container.Name < backend.Name {
+			backend = container
+		}
+	}
+	return backend
+}
+
+// selects the containers of the first list whose ID is (or is not) in the second
+func selectByID(first, second context.Context, inSecond bool) context.Context {
+	ids := make(map[string]bool, len(second))
+	for _, container := range second {
+		ids[container.ID] = true
+	}
+
+	selection := make([]*context.RuntimeContainer, 0)
+	for _, container := range first {
+		if ids[container.ID] == inSecond {
+			selection = append(selection, container)
+		}
+	}
+	return selection
+}
+
+// difference returns the containers of the first list that are not in the
+// second one, compared by ID, in the order of the first list
+func difference(first, second context.Context) context.Context {
+	return selectByID(first, second, false)
+}
+
+// intersection returns the containers of the first list that are also in the
+// second one, compared by ID, in the order of the first list
+func intersection(first, second context.Context) context.Context {
+	return selectByID(first, second, true)
+}
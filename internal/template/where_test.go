@@ -1,9 +1,12 @@
 package template
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/nginx-proxy/docker-gen/internal/context"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestWhere(t *testing.T) {
@@ -292,6 +295,39 @@ func TestWhereRequires(t *testing.T) {
 	tests.run(t)
 }
 
+func TestWhereConditions(t *testing.T) {
+	containers := []*context.RuntimeContainer{
+		{
+			ID:     "1",
+			Labels: map[string]string{"com.example.tier": "web", "com.example.env": "prod"},
+			State:  context.State{Running: true},
+		},
+		{
+			ID:     "2",
+			Labels: map[string]string{"com.example.tier": "web", "com.example.env": "staging"},
+			State:  context.State{Running: true},
+		},
+		{
+			ID:     "3",
+			Labels: map[string]string{"com.example.tier": "db", "com.example.env": "prod"},
+		},
+	}
+
+	tests := templateTestList{
+		{`{{range whereAllOf . (dict "Labels.com.example.tier" "web" "Labels.com.example.env" "prod")}}{{.ID}}{{end}}`, containers, `1`},
+		{`{{range whereAllOf . (dict "Labels.com.example.tier" "web" "State.Running" true)}}{{.ID}}{{end}}`, containers, `12`},
+		{`{{range whereAnyOf . (dict "Labels.com.example.tier" "db" "Labels.com.example.env" "staging")}}{{.ID}}{{end}}`, containers, `23`},
+		{`{{whereAllOf . (dict "Labels.com.example.tier" "cache") | len}}`, containers, `0`},
+		{`{{whereAllOf . (dict "Labels.missing" "web") | len}}`, containers, `0`},
+		{`{{whereAllOf . (dict) | len}}`, containers, `3`},
+		{`{{whereAnyOf . (dict) | len}}`, containers, `0`},
+		{`{{range whereAnyOf (whereAllOf . (dict "State.Running" true)) (dict "Labels.com.example.env" "staging")}}{{.ID}}{{end}}`, containers, `2`},
+		{`{{whereAllOf "foo" (dict)}}`, containers, errors.New("")},
+	}
+
+	tests.run(t)
+}
+
 func TestWhereLabelExists(t *testing.T) {
 	containers := []*context.RuntimeContainer{
 		{
@@ -372,3 +408,163 @@ func TestWhereLabelValueMatches(t *testing.T) {
 
 	tests.run(t)
 }
+
+func TestWhereLabelMatches(t *testing.T) {
+	containers := []*context.RuntimeContainer{
+		{
+			Labels: map[string]string{
+				"com.example.route": "api-v1",
+			},
+			ID: "1",
+		},
+		{
+			Labels: map[string]string{
+				"com.example.route": "api-v12",
+			},
+			ID: "2",
+		},
+		{
+			Labels: map[string]string{
+				"com.example.route": "api-beta",
+			},
+			ID: "3",
+		},
+		{
+			ID: "4",
+		},
+	}
+
+	tests := templateTestList{
+		{`{{range whereLabelMatches . "com.example.route" "^api-v[0-9]+$"}}{{.ID}}{{end}}`, containers, `12`},
+		{`{{range whereLabelMatches . "com.example.route" "^api-v[0-9]+$"}}{{.ID}}{{end}}`, containers, `12`},
+		{`{{whereLabelMatches . "com.example.route" "^api-" | len}}`, containers, `3`},
+		{`{{whereLabelMatches . "com.example.missing" ".*" | len}}`, containers, `0`},
+	}
+
+	tests.run(t)
+
+	_, err := whereLabelMatches(containers, "com.example.route", "^api-(")
+	assert.ErrorContains(t, err, `"^api-("`)
+
+	for i := 0; i < regexpCacheSize+10; i++ {
+		_, err := compileRegexp(fmt.Sprintf("^api-v%d$", i))
+		assert.NoError(t, err)
+	}
+	assert.Len(t, regexpCache.entries, regexpCacheSize, "the compiled patterns are bounded")
+}
+
+func TestWhereNetworkExists(t *testing.T) {
+	containers := []*context.RuntimeContainer{
+		{ID: "1", Networks: []context.Network{{Name: "frontend"}, {Name: "backend"}}},
+		{ID: "2", Networks: []context.Network{{Name: "frontend-internal"}}},
+		{ID: "3", Networks: []context.Network{{Name: "backend"}}},
+		{ID: "4"},
+	}
+
+	tests := templateTestList{
+		{`{{range whereNetworkExists . "frontend"}}{{.ID}}{{end}}`, containers, `1`},
+		{`{{range whereNetworkExists . "backend"}}{{.ID}}{{end}}`, containers, `13`},
+		{`{{whereNetworkExists . "front" | len}}`, containers, `0`},
+	}
+
+	tests.run(t)
+}
+
+func TestWherePort(t *testing.T) {
+	containers := []*context.RuntimeContainer{
+		{
+			ID: "1",
+			Addresses: []context.Address{
+				{Port: "80", HostPort: "8080", Proto: "tcp"},
+				{Port: "443", HostPort: "443", Proto: "tcp"},
+			},
+		},
+		{
+			ID: "2",
+			Addresses: []context.Address{
+				{Port: "443", Proto: "tcp"},
+			},
+		},
+		{
+			ID: "3",
+		},
+	}
+
+	tests := templateTestList{
+		{`{{wherePort . 443 | len}}`, containers, `1`},
+		{`{{wherePort . "443" | len}}`, containers, `1`},
+		{`{{range wherePort . 8080}}{{.ID}}{{end}}`, containers, `1`},
+		{`{{wherePort . 80 | len}}`, containers, `0`},
+		{`{{wherePort . 9999 | len}}`, containers, `0`},
+	}
+
+	tests.run(t)
+}
+
+func TestHealthy(t *testing.T) {
+	containers := []*context.RuntimeContainer{
+		{ID: "1", State: context.State{Running: true}},
+		{ID: "2", State: context.State{Running: true, Health: "healthy"}},
+		{ID: "3", State: context.State{Running: true, Health: "unhealthy"}},
+		{ID: "4", State: context.State{Running: true, Health: "starting"}},
+		{ID: "5", State: context.State{Running: true, Paused: true}},
+		{ID: "6", State: context.State{Running: false, Health: "healthy"}},
+	}
+
+	tests := templateTestList{
+		{`{{range healthy .}}{{.ID}}{{end}}`, containers, `12`},
+		{`{{healthy . | len}}`, []*context.RuntimeContainer{}, `0`},
+	}
+
+	tests.run(t)
+}
+
+func TestDefaultBackend(t *testing.T) {
+	containers := []*context.RuntimeContainer{
+		{Name: "web", Labels: map[string]string{}},
+		{Name: "other", Labels: map[string]string{"proxy.default": "false"}},
+		{Name: "fallback-b", Labels: map[string]string{"proxy.default": "true"}},
+		{Name: "fallback-a", Labels: map[string]string{"proxy.default": "1"}},
+	}
+
+	assert.Equal(t, "fallback-a", defaultBackend(containers, "proxy.default").Name)
+	assert.Nil(t, defaultBackend(containers[:2], "proxy.default"))
+	assert.Nil(t, defaultBackend(containers, "missing"))
+
+	tests := templateTestList{
+		{`{{with defaultBackend . "proxy.default"}}{{.Name}}{{end}}`, containers, `fallback-a`},
+		{`{{with defaultBackend . "missing"}}{{.Name}}{{else}}none{{end}}`, containers, `none`},
+	}
+
+	tests.run(t)
+}
+
+func TestDifferenceAndIntersection(t *testing.T) {
+	containers := []*context.RuntimeContainer{
+		{ID: "3", Name: "c", Labels: map[string]string{"tagged": "true"}},
+		{ID: "1", Name: "a", Labels: map[string]string{}},
+		{ID: "2", Name: "b", Labels: map[string]string{"tagged": "true"}},
+		{ID: "4", Name: "d", Labels: map[string]string{}},
+	}
+	tagged := []*context.RuntimeContainer{containers[2], containers[0]}
+
+	names := func(containers context.Context) []string {
+		result := []string{}
+		for _, container := range containers {
+			result = append(result, container.Name)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"a", "d"}, names(difference(containers, tagged)))
+	assert.Equal(t, []string{"c", "b"}, names(intersection(containers, tagged)))
+	assert.Equal(t, []string{}, names(difference(tagged, containers)))
+	assert.Equal(t, []string{"c", "a", "b", "d"}, names(difference(containers, nil)))
+	assert.Equal(t, []string{}, names(intersection(containers, nil)))
+
+	tests := templateTestList{
+		{`{{range difference . (whereLabelExists . "tagged")}}{{.Name}}{{end}}`, containers, `ad`},
+		{`{{range intersection . (whereLabelExists . "tagged")}}{{.Name}}{{end}}`, containers, `cb`},
+	}
+
+	tests.run(t)
+}
@@ -16,6 +16,8 @@ func TestSplitKeyValueSlice(t *testing.T) {
 		{[]string{"K="}, ""},
 		{[]string{"K=V3"}, "V3"},
 		{[]string{"K=V4=V5"}, "V4=V5"},
+		{[]string{"K=a=b=c"}, "a=b=c"},
+		{[]string{"K=postgres://user:pass@db/app?sslmode=disable&x=="}, "postgres://user:pass@db/app?sslmode=disable&x=="},
 	}
 
 	for _, i := range tests {
@@ -27,6 +29,16 @@ func TestSplitKeyValueSlice(t *testing.T) {
 	}
 }
 
+func TestSplitKeyValueSliceEntries(t *testing.T) {
+	env := SplitKeyValueSlice([]string{"KEY=", "BARE", "DSN=a=b=c", "=nokey"})
+	assert.Equal(t, map[string]string{
+		"KEY":  "",
+		"BARE": "",
+		"DSN":  "a=b=c",
+		"":     "nokey",
+	}, env)
+}
+
 func TestPathExists(t *testing.T) {
 	file, err := os.CreateTemp("", "test")
 	if err != nil {